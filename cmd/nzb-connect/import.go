@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nzb-connect/internal/config"
+	"nzb-connect/internal/vpn"
+)
+
+// importVPN implements "nzb-connect import-vpn [-config file] [-name name]
+// profile", which adds a wg-quick .conf or .ovpn file to the VPN profiles in
+// the config file. Files the profile refers to are read relative to it.
+func importVPN(args []string) int {
+	fs := flag.NewFlagSet("import-vpn", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "path to config file")
+	name := fs.String("name", "", "profile name; an existing profile of that name is replaced (default: the file name)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nzb-connect import-vpn [-config file] [-name name] profile.conf|profile.ovpn")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dir := filepath.Dir(path)
+	profile, err := vpn.ImportProfile(data, func(file string) ([]byte, error) {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	vpnCfg := cfg.GetVPN()
+	profile.Apply(&vpnCfg, *name)
+	cfg.SetVPN(vpnCfg)
+	if err := cfg.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Imported %s (%s) into %s\n", *name, profile.Protocol, *configPath)
+	for _, s := range profile.Ignored {
+		fmt.Printf("  ignored %s\n", s)
+	}
+	if profile.OpenVPN != nil && profile.OpenVPN.AuthType == "userpass" && profile.OpenVPN.Username == "" {
+		fmt.Println("Set vpn.openvpn.username and password, or enter them in the web UI.")
+	}
+	return 0
+}
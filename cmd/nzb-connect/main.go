@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"nzb-connect/internal/api"
 	"nzb-connect/internal/config"
 	"nzb-connect/internal/downloader"
+	"nzb-connect/internal/events"
 	"nzb-connect/internal/postprocess"
 	"nzb-connect/internal/queue"
 	"nzb-connect/internal/vpn"
@@ -23,9 +25,27 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import-vpn" {
+		os.Exit(importVPN(os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "config.yaml", "path to config file")
+	printKillSwitch := flag.Bool("print-killswitch", false, "print the nftables kill switch rules for the VPN config and exit")
 	flag.Parse()
 
+	if *printKillSwitch {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		rules, err := vpn.KillSwitchRuleset(cfg.GetVPN())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(rules)
+		return
+	}
+
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Println("NZB Connect starting...")
 
@@ -35,6 +55,9 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 	log.Printf("Config loaded from %s", *configPath)
+	if err := cfg.EnsureKeys(); err != nil {
+		log.Fatalf("Failed to generate API keys: %v", err)
+	}
 
 	// Ensure directories exist
 	if err := cfg.EnsureDirectories(); err != nil {
@@ -50,21 +73,42 @@ func main() {
 	defer queueMgr.Close()
 	config.ChownToRealUser(dbPath) // ensure DB is accessible without sudo
 
+	// Queue, progress and VPN changes are pushed to the web UI through this
+	bus := events.NewBus()
+	queueMgr.SetEvents(bus)
+
 	// Initialize connection pool manager (interface set later by VPN manager)
 	poolMgr := downloader.NewPoolManager("")
 	poolMgr.UpdateServers(cfg.GetServers())
 
 	// Initialize download engine
 	engine := downloader.NewEngine(poolMgr, queueMgr, cfg.Paths.Incomplete, cfg.Paths.Temp)
+	engine.SetMaxJobs(cfg.Downloads.MaxJobs)
+	engine.SetPreflight(cfg.GetPreflight())
+	engine.SetEvents(bus)
 
 	// Initialize post-processor
 	proc := postprocess.NewProcessor(cfg, queueMgr)
+	proc.OnNeedRecovery(engine.QueueRecoveryBlocks)
+	proc.SetEvents(bus)
 	engine.OnComplete(func(dl *queue.Download) {
 		go proc.Process(dl)
 	})
 
+	// Pick up jobs interrupted by a crash or restart: downloads are requeued
+	// (the engine skips segments already on disk) and post-processing reruns.
+	interrupted, err := queueMgr.RecoverInterrupted()
+	if err != nil {
+		log.Printf("Failed to recover interrupted downloads: %v", err)
+	}
+	for _, dl := range interrupted {
+		log.Printf("Resuming post-processing for %s", dl.Name)
+		go proc.Process(dl)
+	}
+
 	// Initialize VPN manager
 	vpnMgr := vpn.NewManager(cfg)
+	vpnMgr.SetEvents(bus)
 	vpnMgr.OnDown(func() {
 		log.Println("VPN down — pausing downloads and closing connections")
 		queueMgr.SetPaused(true)
@@ -72,7 +116,7 @@ func main() {
 	})
 	vpnMgr.OnUp(func(interfaceName string) {
 		log.Printf("VPN up on %s — resuming downloads", interfaceName)
-		poolMgr.SetVPNInterface(interfaceName)
+		poolMgr.SetTunnel(interfaceName, vpnMgr.Dialer(), vpnMgr.TunnelResolver(interfaceName))
 		poolMgr.UpdateServers(cfg.GetServers())
 		queueMgr.SetPaused(false)
 		engine.Notify()
@@ -81,6 +125,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Nothing downloads until the VPN is up and, with leak_check_url set, its
+	// first leak check has passed; the OnUp callback releases the queue
+	queueMgr.SetPaused(true)
 	vpnMgr.Start(ctx)
 	defer vpnMgr.Stop()
 
@@ -97,6 +144,7 @@ func main() {
 		Engine:   engine,
 		VPNMgr:   vpnMgr,
 		PoolMgr:  poolMgr,
+		Events:   bus,
 	}
 	handler.RegisterRoutes(mux)
 
@@ -108,12 +156,16 @@ func main() {
 	mux.Handle("/", http.FileServer(http.FS(distFS)))
 
 	addr := fmt.Sprintf(":%d", cfg.Web.Port)
+	// Requests are cancelled on shutdown, so open event streams don't hold it up
+	reqCtx, cancelRequests := context.WithCancel(context.Background())
 	srv := &http.Server{
 		Addr:         addr,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return reqCtx },
 	}
+	srv.RegisterOnShutdown(cancelRequests)
 
 	// Graceful shutdown
 	go func() {
@@ -129,11 +181,10 @@ func main() {
 	log.Printf("Web UI listening on http://0.0.0.0%s", addr)
 	if vpnMgr.IsUp() {
 		log.Printf("VPN interface %s is UP", vpnMgr.InterfaceName())
-	} else if cfg.VPN.Protocol != "" {
-		log.Printf("VPN managed mode (%s) — connection in progress", cfg.VPN.Protocol)
+	} else if vpnMgr.IsManaged() {
+		log.Printf("VPN managed mode (%s) — connection in progress", vpnMgr.ActiveProfile())
 	} else {
 		log.Printf("WARNING: VPN interface %s is DOWN - downloads paused", cfg.VPN.Interface)
-		queueMgr.SetPaused(true)
 	}
 
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Authentication, modelled on SABnzbd:
+//
+//   - /api (the SABnzbd API) needs the API key, or the NZB key for the modes
+//     that add NZBs. Requests from the web UI are let through without one.
+//   - The REST routes used by the web UI (/api/servers, /api/vpn, ...) need a
+//     web UI session, HTTP basic auth with the web login, or the API key.
+//
+// A request comes from the web UI if it carries a valid session cookie, and
+// only logging in creates one: with the web login if one is configured,
+// otherwise with the API key. The cookie is SameSite=Strict, so other sites
+// can't make requests with it.
+
+const (
+	sessionCookie = "nzbconnect_session"
+	sessionTTL    = 7 * 24 * time.Hour
+)
+
+// loginDelay is how long a failed login waits before answering; a variable
+// so tests don't have to.
+var loginDelay = time.Second
+
+// sessionStore holds web UI sessions in memory; a restart logs everyone out.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]time.Time // token → expiry
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]time.Time)}
+}
+
+func (s *sessionStore) create() string {
+	token := generateID() + generateID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[token] = time.Now().Add(sessionTTL)
+	return token
+}
+
+// valid reports whether token is a live session, extending it if so.
+func (s *sessionStore) valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, exp := range s.sessions {
+		if now.After(exp) {
+			delete(s.sessions, t)
+		}
+	}
+	if _, ok := s.sessions[token]; !ok {
+		return false
+	}
+	s.sessions[token] = now.Add(sessionTTL)
+	return true
+}
+
+func (s *sessionStore) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+func equalKey(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// hasWebLogin reports whether a web login is configured.
+func (h *Handler) hasWebLogin() bool {
+	return h.Config.GetWeb().Username != ""
+}
+
+// fromWebUI reports whether r comes from a logged-in web UI.
+func (h *Handler) fromWebUI(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	return err == nil && h.sessions.valid(c.Value)
+}
+
+// checkLogin reports whether username and password log in to the web UI:
+// the web login if one is configured, otherwise any username with the API
+// key as the password.
+func (h *Handler) checkLogin(username, password string) bool {
+	web := h.Config.GetWeb()
+	if web.Username == "" {
+		return equalKey(password, web.APIKey)
+	}
+	return equalKey(username, web.Username) && equalKey(password, web.Password)
+}
+
+// checkBasicAuth reports whether r carries the web login as basic auth.
+func (h *Handler) checkBasicAuth(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	web := h.Config.GetWeb()
+	return ok && web.Username != "" && equalKey(user, web.Username) && equalKey(pass, web.Password)
+}
+
+// apiKey returns the key sent with r, as the apikey parameter or the
+// X-Api-Key header.
+func apiKey(r *http.Request) string {
+	if key := r.FormValue("apikey"); key != "" {
+		return key
+	}
+	return r.Header.Get("X-Api-Key")
+}
+
+// nzbKeyModes are the SABnzbd API modes the NZB key may use.
+var nzbKeyModes = map[string]bool{"addfile": true, "addurl": true, "": true}
+
+// checkSABKey returns the SABnzbd error for a request to /api in mode, or ""
+// if it may go ahead.
+func (h *Handler) checkSABKey(r *http.Request, mode string) string {
+	if mode == "version" || mode == "auth" || h.fromWebUI(r) {
+		return ""
+	}
+	key := apiKey(r)
+	web := h.Config.GetWeb()
+	switch {
+	case key == "":
+		return "API Key Required"
+	case equalKey(key, web.APIKey):
+		return ""
+	case equalKey(key, web.NZBKey) && nzbKeyModes[mode]:
+		return ""
+	default:
+		return "API Key Incorrect"
+	}
+}
+
+// sabAuthMode answers mode=auth with the kind of key the request carries.
+func (h *Handler) sabAuthMode(w http.ResponseWriter, r *http.Request) {
+	web := h.Config.GetWeb()
+	key := apiKey(r)
+	auth := "badkey"
+	switch {
+	case h.fromWebUI(r):
+		auth = "apikey"
+	case equalKey(key, web.APIKey):
+		auth = "apikey"
+	case equalKey(key, web.NZBKey):
+		auth = "nzbkey"
+	}
+	writeJSON(w, map[string]interface{}{"auth": auth})
+}
+
+// requireLogin wraps a REST handler so that it needs a web UI session, basic
+// auth or the API key.
+func (h *Handler) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.fromWebUI(r) || h.checkBasicAuth(r) || equalKey(r.Header.Get("X-Api-Key"), h.Config.GetWeb().APIKey) {
+			next(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": false, "error": "login required"})
+	}
+}
+
+// handleAuthStatus handles GET /api/auth/status. login is "password" when
+// the UI should ask for the web login and "apikey" when it should ask for the
+// API key.
+func (h *Handler) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	login := "apikey"
+	if h.hasWebLogin() {
+		login = "password"
+	}
+	writeJSON(w, map[string]interface{}{
+		"login":         login,
+		"authenticated": h.fromWebUI(r),
+	})
+}
+
+// handleLogin handles POST /api/auth/login with {"username", "password"}, the
+// password being the API key when no web login is configured.
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "invalid JSON"})
+		return
+	}
+	if !h.checkLogin(req.Username, req.Password) {
+		log.Printf("Failed web login for %q from %s", req.Username, r.RemoteAddr)
+		time.Sleep(loginDelay) // slow down guessing
+		msg := "invalid username or password"
+		if !h.hasWebLogin() {
+			msg = "invalid API key"
+		}
+		writeJSON(w, map[string]interface{}{"status": false, "error": msg})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    h.sessions.create(),
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+// handleLogout handles POST /api/auth/logout.
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		h.sessions.remove(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+// handleKeys handles:
+//
+//	GET  /api/auth/keys    show the API and NZB keys
+//	POST /api/auth/keys    rotate one ({"key": "apikey"} or {"key": "nzbkey"})
+func (h *Handler) handleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		web := h.Config.GetWeb()
+		writeJSON(w, map[string]interface{}{"apikey": web.APIKey, "nzbkey": web.NZBKey})
+	case http.MethodPost:
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, map[string]interface{}{"status": false, "error": "invalid JSON"})
+			return
+		}
+		key, err := h.Config.RotateKey(req.Key)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+			return
+		}
+		if err := h.Config.Save(); err != nil {
+			writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+			return
+		}
+		log.Printf("Rotated %s", req.Key)
+		writeJSON(w, map[string]interface{}{"status": true, req.Key: key})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
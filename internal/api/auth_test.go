@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nzb-connect/internal/config"
+)
+
+const (
+	testAPIKey = "0123456789abcdef0123456789abcdef"
+	testNZBKey = "fedcba9876543210fedcba9876543210"
+)
+
+// newAuthHandler returns a Handler with the test keys and, if username is
+// set, a web login.
+func newAuthHandler(username, password string) *Handler {
+	return &Handler{
+		Config: &config.Config{Web: config.WebConfig{
+			Username: username,
+			Password: password,
+			APIKey:   testAPIKey,
+			NZBKey:   testNZBKey,
+		}},
+		sessions: newSessionStore(),
+	}
+}
+
+func TestCheckSABKey(t *testing.T) {
+	h := newAuthHandler("", "")
+	session := h.sessions.create()
+
+	tests := []struct {
+		name   string
+		mode   string
+		key    string
+		header map[string]string
+		cookie string
+		want   string
+	}{
+		{name: "no key", mode: "queue", want: "API Key Required"},
+		{name: "wrong key", mode: "queue", key: "nope", want: "API Key Incorrect"},
+		{name: "api key", mode: "queue", key: testAPIKey},
+		{name: "api key header", mode: "queue", header: map[string]string{"X-Api-Key": testAPIKey}},
+		{name: "version needs no key", mode: "version"},
+		{name: "auth needs no key", mode: "auth"},
+		{name: "nzb key addurl", mode: "addurl", key: testNZBKey},
+		{name: "nzb key addfile", mode: "addfile", key: testNZBKey},
+		{name: "nzb key no mode", mode: "", key: testNZBKey},
+		{name: "nzb key queue", mode: "queue", key: testNZBKey, want: "API Key Incorrect"},
+		{name: "nzb key config", mode: "config", key: testNZBKey, want: "API Key Incorrect"},
+		{name: "nzb key history", mode: "history", key: testNZBKey, want: "API Key Incorrect"},
+		{name: "session", mode: "queue", cookie: session},
+		{name: "stale session", mode: "queue", cookie: "stale", want: "API Key Required"},
+		{name: "header is not a login", mode: "queue", header: map[string]string{"X-Requested-With": "XMLHttpRequest"}, want: "API Key Required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := "/api?mode=" + tt.mode
+			if tt.key != "" {
+				url += "&apikey=" + tt.key
+			}
+			r := httptest.NewRequest(http.MethodGet, url, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+			}
+			if got := h.checkSABKey(r, tt.mode); got != tt.want {
+				t.Errorf("checkSABKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	for _, username := range []string{"", "admin"} {
+		h := newAuthHandler(username, "secret")
+		session := h.sessions.create()
+		guarded := h.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		// Basic auth only works with a web login.
+		basicAuth := http.StatusUnauthorized
+		if username != "" {
+			basicAuth = http.StatusOK
+		}
+
+		tests := []struct {
+			name string
+			req  func(r *http.Request)
+			want int
+		}{
+			{"nothing", func(r *http.Request) {}, http.StatusUnauthorized},
+			{"X-Requested-With", func(r *http.Request) { r.Header.Set("X-Requested-With", "XMLHttpRequest") }, http.StatusUnauthorized},
+			{"nzb key", func(r *http.Request) { r.Header.Set("X-Api-Key", testNZBKey) }, http.StatusUnauthorized},
+			{"api key", func(r *http.Request) { r.Header.Set("X-Api-Key", testAPIKey) }, http.StatusOK},
+			{"session", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: session}) }, http.StatusOK},
+			{"bad basic auth", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+			{"basic auth", func(r *http.Request) { r.SetBasicAuth(username, "secret") }, basicAuth},
+		}
+		for _, tt := range tests {
+			t.Run("user="+username+"/"+tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/api/servers", nil)
+				tt.req(r)
+				w := httptest.NewRecorder()
+				guarded(w, r)
+				if w.Code != tt.want {
+					t.Errorf("status = %d, want %d", w.Code, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	loginDelay = 0
+	tests := []struct {
+		name     string
+		username string
+		body     string
+		ok       bool
+	}{
+		{"api key without web login", "", `{"password":"` + testAPIKey + `"}`, true},
+		{"nzb key without web login", "", `{"password":"` + testNZBKey + `"}`, false},
+		{"empty without web login", "", `{}`, false},
+		{"web login", "admin", `{"username":"admin","password":"secret"}`, true},
+		{"wrong password", "admin", `{"username":"admin","password":"nope"}`, false},
+		{"api key with web login", "admin", `{"password":"` + testAPIKey + `"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAuthHandler(tt.username, "secret")
+			w := httptest.NewRecorder()
+			h.handleLogin(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(tt.body)))
+
+			var session *http.Cookie
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessionCookie {
+					session = c
+				}
+			}
+			if (session != nil) != tt.ok {
+				t.Fatalf("session cookie set = %v, want %v (body %s)", session != nil, tt.ok, w.Body)
+			}
+			if !tt.ok {
+				return
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api?mode=queue", nil)
+			r.AddCookie(session)
+			if msg := h.checkSABKey(r, "queue"); msg != "" {
+				t.Errorf("checkSABKey with the new session = %q", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment, so proxies
+// don't time it out.
+const sseKeepAlive = 15 * time.Second
+
+// handleEvents handles GET /api/events, a Server-Sent Events stream of the
+// event bus. Each event is sent as
+//
+//	event: <type>
+//	data: <JSON>
+//
+// The stream ends if the client falls too far behind; EventSource then
+// reconnects, and the client should reload its state when it does.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Events == nil {
+		http.Error(w, "events not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The server's write timeout would cut the stream off
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Event stream: clearing write deadline: %v", err)
+	}
+
+	ch, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return // fell behind
+			}
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				log.Printf("Event stream: encoding %s event: %v", ev.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
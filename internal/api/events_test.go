@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/events"
+)
+
+func TestHandleEvents(t *testing.T) {
+	h := newAuthHandler("", "")
+	h.Events = events.NewBus()
+
+	returned := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(returned)
+		h.handleEvents(w, r)
+	}))
+	// Shorter than the wait below, so the stream only survives if the
+	// handler clears the deadline
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	for header, want := range map[string]string{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"X-Accel-Buffering": "no",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	r := bufio.NewReader(resp.Body)
+	// readEvent reads up to and including the blank line ending an event.
+	readEvent := func() string {
+		t.Helper()
+		var b strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading stream after %q: %v", b.String(), err)
+			}
+			b.WriteString(line)
+			if line == "\n" {
+				return b.String()
+			}
+		}
+	}
+
+	if got := readEvent(); got != "retry: 3000\n\n" {
+		t.Errorf("first message = %q", got)
+	}
+
+	time.Sleep(3 * srv.Config.WriteTimeout)
+	h.Events.Publish(events.Status, map[string]bool{"paused": true})
+	if got, want := readEvent(), "event: status\ndata: {\"paused\":true}\n\n"; got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after the request was cancelled")
+	}
+}
+
+func TestHandleEventsUnavailable(t *testing.T) {
+	h := newAuthHandler("", "")
+	for _, tc := range []struct {
+		name   string
+		method string
+		bus    *events.Bus
+		want   int
+	}{
+		{"post", http.MethodPost, events.NewBus(), http.StatusMethodNotAllowed},
+		{"no bus", http.MethodGet, nil, http.StatusServiceUnavailable},
+	} {
+		h.Events = tc.bus
+		w := httptest.NewRecorder()
+		h.handleEvents(w, httptest.NewRequest(tc.method, "/api/events", nil))
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"nzb-connect/internal/vpn"
+)
+
+// maxNZBSize is the largest NZB accepted from a URL, after decompression.
+const maxNZBSize = 100 << 20
+
+// errVPNDown is returned instead of fetching over the host's normal route.
+var errVPNDown = errors.New("VPN is down — not fetching the NZB outside the tunnel")
+
+// httpClient returns the client for outbound fetches: bound to the VPN
+// interface whenever one is configured, so indexers only ever see the VPN's
+// address. It fails while the VPN is down, or has no interface or dialer to
+// reach the tunnel with, rather than fall back to the normal route. Managed
+// connectors have no interface while they are down, so that alone doesn't
+// mean no VPN is configured.
+func (h *Handler) httpClient() (*http.Client, error) {
+	var iface string
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	if h.VPNMgr != nil {
+		iface = h.VPNMgr.InterfaceName()
+		dial = h.VPNMgr.Dialer()
+		if h.VPNMgr.IsManaged() || iface != "" {
+			if !h.VPNMgr.IsUp() || (iface == "" && dial == nil) {
+				return nil, errVPNDown
+			}
+		}
+	}
+	h.clientMu.Lock()
+	defer h.clientMu.Unlock()
+	if h.client == nil || h.clientIface != iface {
+		if h.client != nil {
+			h.client.CloseIdleConnections()
+		}
+		if dial != nil {
+			h.client = vpn.NewHTTPClientWith(dial)
+		} else {
+			h.client = vpn.NewHTTPClient(iface)
+		}
+		h.clientIface = iface
+	}
+	return h.client, nil
+}
+
+// fetchNZB downloads an NZB from a URL and returns it with a job name taken
+// from the Content-Disposition header or, failing that, the URL. Gzipped
+// NZBs are decompressed.
+func (h *Handler) fetchNZB(nzbURL string) (string, []byte, error) {
+	u, err := url.Parse(nzbURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", nil, fmt.Errorf("invalid URL %q", nzbURL)
+	}
+	client, err := h.httpClient()
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := client.Get(nzbURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("download error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("download error: %s", resp.Status)
+	}
+
+	data, err := readLimited(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	// .nzb.gz files, or a server that compresses without saying so. Go
+	// already undoes Content-Encoding: gzip when it asked for it.
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", nil, fmt.Errorf("decompressing NZB: %v", err)
+		}
+		if data, err = readLimited(zr); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return nzbName(resp, u), data, nil
+}
+
+// readLimited reads r up to maxNZBSize.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxNZBSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read error: %v", err)
+	}
+	if len(data) > maxNZBSize {
+		return nil, fmt.Errorf("NZB larger than %d MB", maxNZBSize>>20)
+	}
+	return data, nil
+}
+
+// nzbName returns the job name for a downloaded NZB.
+func nzbName(resp *http.Response, u *url.URL) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = u.Path
+	}
+	// Never trust a path from the server; the name becomes a directory
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".nzb")
+	if name == "" || name == "." || name == "/" || name == ".." {
+		name = "download"
+	}
+	return name
+}
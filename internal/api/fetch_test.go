@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"nzb-connect/internal/config"
+	"nzb-connect/internal/vpn"
+)
+
+func TestHTTPClientWithoutVPN(t *testing.T) {
+	h := &Handler{Config: &config.Config{}}
+	if _, err := h.httpClient(); err != nil {
+		t.Fatalf("httpClient without a VPN manager: %v", err)
+	}
+
+	mgr := vpn.NewManager(h.Config)
+	mgr.Start(context.Background())
+	defer mgr.Stop()
+	h.VPNMgr = mgr
+	if _, err := h.httpClient(); err != nil {
+		t.Fatalf("httpClient with no VPN configured: %v", err)
+	}
+}
+
+func TestHTTPClientRefusesWhileVPNDown(t *testing.T) {
+	off := false
+	tests := []struct {
+		name string
+		vpn  config.VPNConfig
+	}{
+		// A managed tunnel that isn't connected has no interface name
+		{"managed", config.VPNConfig{
+			Protocol:    "wireguard",
+			WireGuard:   &config.WireGuardConfig{},
+			AutoConnect: &off,
+		}},
+		{"passive", config.VPNConfig{Interface: "nzbtest-absent0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{VPN: tt.vpn}
+			mgr := vpn.NewManager(cfg)
+			mgr.Start(context.Background())
+			defer mgr.Stop()
+
+			h := &Handler{Config: cfg, VPNMgr: mgr}
+			if _, err := h.httpClient(); !errors.Is(err, errVPNDown) {
+				t.Fatalf("httpClient = %v, want errVPNDown", err)
+			}
+			if _, _, err := h.fetchNZB("http://indexer.invalid/get.nzb"); !errors.Is(err, errVPNDown) {
+				t.Fatalf("fetchNZB = %v, want errVPNDown", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,623 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nzb-connect/internal/queue"
+)
+
+// NZBGet-compatible JSON-RPC (/jsonrpc) and XML-RPC (/xmlrpc) API. It works
+// on the same queue and engine as the SABnzbd API, so both show the same
+// state. NZBGet identifies downloads by number; Download.Num is used as the
+// NZBID.
+
+// nzbgetVersion is the NZBGet version reported to clients.
+const nzbgetVersion = "21.1"
+
+// started is when the process started, for the status uptime.
+var started = time.Now()
+
+// nzbgetAddOnlyMethods are the methods the NZB key may call.
+var nzbgetAddOnlyMethods = map[string]bool{"version": true, "append": true}
+
+// nzbgetAccess returns whether r may call the NZBGet API and whether it is
+// limited to adding NZBs. NZBGet clients authenticate with HTTP basic auth;
+// the password may be the web login's, the API key or the NZB key.
+func (h *Handler) nzbgetAccess(r *http.Request) (ok, addOnly bool) {
+	if h.fromWebUI(r) || h.checkBasicAuth(r) {
+		return true, false
+	}
+	_, pass, _ := r.BasicAuth()
+	web := h.Config.GetWeb()
+	switch {
+	case equalKey(pass, web.APIKey):
+		return true, false
+	case equalKey(pass, web.NZBKey):
+		return true, true
+	}
+	return false, false
+}
+
+// handleJSONRPC handles POST /jsonrpc.
+func (h *Handler) handleJSONRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ok, addOnly := h.nzbgetAccess(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="NZBGet"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		ID     interface{}   `json:"id"`
+	}
+	resp := map[string]interface{}{"version": "1.1"}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		resp["error"] = map[string]interface{}{"name": "JSONRPCError", "code": 1, "message": "invalid JSON-RPC request"}
+		writeJSON(w, resp)
+		return
+	}
+	resp["id"] = req.ID
+	result, err := h.nzbgetCall(req.Method, req.Params, addOnly)
+	if err != nil {
+		resp["error"] = map[string]interface{}{"name": "JSONRPCError", "code": 1, "message": err.Error()}
+	} else {
+		resp["result"] = result
+	}
+	writeJSON(w, resp)
+}
+
+// handleXMLRPC handles POST /xmlrpc.
+func (h *Handler) handleXMLRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ok, addOnly := h.nzbgetAccess(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="NZBGet"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	method, params, err := decodeXMLRPCCall(r.Body)
+	if err != nil {
+		encodeXMLRPCFault(w, 1, err.Error())
+		return
+	}
+	result, err := h.nzbgetCall(method, params, addOnly)
+	if err != nil {
+		encodeXMLRPCFault(w, 1, err.Error())
+		return
+	}
+	if err := encodeXMLRPCResponse(w, result); err != nil {
+		log.Printf("Error encoding XML-RPC response for %s: %v", method, err)
+	}
+}
+
+// nzbgetCall runs one NZBGet API method.
+func (h *Handler) nzbgetCall(method string, params []interface{}, addOnly bool) (interface{}, error) {
+	if addOnly && !nzbgetAddOnlyMethods[method] {
+		return nil, fmt.Errorf("access denied")
+	}
+	switch method {
+	case "version":
+		return nzbgetVersion, nil
+	case "append":
+		return h.nzbgetAppend(params)
+	case "listgroups":
+		return h.nzbgetListGroups()
+	case "history":
+		return h.nzbgetHistory()
+	case "status":
+		return h.nzbgetStatus()
+	case "editqueue":
+		return h.nzbgetEditQueue(params)
+	case "config":
+		return h.nzbgetConfig(), nil
+	case "pausedownload":
+		h.Engine.Pause()
+		return true, nil
+	case "resumedownload":
+		h.Engine.Resume()
+		return true, nil
+	case "rate":
+		h.Engine.SetSpeedLimit(int64(argInt(params, 0)) * 1024)
+		return true, nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", method)
+	}
+}
+
+// Parameter helpers. Missing or mistyped parameters read as zero values.
+
+func argString(p []interface{}, i int) string {
+	if i < len(p) {
+		if s, ok := p[i].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func argInt(p []interface{}, i int) int {
+	if i < len(p) {
+		switch v := p[i].(type) {
+		case float64:
+			return int(v)
+		case string:
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
+
+func argBool(p []interface{}, i int) bool {
+	if i < len(p) {
+		b, _ := p[i].(bool)
+		return b
+	}
+	return false
+}
+
+// nzbgetToPriority maps an NZBGet priority (-100 very low … 100 very high,
+// 900 force) to a queue priority.
+func nzbgetToPriority(p int) int {
+	switch {
+	case p >= 900:
+		return queue.PriorityForce
+	case p > 0:
+		return queue.PriorityHigh
+	case p < 0:
+		return queue.PriorityLow
+	default:
+		return queue.PriorityNormal
+	}
+}
+
+func priorityToNZBGet(p int) int {
+	switch p {
+	case queue.PriorityForce:
+		return 900
+	case queue.PriorityHigh:
+		return 50
+	case queue.PriorityLow:
+		return -50
+	default:
+		return 0
+	}
+}
+
+// nzbgetAppend implements append. Two signatures are in use:
+//
+//	v16+:  NZBFilename, Content, Category, Priority, AddToTop, AddPaused,
+//	       DupeKey, DupeScore, DupeMode, PPParameters
+//	v13+:  NZBFilename, Category, Priority, AddToTop, Content, AddPaused, ...
+//
+// Content is the NZB in base64 or a URL to fetch it from. It returns the
+// NZBID, or 0 if the NZB couldn't be added.
+func (h *Handler) nzbgetAppend(p []interface{}) (interface{}, error) {
+	var filename, content, category string
+	var priority int
+	var addToTop, addPaused bool
+	var ppParams []interface{}
+	if _, old := safeIndex(p, 3).(bool); old {
+		filename, category, priority = argString(p, 0), argString(p, 1), argInt(p, 2)
+		addToTop, content, addPaused = argBool(p, 3), argString(p, 4), argBool(p, 5)
+	} else {
+		filename, content, category = argString(p, 0), argString(p, 1), argString(p, 2)
+		priority, addToTop, addPaused = argInt(p, 3), argBool(p, 4), argBool(p, 5)
+		ppParams, _ = safeIndex(p, 9).([]interface{})
+	}
+
+	name := strings.TrimSuffix(filename, ".nzb")
+	var data []byte
+	if strings.HasPrefix(content, "http://") || strings.HasPrefix(content, "https://") {
+		urlName, fetched, err := h.fetchNZB(content)
+		if err != nil {
+			log.Printf("NZBGet append %s: %v", filename, err)
+			return 0, nil
+		}
+		data = fetched
+		if name == "" {
+			name = urlName
+		}
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("content is neither base64 nor a URL")
+		}
+		data = decoded
+	}
+	if name == "" {
+		name = "download"
+	}
+
+	id, err := h.addDownload(name, category, nzbgetToPriority(priority), data)
+	if err != nil {
+		log.Printf("NZBGet append %s: %v", filename, err)
+		return 0, nil
+	}
+
+	params := make(map[string]string)
+	for _, pp := range ppParams {
+		if m, ok := pp.(map[string]interface{}); ok {
+			if n, _ := m["Name"].(string); n != "" {
+				params[n] = fmt.Sprint(m["Value"])
+			}
+		}
+	}
+	if err := h.QueueMgr.SetParams(id, params); err != nil {
+		log.Printf("Error saving parameters for %s: %v", name, err)
+	}
+	if addToTop {
+		if err := h.moveToTopOfPriority(id, nzbgetToPriority(priority)); err != nil {
+			log.Printf("Error moving %s to the top: %v", name, err)
+		}
+	}
+	if addPaused {
+		if err := h.Engine.PauseDownload(id); err != nil {
+			log.Printf("Error pausing %s: %v", name, err)
+		}
+	}
+
+	dl, err := h.QueueMgr.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return dl.Num, nil
+}
+
+// moveToTopOfPriority moves a download to the top of the downloads with the
+// same priority. Move takes the priority of its new neighbour, so it is put
+// back afterwards; its position is then the lowest, which keeps it first.
+func (h *Handler) moveToTopOfPriority(id string, priority int) error {
+	moved, err := h.QueueMgr.Move(id, 0)
+	if err != nil || moved == priority {
+		return err
+	}
+	_, err = h.QueueMgr.SetPriority(id, priority)
+	return err
+}
+
+func safeIndex(p []interface{}, i int) interface{} {
+	if i < len(p) {
+		return p[i]
+	}
+	return nil
+}
+
+// nzbgetSize adds NZBGet's split 32-bit and MB fields for a size to m.
+func nzbgetSize(m map[string]interface{}, prefix string, n int64) {
+	m[prefix+"Lo"] = n & 0xffffffff
+	m[prefix+"Hi"] = n >> 32
+	m[prefix+"MB"] = n / (1024 * 1024)
+}
+
+// nzbgetParams returns a download's parameters as NZBGet's Parameters list.
+func (h *Handler) nzbgetParams(id string) []interface{} {
+	params, err := h.QueueMgr.GetParams(id)
+	if err != nil {
+		log.Printf("Error loading parameters for %s: %v", id, err)
+	}
+	list := []interface{}{}
+	for name, value := range params {
+		list = append(list, map[string]interface{}{"Name": name, "Value": value})
+	}
+	return list
+}
+
+func nzbgetQueueStatus(dl *queue.Download) string {
+	switch dl.Status {
+	case queue.StatusPaused:
+		return "PAUSED"
+	case queue.StatusDownloading:
+		return "DOWNLOADING"
+	case queue.StatusProcessing:
+		switch dl.ExtractStage {
+		case queue.StageVerifying:
+			return "VERIFYING"
+		case queue.StageRepairing:
+			return "REPAIRING"
+		case queue.StageExtracting:
+			return "UNPACKING"
+		}
+		return "PP_QUEUED"
+	default:
+		return "QUEUED"
+	}
+}
+
+// nzbgetListGroups implements listgroups.
+func (h *Handler) nzbgetListGroups() (interface{}, error) {
+	downloads, err := h.QueueMgr.GetQueue()
+	if err != nil {
+		return nil, err
+	}
+	groups := []interface{}{}
+	for _, dl := range downloads {
+		remaining := dl.TotalBytes - dl.DownloadedBytes
+		active := 0
+		if dl.Status == queue.StatusDownloading {
+			active = 1
+		}
+		g := map[string]interface{}{
+			"NZBID":             dl.Num,
+			"FirstID":           dl.Num,
+			"LastID":            dl.Num,
+			"NZBName":           dl.Name,
+			"NZBNicename":       dl.Name,
+			"NZBFilename":       dl.Name + ".nzb",
+			"Kind":              "NZB",
+			"Category":          dl.Category,
+			"Status":            nzbgetQueueStatus(dl),
+			"MaxPriority":       priorityToNZBGet(dl.Priority),
+			"ActiveDownloads":   active,
+			"DestDir":           dl.Path,
+			"FinalDir":          "",
+			"Health":            1000,
+			"CriticalHealth":    1000,
+			"DupeKey":           "",
+			"DupeScore":         0,
+			"DupeMode":          "SCORE",
+			"Parameters":        h.nzbgetParams(dl.ID),
+			"PostInfoText":      dl.ExtractFile,
+			"PostStageProgress": int(dl.ExtractPct * 10),
+			"DownloadRate":      h.Engine.JobSpeed(dl.ID),
+		}
+		nzbgetSize(g, "FileSize", dl.TotalBytes)
+		nzbgetSize(g, "RemainingSize", remaining)
+		nzbgetSize(g, "DownloadedSize", dl.DownloadedBytes)
+		paused := int64(0)
+		if dl.Status == queue.StatusPaused {
+			paused = remaining
+		}
+		nzbgetSize(g, "PausedSize", paused)
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// nzbgetHistoryStatus maps a finished download to NZBGet's status fields.
+func nzbgetHistoryStatus(dl *queue.Download) map[string]interface{} {
+	st := map[string]interface{}{
+		"Status":       "SUCCESS/ALL",
+		"ParStatus":    "SUCCESS",
+		"UnpackStatus": "SUCCESS",
+		"MoveStatus":   "SUCCESS",
+		"ScriptStatus": "NONE",
+		"DeleteStatus": "NONE",
+		"MarkStatus":   "NONE",
+		"UrlStatus":    "NONE",
+	}
+	if dl.Status != queue.StatusFailed {
+		return st
+	}
+	msg := dl.ErrorMsg
+	switch {
+	case msg == "cancelled by user":
+		st["Status"], st["DeleteStatus"] = "DELETED/MANUAL", "MANUAL"
+		st["ParStatus"], st["UnpackStatus"], st["MoveStatus"] = "NONE", "NONE", "NONE"
+	case strings.HasPrefix(msg, "repair failed"):
+		st["Status"], st["ParStatus"] = "FAILURE/PAR", "FAILURE"
+		st["UnpackStatus"] = "NONE"
+	case strings.HasPrefix(msg, "extraction failed"):
+		st["Status"], st["UnpackStatus"] = "FAILURE/UNPACK", "FAILURE"
+	default:
+		// Download errors, missing articles, failed pre-flight checks
+		st["Status"], st["DeleteStatus"] = "FAILURE/HEALTH", "HEALTH"
+		st["ParStatus"], st["UnpackStatus"], st["MoveStatus"] = "NONE", "NONE", "NONE"
+	}
+	return st
+}
+
+// nzbgetHistory implements history.
+func (h *Handler) nzbgetHistory() (interface{}, error) {
+	history, err := h.QueueMgr.GetHistory()
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	for _, dl := range history {
+		var historyTime int64
+		downloadTime := 0
+		if dl.CompletedAt != nil {
+			historyTime = dl.CompletedAt.Unix()
+			downloadTime = int(dl.CompletedAt.Sub(dl.CreatedAt).Seconds())
+		}
+		item := map[string]interface{}{
+			"NZBID":           dl.Num,
+			"ID":              dl.Num,
+			"Name":            dl.Name,
+			"NZBName":         dl.Name,
+			"NZBNicename":     dl.Name,
+			"Kind":            "NZB",
+			"Category":        dl.Category,
+			"DestDir":         dl.Path,
+			"FinalDir":        "",
+			"HistoryTime":     historyTime,
+			"DownloadTimeSec": downloadTime,
+			"Health":          1000,
+			"CriticalHealth":  1000,
+			"Parameters":      h.nzbgetParams(dl.ID),
+			"FailMessage":     dl.ErrorMsg,
+		}
+		for k, v := range nzbgetHistoryStatus(dl) {
+			item[k] = v
+		}
+		nzbgetSize(item, "FileSize", dl.TotalBytes)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// nzbgetStatus implements status.
+func (h *Handler) nzbgetStatus() (interface{}, error) {
+	downloads, err := h.QueueMgr.GetQueue()
+	if err != nil {
+		return nil, err
+	}
+	var remaining int64
+	postJobs, active := 0, 0
+	for _, dl := range downloads {
+		remaining += dl.TotalBytes - dl.DownloadedBytes
+		switch dl.Status {
+		case queue.StatusProcessing:
+			postJobs++
+		case queue.StatusDownloading:
+			active++
+		}
+	}
+	paused := h.QueueMgr.IsPaused()
+	st := map[string]interface{}{
+		"DownloadRate":    h.Engine.CurrentSpeed(),
+		"DownloadLimit":   h.Engine.SpeedLimit(),
+		"DownloadPaused":  paused,
+		"Download2Paused": paused,
+		"ServerPaused":    false,
+		"ServerStandBy":   active == 0,
+		"PostPaused":      false,
+		"ScanPaused":      false,
+		"QuotaReached":    false,
+		"PostJobCount":    postJobs,
+		"UrlCount":        0,
+		"ThreadCount":     h.PoolMgr.Capacity(),
+		"UpTimeSec":       int64(time.Since(started).Seconds()),
+		"ServerTime":      time.Now().Unix(),
+		"ResumeTime":      0,
+		"FeedActive":      false,
+		"NewsServers":     []interface{}{},
+	}
+	nzbgetSize(st, "RemainingSize", remaining)
+	return st, nil
+}
+
+// nzbgetEditQueue implements editqueue. Two signatures are in use:
+//
+//	v18+:  Command, Param, IDs
+//	older: Command, Offset, EditText, IDs
+//
+// It returns false if the command failed for any of the downloads.
+func (h *Handler) nzbgetEditQueue(p []interface{}) (interface{}, error) {
+	command := argString(p, 0)
+	param := argString(p, 1)
+	rawIDs, _ := safeIndex(p, 2).([]interface{})
+	if len(p) == 4 {
+		param = argString(p, 2)
+		if command == "GroupMoveOffset" {
+			param = strconv.Itoa(argInt(p, 1))
+		}
+		rawIDs, _ = safeIndex(p, 3).([]interface{})
+	}
+
+	ok := true
+	for i := range rawIDs {
+		id, err := h.QueueMgr.IDForNum(int64(argInt(rawIDs, i)))
+		if err == nil {
+			err = h.nzbgetEdit(command, param, id)
+		}
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "unknown command") {
+				return nil, err
+			}
+			log.Printf("NZBGet editqueue %s: %v", command, err)
+			ok = false
+		}
+	}
+	h.Engine.Notify()
+	return ok, nil
+}
+
+func (h *Handler) nzbgetEdit(command, param, id string) error {
+	switch command {
+	case "GroupPause":
+		return h.Engine.PauseDownload(id)
+	case "GroupResume":
+		return h.Engine.ResumeDownload(id)
+	case "GroupDelete", "GroupParkDelete", "GroupDupeDelete":
+		// Deleted jobs move to the history, as in NZBGet
+		h.Engine.CancelDownload(id)
+		return nil
+	case "GroupFinalDelete":
+		return h.Engine.Delete(id, true)
+	case "GroupMoveTop":
+		_, err := h.QueueMgr.Move(id, 0)
+		return err
+	case "GroupMoveBottom":
+		_, err := h.QueueMgr.Move(id, 1<<30)
+		return err
+	case "GroupMoveOffset":
+		offset, err := strconv.Atoi(param)
+		if err != nil {
+			return fmt.Errorf("invalid offset %q", param)
+		}
+		downloads, err := h.QueueMgr.GetQueue()
+		if err != nil {
+			return err
+		}
+		for i, dl := range downloads {
+			if dl.ID == id {
+				_, err = h.QueueMgr.Move(id, i+offset)
+				return err
+			}
+		}
+		return fmt.Errorf("download %s not in queue", id)
+	case "GroupSetPriority":
+		prio, err := strconv.Atoi(param)
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", param)
+		}
+		_, err = h.QueueMgr.SetPriority(id, nzbgetToPriority(prio))
+		return err
+	case "GroupSetCategory", "GroupApplyCategory":
+		return h.QueueMgr.SetCategory(id, param)
+	case "HistoryDelete", "HistoryFinalDelete":
+		return h.Engine.Delete(id, false)
+	case "HistoryRedownload", "HistoryRetry":
+		return h.Engine.Retry(id)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// nzbgetConfig implements config with the options clients read: the
+// directories and the categories.
+func (h *Handler) nzbgetConfig() []interface{} {
+	opt := func(name, value string) interface{} {
+		return map[string]interface{}{"Name": name, "Value": value}
+	}
+	web := h.Config.GetWeb()
+	opts := []interface{}{
+		opt("MainDir", h.Config.Paths.Complete),
+		opt("DestDir", h.Config.Paths.Complete),
+		opt("InterDir", h.Config.Paths.Incomplete),
+		opt("TempDir", h.Config.Paths.Temp),
+		opt("AppendCategoryDir", "yes"),
+		opt("ControlPort", strconv.Itoa(web.Port)),
+		opt("KeepHistory", "0"),
+		opt("Version", nzbgetVersion),
+	}
+	n := 0
+	for _, c := range h.categories() {
+		if c == "*" {
+			continue
+		}
+		n++
+		opts = append(opts,
+			opt(fmt.Sprintf("Category%d.Name", n), c),
+			opt(fmt.Sprintf("Category%d.DestDir", n), ""))
+	}
+	return opts
+}
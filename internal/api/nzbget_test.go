@@ -0,0 +1,334 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nzb-connect/internal/queue"
+)
+
+const testNZB = `<?xml version="1.0" encoding="UTF-8"?>
+<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">
+  <file poster="user@example.com" date="1234567890" subject="&quot;test.rar&quot; yEnc (1/2)">
+    <groups><group>alt.binaries.test</group></groups>
+    <segments>
+      <segment bytes="3000000000" number="1">a@example.com</segment>
+      <segment bytes="3000000000" number="2">b@example.com</segment>
+    </segments>
+  </file>
+</nzb>`
+
+var testNZBBase64 = base64.StdEncoding.EncodeToString([]byte(testNZB))
+
+// jsonParams decodes a JSON-RPC params array, so arguments have the types
+// clients send.
+func jsonParams(t *testing.T, format string, args ...interface{}) []interface{} {
+	t.Helper()
+	var p []interface{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(format, args...)), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// nzbgetAppendTest appends testNZB with params and returns the queued download.
+func nzbgetAppendTest(t *testing.T, h *Handler, params []interface{}) *queue.Download {
+	t.Helper()
+	result, err := h.nzbgetCall("append", params, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	num, ok := result.(int64)
+	if !ok || num <= 0 {
+		t.Fatalf("append returned %v", result)
+	}
+	id, err := h.QueueMgr.IDForNum(num)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl, err := h.QueueMgr.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dl
+}
+
+func TestNZBGetPriority(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int
+		back int // what the queue priority is reported as
+	}{
+		{900, queue.PriorityForce, 900},
+		{1000, queue.PriorityForce, 900},
+		{100, queue.PriorityHigh, 50},
+		{50, queue.PriorityHigh, 50},
+		{1, queue.PriorityHigh, 50},
+		{0, queue.PriorityNormal, 0},
+		{-1, queue.PriorityLow, -50},
+		{-100, queue.PriorityLow, -50},
+	}
+	for _, tt := range tests {
+		got := nzbgetToPriority(tt.in)
+		if got != tt.want {
+			t.Errorf("nzbgetToPriority(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+		if back := priorityToNZBGet(got); back != tt.back {
+			t.Errorf("priorityToNZBGet(%d) = %d, want %d", got, back, tt.back)
+		}
+	}
+}
+
+func TestNZBGetAppend(t *testing.T) {
+	h := newTestHandler(t)
+
+	// v16+: NZBFilename, Content, Category, Priority, AddToTop, AddPaused,
+	// DupeKey, DupeScore, DupeMode, PPParameters
+	first := nzbgetAppendTest(t, h, jsonParams(t,
+		`["first.nzb", %q, "tv", 50, false, false, "", 0, "SCORE", [{"Name": "drone", "Value": "abc123"}]]`, testNZBBase64))
+	if first.Name != "first" || first.Category != "tv" || first.Priority != queue.PriorityHigh {
+		t.Errorf("v16 append: %+v", first)
+	}
+	if first.TotalBytes != 6000000000 {
+		t.Errorf("v16 append: total bytes %d", first.TotalBytes)
+	}
+	if params, _ := h.QueueMgr.GetParams(first.ID); params["drone"] != "abc123" {
+		t.Errorf("v16 append: parameters %v", params)
+	}
+
+	// v13+: NZBFilename, Category, Priority, AddToTop, Content, AddPaused
+	second := nzbgetAppendTest(t, h, jsonParams(t, `["second.nzb", "movies", 900, true, %q, true]`, testNZBBase64))
+	if second.Name != "second" || second.Category != "movies" || second.Status != queue.StatusPaused {
+		t.Errorf("v13 append: %+v", second)
+	}
+	// Force priority and added to the top
+	if q, _ := h.QueueMgr.GetQueue(); len(q) != 2 || q[0].ID != second.ID || q[0].Priority != queue.PriorityForce {
+		t.Errorf("queue after v13 append with AddToTop: %+v", q)
+	}
+
+	// An NZB that can't be added returns 0 rather than an error
+	if result, err := h.nzbgetCall("append", jsonParams(t, `["bad.nzb", %q, "", 0, false, false]`,
+		base64.StdEncoding.EncodeToString([]byte("not xml"))), false); err != nil || result != 0 {
+		t.Errorf("append of an invalid NZB = %v, %v; want 0", result, err)
+	}
+	if _, err := h.nzbgetCall("append", jsonParams(t, `["bad.nzb", "%%%%", "", 0, false, false]`), false); err == nil {
+		t.Error("expected an error for content that is neither base64 nor a URL")
+	}
+}
+
+func TestNZBGetEditQueue(t *testing.T) {
+	h := newTestHandler(t)
+	var nums []int64
+	for _, name := range []string{"a", "b", "c"} {
+		dl := nzbgetAppendTest(t, h, jsonParams(t, `[%q, %q, "", 0, false, false]`, name+".nzb", testNZBBase64))
+		nums = append(nums, dl.Num)
+	}
+	names := func() string {
+		q, _ := h.QueueMgr.GetQueue()
+		var s []string
+		for _, dl := range q {
+			s = append(s, dl.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	edit := func(format string, args ...interface{}) interface{} {
+		t.Helper()
+		result, err := h.nzbgetCall("editqueue", jsonParams(t, format, args...), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// v18+: Command, Param, IDs
+	if ok := edit(`["GroupPause", "", [%d, %d]]`, nums[0], nums[1]); ok != true {
+		t.Errorf("GroupPause = %v", ok)
+	}
+	for _, num := range nums[:2] {
+		if dl, _ := h.QueueMgr.Get(mustID(t, h, num)); dl.Status != queue.StatusPaused {
+			t.Errorf("GroupPause: %s is %s", dl.Name, dl.Status)
+		}
+	}
+	edit(`["GroupMoveOffset", "2", [%d]]`, nums[0])
+	if got := names(); got != "b,c,a" {
+		t.Errorf("after v18 GroupMoveOffset: %s, want b,c,a", got)
+	}
+	edit(`["GroupSetCategory", "tv", [%d]]`, nums[2])
+	if dl, _ := h.QueueMgr.Get(mustID(t, h, nums[2])); dl.Category != "tv" {
+		t.Errorf("GroupSetCategory: category %q", dl.Category)
+	}
+
+	// Older: Command, Offset, EditText, IDs
+	if ok := edit(`["GroupResume", 0, "", [%d]]`, nums[0]); ok != true {
+		t.Errorf("old GroupResume = %v", ok)
+	}
+	if dl, _ := h.QueueMgr.Get(mustID(t, h, nums[0])); dl.Status != queue.StatusQueued {
+		t.Errorf("old GroupResume: status %s", dl.Status)
+	}
+	edit(`["GroupMoveOffset", -2, "", [%d]]`, nums[0])
+	if got := names(); got != "a,b,c" {
+		t.Errorf("after old GroupMoveOffset: %s, want a,b,c", got)
+	}
+	edit(`["GroupSetPriority", 0, "900", [%d]]`, nums[2])
+	if dl, _ := h.QueueMgr.Get(mustID(t, h, nums[2])); dl.Priority != queue.PriorityForce {
+		t.Errorf("old GroupSetPriority: priority %d", dl.Priority)
+	}
+
+	// A failure for one of the IDs reports false; unknown commands fail
+	if ok := edit(`["GroupResume", "", [%d, 9999]]`, nums[1]); ok != false {
+		t.Errorf("GroupResume with an unknown ID = %v, want false", ok)
+	}
+	if _, err := h.nzbgetCall("editqueue", jsonParams(t, `["GroupSplit", "", [%d]]`, nums[0]), false); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
+
+func mustID(t *testing.T, h *Handler, num int64) string {
+	t.Helper()
+	id, err := h.QueueMgr.IDForNum(num)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestNZBGetListGroups(t *testing.T) {
+	h := newTestHandler(t)
+	dl := nzbgetAppendTest(t, h, jsonParams(t, `["show.nzb", %q, "tv", -50, false, true]`, testNZBBase64))
+	if err := h.QueueMgr.UpdateProgress(dl.ID, 1000000000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.QueueMgr.SetParams(dl.ID, map[string]string{"drone": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := h.nzbgetCall("listgroups", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := result.([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("listgroups returned %d groups", len(groups))
+	}
+	g := groups[0].(map[string]interface{})
+	want := map[string]interface{}{
+		"NZBID":            dl.Num,
+		"NZBName":          "show",
+		"NZBFilename":      "show.nzb",
+		"Category":         "tv",
+		"Status":           "PAUSED",
+		"MaxPriority":      -50,
+		"FileSizeLo":       int64(6000000000 & 0xffffffff),
+		"FileSizeHi":       int64(1),
+		"FileSizeMB":       int64(6000000000 / (1 << 20)),
+		"RemainingSizeMB":  int64(5000000000 / (1 << 20)),
+		"DownloadedSizeLo": int64(1000000000),
+		"PausedSizeMB":     int64(5000000000 / (1 << 20)),
+		"ActiveDownloads":  0,
+	}
+	for k, v := range want {
+		if g[k] != v {
+			t.Errorf("%s = %v (%T), want %v (%T)", k, g[k], g[k], v, v)
+		}
+	}
+	params := g["Parameters"].([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["Name"] != "drone" {
+		t.Errorf("Parameters = %v", params)
+	}
+}
+
+func TestNZBGetHistory(t *testing.T) {
+	h := newTestHandler(t)
+	outcomes := []struct {
+		name   string
+		fail   string // error message, or "" to complete
+		status string
+		par    string
+		unpack string
+	}{
+		{"ok", "", "SUCCESS/ALL", "SUCCESS", "SUCCESS"},
+		{"par", "repair failed: not enough blocks", "FAILURE/PAR", "FAILURE", "NONE"},
+		{"unpack", "extraction failed: CRC error", "FAILURE/UNPACK", "SUCCESS", "FAILURE"},
+		{"health", "too many missing articles", "FAILURE/HEALTH", "NONE", "NONE"},
+		{"deleted", "cancelled by user", "DELETED/MANUAL", "NONE", "NONE"},
+	}
+	for _, o := range outcomes {
+		dl := nzbgetAppendTest(t, h, jsonParams(t, `[%q, %q, "tv", 0, false, false]`, o.name+".nzb", testNZBBase64))
+		var err error
+		if o.fail == "" {
+			err = h.QueueMgr.UpdateStatus(dl.ID, queue.StatusCompleted)
+		} else {
+			err = h.QueueMgr.SetError(dl.ID, o.fail)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := h.nzbgetCall("history", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := map[string]map[string]interface{}{}
+	for _, it := range result.([]interface{}) {
+		m := it.(map[string]interface{})
+		items[m["Name"].(string)] = m
+	}
+	for _, o := range outcomes {
+		it, ok := items[o.name]
+		if !ok {
+			t.Errorf("%s missing from the history", o.name)
+			continue
+		}
+		if it["Status"] != o.status || it["ParStatus"] != o.par || it["UnpackStatus"] != o.unpack {
+			t.Errorf("%s: Status %v, ParStatus %v, UnpackStatus %v; want %s, %s, %s",
+				o.name, it["Status"], it["ParStatus"], it["UnpackStatus"], o.status, o.par, o.unpack)
+		}
+		if it["FailMessage"] != o.fail || it["Category"] != "tv" || it["NZBID"] != it["ID"] {
+			t.Errorf("%s: %v", o.name, it)
+		}
+		if it["FileSizeHi"] != int64(1) || it["FileSizeMB"] != int64(6000000000/(1<<20)) {
+			t.Errorf("%s: size fields %v/%v", o.name, it["FileSizeHi"], it["FileSizeMB"])
+		}
+	}
+}
+
+func TestNZBGetAccess(t *testing.T) {
+	h := newTestHandler(t)
+	call := func(pass, method string) map[string]interface{} {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/jsonrpc", strings.NewReader(`{"method": "`+method+`", "params": [], "id": 7}`))
+		r.SetBasicAuth("nzbget", pass)
+		w := httptest.NewRecorder()
+		h.handleJSONRPC(w, r)
+		if w.Code == http.StatusUnauthorized {
+			return nil
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := call(testAPIKey, "status"); resp == nil || resp["result"] == nil || resp["id"] != 7.0 {
+		t.Errorf("status with the API key: %v", resp)
+	}
+	if resp := call(testNZBKey, "version"); resp == nil || resp["result"] != nzbgetVersion {
+		t.Errorf("version with the NZB key: %v", resp)
+	}
+	if resp := call(testNZBKey, "listgroups"); resp == nil || resp["error"] == nil {
+		t.Errorf("listgroups with the NZB key: %v, want access denied", resp)
+	}
+	if resp := call("wrong", "version"); resp != nil {
+		t.Errorf("wrong password: %v, want 401", resp)
+	}
+	if resp := call(testAPIKey, "scan"); resp == nil || resp["error"] == nil {
+		t.Errorf("unknown method: %v, want an error", resp)
+	}
+}
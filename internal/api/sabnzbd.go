@@ -9,11 +9,14 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"nzb-connect/internal/config"
 	"nzb-connect/internal/downloader"
+	"nzb-connect/internal/events"
 	"nzb-connect/internal/nzb"
 	"nzb-connect/internal/queue"
 	"nzb-connect/internal/vpn"
@@ -26,6 +29,13 @@ type Handler struct {
 	Engine   *downloader.Engine
 	VPNMgr   *vpn.Manager
 	PoolMgr  *downloader.PoolManager
+	Events   *events.Bus
+
+	sessions *sessionStore
+
+	clientMu    sync.Mutex
+	client      *http.Client // for outbound fetches, see httpClient
+	clientIface string
 }
 
 func generateID() string {
@@ -36,65 +46,117 @@ func generateID() string {
 
 // RegisterRoutes registers all API routes on the given mux.
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	h.sessions = newSessionStore()
+	if !h.hasWebLogin() {
+		log.Println("No web.username set — log in to the web UI with the API key")
+	}
+
 	mux.HandleFunc("/api", h.handleSABnzbd)
-	mux.HandleFunc("/api/servers", h.handleServers)
-	mux.HandleFunc("/api/servers/", h.handleServerByID)
-	mux.HandleFunc("/api/servers/test", h.handleTestServer)
-	mux.HandleFunc("/api/queue/", h.handleQueueItem)
-	mux.HandleFunc("/api/vpn", h.handleVPN)
-	mux.HandleFunc("/api/vpn/connect", h.handleVPNConnect)
-	mux.HandleFunc("/api/vpn/disconnect", h.handleVPNDisconnect)
-	mux.HandleFunc("/api/vpn/status", h.handleVPNStatus)
+	mux.HandleFunc("/jsonrpc", h.handleJSONRPC)
+	mux.HandleFunc("/xmlrpc", h.handleXMLRPC)
+	mux.HandleFunc("/api/auth/status", h.handleAuthStatus)
+	mux.HandleFunc("/api/auth/login", h.handleLogin)
+	mux.HandleFunc("/api/auth/logout", h.handleLogout)
+	mux.HandleFunc("/api/auth/keys", h.requireLogin(h.handleKeys))
+	mux.HandleFunc("/api/events", h.requireLogin(h.handleEvents))
+	mux.HandleFunc("/api/servers", h.requireLogin(h.handleServers))
+	mux.HandleFunc("/api/servers/", h.requireLogin(h.handleServerByID))
+	mux.HandleFunc("/api/servers/test", h.requireLogin(h.handleTestServer))
+	mux.HandleFunc("/api/queue/", h.requireLogin(h.handleQueueItem))
+	mux.HandleFunc("/api/vpn", h.requireLogin(h.handleVPN))
+	mux.HandleFunc("/api/vpn/connect", h.requireLogin(h.handleVPNConnect))
+	mux.HandleFunc("/api/vpn/disconnect", h.requireLogin(h.handleVPNDisconnect))
+	mux.HandleFunc("/api/vpn/status", h.requireLogin(h.handleVPNStatus))
+	mux.HandleFunc("/api/vpn/import", h.requireLogin(h.handleVPNImport))
+	mux.HandleFunc("/api/vpn/profile", h.requireLogin(h.handleVPNProfile))
 }
 
 // handleSABnzbd handles the SABnzbd-compatible API endpoint.
 func (h *Handler) handleSABnzbd(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.handleSABnzbdGet(w, r)
-	case http.MethodPost:
-		h.handleSABnzbdPost(w, r)
-	default:
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-}
 
-func (h *Handler) handleSABnzbdGet(w http.ResponseWriter, r *http.Request) {
-	mode := r.URL.Query().Get("mode")
+	mode := r.FormValue("mode")
+	if msg := h.checkSABKey(r, mode); msg != "" {
+		writeSABError(w, msg)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		switch mode {
+		case "addfile":
+			h.addNZBFile(w, r)
+			return
+		case "":
+			// No mode: try to handle as NZB add
+			h.addNZB(w, r)
+			return
+		}
+	}
+	h.handleSABnzbdMode(w, r, mode)
+}
 
+// handleSABnzbdMode serves the SABnzbd API modes that work over both GET and
+// POST.
+func (h *Handler) handleSABnzbdMode(w http.ResponseWriter, r *http.Request, mode string) {
 	switch mode {
 	case "queue":
-		h.getQueue(w, r)
+		switch r.FormValue("name") {
+		case "priority":
+			h.setQueuePriority(w, r)
+		case "delete":
+			h.deleteQueueItems(w, r)
+		case "pause":
+			h.pauseQueueItems(w, r, true)
+		case "resume":
+			h.pauseQueueItems(w, r, false)
+		default:
+			h.getQueue(w, r)
+		}
+	case "switch":
+		h.switchQueueItem(w, r)
+	case "pause":
+		h.Engine.Pause()
+		writeJSON(w, map[string]interface{}{"status": true})
+	case "resume":
+		h.Engine.Resume()
+		writeJSON(w, map[string]interface{}{"status": true})
 	case "history":
-		h.getHistory(w, r)
+		if r.FormValue("name") == "delete" {
+			h.deleteHistoryItems(w, r)
+		} else {
+			h.getHistory(w, r)
+		}
+	case "retry":
+		h.retryDownload(w, r)
+	case "change_cat":
+		h.changeCategory(w, r)
+	case "get_cats":
+		writeJSON(w, map[string]interface{}{"categories": h.categories()})
+	case "get_config":
+		h.getConfig(w, r)
+	case "config":
+		if r.FormValue("name") == "speedlimit" {
+			h.setSpeedLimit(w, r)
+		} else {
+			writeSABError(w, "not implemented")
+		}
+	case "warnings":
+		h.getWarnings(w, r)
+	case "addurl":
+		h.addNZBURL(w, r)
 	case "status":
 		h.getStatus(w, r)
+	case "auth":
+		h.sabAuthMode(w, r)
 	case "version":
 		writeJSON(w, map[string]string{"version": "4.0.0"})
 	case "fullstatus":
 		h.getStatus(w, r)
 	default:
-		writeJSON(w, map[string]interface{}{
-			"status": true,
-			"mode":   mode,
-		})
-	}
-}
-
-func (h *Handler) handleSABnzbdPost(w http.ResponseWriter, r *http.Request) {
-	mode := r.URL.Query().Get("mode")
-	if mode == "" {
-		mode = r.FormValue("mode")
-	}
-
-	switch mode {
-	case "addfile":
-		h.addNZBFile(w, r)
-	case "addurl":
-		h.addNZBURL(w, r)
-	default:
-		// Default: try to handle as NZB add
-		h.addNZB(w, r)
+		writeSABError(w, "not implemented")
 	}
 }
 
@@ -115,7 +177,7 @@ func (h *Handler) addNZB(w http.ResponseWriter, r *http.Request) {
 			category = r.FormValue("category")
 		}
 
-		id, err := h.addDownload(name, category, data)
+		id, err := h.addDownload(name, category, parsePriority(r.FormValue("priority")), data)
 		if err != nil {
 			writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
 			return
@@ -161,7 +223,7 @@ func (h *Handler) addNZBFile(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSuffix(header.Filename, ".nzb")
 	category := r.FormValue("cat")
 
-	id, err := h.addDownload(name, category, data)
+	id, err := h.addDownload(name, category, parsePriority(r.FormValue("priority")), data)
 	if err != nil {
 		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
 		return
@@ -186,33 +248,18 @@ func (h *Handler) addNZBURL(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) downloadAndAddNZB(w http.ResponseWriter, r *http.Request, nzbURL string) {
-	resp, err := http.Get(nzbURL)
+	name, data, err := h.fetchNZB(nzbURL)
 	if err != nil {
-		writeJSON(w, map[string]interface{}{"status": false, "error": fmt.Sprintf("download error: %v", err)})
-		return
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		writeJSON(w, map[string]interface{}{"status": false, "error": "read error"})
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
 		return
 	}
 
-	// Extract name from URL
-	parts := strings.Split(nzbURL, "/")
-	name := parts[len(parts)-1]
-	name = strings.TrimSuffix(name, ".nzb")
-	if name == "" {
-		name = "download"
-	}
-
 	category := r.FormValue("cat")
 	if category == "" {
 		category = r.FormValue("category")
 	}
 
-	id, err := h.addDownload(name, category, data)
+	id, err := h.addDownload(name, category, parsePriority(r.FormValue("priority")), data)
 	if err != nil {
 		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
 		return
@@ -224,7 +271,7 @@ func (h *Handler) downloadAndAddNZB(w http.ResponseWriter, r *http.Request, nzbU
 	})
 }
 
-func (h *Handler) addDownload(name, category string, nzbData []byte) (string, error) {
+func (h *Handler) addDownload(name, category string, priority int, nzbData []byte) (string, error) {
 	// Parse to validate and get metadata
 	parsed, err := nzb.ParseBytes(nzbData)
 	if err != nil {
@@ -239,6 +286,7 @@ func (h *Handler) addDownload(name, category string, nzbData []byte) (string, er
 		TotalBytes:    parsed.TotalSize(),
 		TotalSegments: parsed.TotalSegments(),
 		NZBData:       nzbData,
+		Priority:      priority,
 	}
 
 	if err := h.QueueMgr.Add(dl); err != nil {
@@ -254,6 +302,306 @@ func (h *Handler) addDownload(name, category string, nzbData []byte) (string, er
 	return id, nil
 }
 
+// parsePriority maps a SABnzbd priority parameter to a queue priority. Empty,
+// -100 ("default") and unsupported values mean normal.
+func parsePriority(v string) int {
+	p, err := strconv.Atoi(v)
+	if err != nil || p < queue.PriorityLow || p > queue.PriorityForce {
+		return queue.PriorityNormal
+	}
+	return p
+}
+
+func priorityName(p int) string {
+	switch p {
+	case queue.PriorityForce:
+		return "Force"
+	case queue.PriorityHigh:
+		return "High"
+	case queue.PriorityLow:
+		return "Low"
+	default:
+		return "Normal"
+	}
+}
+
+// setQueuePriority handles mode=queue&name=priority&value=<nzo_id>&value2=<priority>.
+func (h *Handler) setQueuePriority(w http.ResponseWriter, r *http.Request) {
+	priority, err := strconv.Atoi(r.FormValue("value2"))
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "invalid priority"})
+		return
+	}
+	pos, err := h.QueueMgr.SetPriority(r.FormValue("value"), priority)
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+		return
+	}
+	h.Engine.Notify()
+	writeJSON(w, map[string]interface{}{"position": pos})
+}
+
+// switchQueueItem handles mode=switch&value=<nzo_id>&value2=<nzo_id or index>,
+// moving the first download to the position of the second (or to the index).
+func (h *Handler) switchQueueItem(w http.ResponseWriter, r *http.Request) {
+	id, target := r.FormValue("value"), r.FormValue("value2")
+	index, err := strconv.Atoi(target)
+	if err != nil {
+		downloads, err := h.QueueMgr.GetQueue()
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+			return
+		}
+		index = -1
+		for i, dl := range downloads {
+			if dl.ID == target {
+				index = i
+			}
+		}
+		if index < 0 {
+			writeJSON(w, map[string]interface{}{"status": false, "error": "unknown nzo_id " + target})
+			return
+		}
+	}
+	priority, err := h.QueueMgr.Move(id, index)
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+		return
+	}
+	h.Engine.Notify()
+	writeJSON(w, map[string]interface{}{
+		"result": map[string]interface{}{"priority": priority, "position": index},
+	})
+}
+
+// splitIDs splits a comma-separated list of nzo_ids.
+func splitIDs(v string) []string {
+	var ids []string
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// deleteQueueItems handles mode=queue&name=delete&value=<ids|all>&del_files=1.
+func (h *Handler) deleteQueueItems(w http.ResponseWriter, r *http.Request) {
+	ids := splitIDs(r.FormValue("value"))
+	if r.FormValue("value") == "all" {
+		downloads, err := h.QueueMgr.GetQueue()
+		if err != nil {
+			writeSABError(w, err.Error())
+			return
+		}
+		ids = ids[:0]
+		for _, dl := range downloads {
+			ids = append(ids, dl.ID)
+		}
+	}
+	h.deleteDownloads(w, ids, r.FormValue("del_files") == "1", func(dl *queue.Download) bool {
+		return dl.Status != queue.StatusCompleted && dl.Status != queue.StatusFailed
+	})
+}
+
+// deleteHistoryItems handles
+// mode=history&name=delete&value=<ids|all|failed|completed>&del_files=1.
+// del_files only removes the files of failed downloads.
+func (h *Handler) deleteHistoryItems(w http.ResponseWriter, r *http.Request) {
+	value := r.FormValue("value")
+	ids := splitIDs(value)
+	if value == "all" || value == "failed" || value == "completed" {
+		history, err := h.QueueMgr.GetHistory()
+		if err != nil {
+			writeSABError(w, err.Error())
+			return
+		}
+		ids = ids[:0]
+		for _, dl := range history {
+			if value == "all" || dl.Status == value {
+				ids = append(ids, dl.ID)
+			}
+		}
+	}
+	h.deleteDownloads(w, ids, r.FormValue("del_files") == "1", func(dl *queue.Download) bool {
+		return dl.Status == queue.StatusCompleted || dl.Status == queue.StatusFailed
+	})
+}
+
+// deleteDownloads deletes the downloads for which match returns true.
+func (h *Handler) deleteDownloads(w http.ResponseWriter, ids []string, deleteFiles bool, match func(*queue.Download) bool) {
+	if len(ids) == 0 {
+		writeSABError(w, "no nzo_id given")
+		return
+	}
+	deleted := []string{}
+	for _, id := range ids {
+		dl, err := h.QueueMgr.Get(id)
+		if err != nil || !match(dl) {
+			continue
+		}
+		if err := h.Engine.Delete(id, deleteFiles); err != nil {
+			writeSABError(w, err.Error())
+			return
+		}
+		deleted = append(deleted, id)
+	}
+	if len(deleted) == 0 {
+		writeSABError(w, "no matching nzo_id")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": true, "nzo_ids": deleted})
+}
+
+// pauseQueueItems handles mode=queue&name=pause|resume&value=<ids>.
+func (h *Handler) pauseQueueItems(w http.ResponseWriter, r *http.Request, pause bool) {
+	ids := splitIDs(r.FormValue("value"))
+	if len(ids) == 0 {
+		writeSABError(w, "no nzo_id given")
+		return
+	}
+	for _, id := range ids {
+		var err error
+		if pause {
+			err = h.Engine.PauseDownload(id)
+		} else {
+			err = h.Engine.ResumeDownload(id)
+		}
+		if err != nil {
+			writeSABError(w, err.Error())
+			return
+		}
+	}
+	writeJSON(w, map[string]interface{}{"status": true, "nzo_ids": ids})
+}
+
+// retryDownload handles mode=retry&value=<nzo_id>.
+func (h *Handler) retryDownload(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("value")
+	if err := h.Engine.Retry(id); err != nil {
+		writeSABError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": true, "nzo_id": id})
+}
+
+// changeCategory handles mode=change_cat&value=<nzo_id>&value2=<category>.
+// "*" and "Default" clear the category.
+func (h *Handler) changeCategory(w http.ResponseWriter, r *http.Request) {
+	cat := r.FormValue("value2")
+	if cat == "*" || cat == "Default" {
+		cat = ""
+	}
+	if err := h.QueueMgr.SetCategory(r.FormValue("value"), cat); err != nil {
+		writeSABError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+// categories returns "*" (no category) followed by the configured categories
+// and any others downloads have used.
+func (h *Handler) categories() []string {
+	cats := []string{"*"}
+	seen := map[string]bool{"*": true}
+	used, err := h.QueueMgr.Categories()
+	if err != nil {
+		log.Printf("Error listing categories: %v", err)
+	}
+	for _, c := range append(h.Config.GetCategories(), used...) {
+		if !seen[c] {
+			seen[c] = true
+			cats = append(cats, c)
+		}
+	}
+	return cats
+}
+
+// getConfig handles mode=get_config with the subset Sonarr/Radarr read:
+// the download folders and the categories.
+func (h *Handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	var cats []map[string]interface{}
+	for _, c := range h.categories() {
+		dir := c
+		if c == "*" {
+			dir = ""
+		}
+		cats = append(cats, map[string]interface{}{"name": c, "dir": dir, "priority": -100, "pp": "3", "script": "None"})
+	}
+	writeJSON(w, map[string]interface{}{
+		"config": map[string]interface{}{
+			"misc": map[string]interface{}{
+				"complete_dir":      h.Config.Paths.Complete,
+				"download_dir":      h.Config.Paths.Incomplete,
+				"history_retention": "",
+				"pre_check":         h.Config.GetPreflight().Enabled,
+			},
+			"categories": cats,
+		},
+	})
+}
+
+// setSpeedLimit handles mode=config&name=speedlimit&value=<limit>. The limit
+// is a percentage of downloads.line_speed, or an absolute speed with a K or M
+// suffix; empty, 0 or 100% removes it.
+func (h *Handler) setSpeedLimit(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseSpeedLimit(r.FormValue("value"), h.Config.GetDownloads().LineSpeed)
+	if err != nil {
+		writeSABError(w, err.Error())
+		return
+	}
+	h.Engine.SetSpeedLimit(limit)
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+// parseSpeedLimit converts a SABnzbd speed limit to bytes/sec. lineSpeed is
+// the connection speed in KB/s that percentages are relative to.
+func parseSpeedLimit(v string, lineSpeed int) (int64, error) {
+	v = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(v), "B"))
+	if v == "" {
+		return 0, nil
+	}
+	unit := 0.0
+	switch {
+	case strings.HasSuffix(v, "K"):
+		unit = 1024
+	case strings.HasSuffix(v, "M"):
+		unit = 1024 * 1024
+	}
+	n, err := strconv.ParseFloat(strings.TrimRight(v, "KM"), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid speed limit %q", v)
+	}
+	if unit > 0 {
+		return int64(n * unit), nil
+	}
+	if n == 0 || n >= 100 {
+		return 0, nil
+	}
+	if lineSpeed <= 0 {
+		return 0, fmt.Errorf("percentage speed limits need downloads.line_speed in the config")
+	}
+	return int64(n / 100 * float64(lineSpeed) * 1024), nil
+}
+
+// getWarnings handles mode=warnings, and mode=warnings&name=clear.
+func (h *Handler) getWarnings(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("name") == "clear" {
+		h.QueueMgr.ClearWarnings()
+		writeJSON(w, map[string]interface{}{"status": true})
+		return
+	}
+	warnings := []map[string]interface{}{}
+	for _, warn := range h.QueueMgr.Warnings() {
+		warnings = append(warnings, map[string]interface{}{
+			"text": warn.Text,
+			"type": "WARNING",
+			"time": warn.Time.Unix(),
+		})
+	}
+	writeJSON(w, map[string]interface{}{"warnings": warnings})
+}
+
 func (h *Handler) getQueue(w http.ResponseWriter, r *http.Request) {
 	downloads, err := h.QueueMgr.GetQueue()
 	if err != nil {
@@ -263,18 +611,22 @@ func (h *Handler) getQueue(w http.ResponseWriter, r *http.Request) {
 
 	slots := make([]map[string]interface{}, 0, len(downloads))
 	for _, dl := range downloads {
+		speed := h.Engine.JobSpeed(dl.ID)
 		slots = append(slots, map[string]interface{}{
 			"nzo_id":      dl.ID,
 			"filename":    dl.Name,
 			"cat":         dl.Category,
-			"status":      mapStatusToSAB(dl.Status),
+			"status":      mapStatusToSAB(dl.Status, dl.ExtractStage),
 			"mb":          fmt.Sprintf("%.2f", float64(dl.TotalBytes)/1024/1024),
 			"mbleft":      fmt.Sprintf("%.2f", float64(dl.TotalBytes-dl.DownloadedBytes)/1024/1024),
 			"percentage":  fmt.Sprintf("%.0f", dl.Progress()),
 			"size":        nzb.FormatSize(dl.TotalBytes),
 			"sizeleft":    nzb.FormatSize(dl.TotalBytes - dl.DownloadedBytes),
-			"timeleft":     "unknown",
+			"timeleft":     formatTimeLeft(dl.TotalBytes-dl.DownloadedBytes, speed),
+			"kbpersec":     fmt.Sprintf("%.2f", float64(speed)/1024),
 			"extract_pct":  fmt.Sprintf("%.0f", dl.ExtractPct),
+			"priority":     priorityName(dl.Priority),
+			"index":        len(slots),
 			"extract_file": dl.ExtractFile,
 		})
 	}
@@ -283,12 +635,34 @@ func (h *Handler) getQueue(w http.ResponseWriter, r *http.Request) {
 		"queue": map[string]interface{}{
 			"paused": h.QueueMgr.IsPaused(),
 			"slots":  slots,
+			"speedlimit":     h.speedLimitPct(),
+			"speedlimit_abs": strconv.FormatInt(h.Engine.SpeedLimit(), 10),
 			"speed":  fmt.Sprintf("%.0f", float64(h.Engine.CurrentSpeed())/1024),
 			"noofslots": len(slots),
 		},
 	})
 }
 
+// speedLimitPct returns the speed limit as a percentage of the line speed,
+// or "" if there is no limit or no line speed to compare it with.
+func (h *Handler) speedLimitPct() string {
+	limit, line := h.Engine.SpeedLimit(), h.Config.GetDownloads().LineSpeed
+	if limit == 0 || line <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f", float64(limit)/1024/float64(line)*100)
+}
+
+// formatTimeLeft returns the SABnzbd "h:mm:ss" time to download remaining
+// bytes at speed bytes/sec.
+func formatTimeLeft(remaining, speed int64) string {
+	if speed <= 0 {
+		return "unknown"
+	}
+	secs := remaining / speed
+	return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+}
+
 func (h *Handler) getHistory(w http.ResponseWriter, r *http.Request) {
 	history, err := h.QueueMgr.GetHistory()
 	if err != nil {
@@ -356,18 +730,82 @@ func (h *Handler) getStatus(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleQueueItem handles DELETE /api/queue/{id} to cancel a download.
+// handleQueueItem handles:
+//
+//	DELETE /api/queue/{id}                  cancel a download
+//	GET    /api/queue/{id}/files            list its files
+//	PUT    /api/queue/{id}/files/{index}    set a file's state ({"state": "skipped"})
+//	PUT    /api/queue/{id}/position         move it in the queue ({"position": 0})
 func (h *Handler) handleQueueItem(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/queue/"), "/")
+	id := parts[0]
+	if id == "" {
+		http.Error(w, "missing download ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		h.Engine.CancelDownload(id)
+		writeJSON(w, map[string]interface{}{"status": true})
+	case len(parts) == 2 && parts[1] == "files" && r.Method == http.MethodGet:
+		files, err := h.Engine.Files(id)
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"files": files})
+	case len(parts) == 3 && parts[1] == "files" && r.Method == http.MethodPut:
+		h.setFileState(w, r, id, parts[2])
+	case len(parts) == 2 && parts[1] == "position" && r.Method == http.MethodPut:
+		h.moveQueueItem(w, r, id)
+	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) moveQueueItem(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "invalid JSON"})
 		return
 	}
-	id := strings.TrimPrefix(r.URL.Path, "/api/queue/")
-	if id == "" {
-		http.Error(w, "missing download ID", http.StatusBadRequest)
+	if _, err := h.QueueMgr.Move(id, req.Position); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+		return
+	}
+	h.Engine.Notify()
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
+func (h *Handler) setFileState(w http.ResponseWriter, r *http.Request, id, index string) {
+	files, err := h.Engine.Files(id)
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+		return
+	}
+	idx, err := strconv.Atoi(index)
+	if err != nil || idx < 0 || idx >= len(files) {
+		http.Error(w, "invalid file index", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "invalid JSON"})
+		return
+	}
+	if req.State != queue.FileWanted && req.State != queue.FileSkipped {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "state must be wanted or skipped"})
+		return
+	}
+	if err := h.QueueMgr.SetFileState(id, idx, req.State); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
 		return
 	}
-	h.Engine.CancelDownload(id)
 	writeJSON(w, map[string]interface{}{"status": true})
 }
 
@@ -406,6 +844,7 @@ func (h *Handler) listServers(w http.ResponseWriter, r *http.Request) {
 	servers := h.Config.GetServers()
 	writeJSON(w, map[string]interface{}{
 		"servers": servers,
+		"errors":  h.PoolMgr.ServerErrors(),
 	})
 }
 
@@ -503,7 +942,7 @@ func (h *Handler) handleTestServer(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := timeoutContext(10 * time.Second)
 	defer cancel()
 
-	if err := downloader.TestConnection(ctx, srv, vpnIface); err != nil {
+	if err := h.PoolMgr.TestServer(ctx, srv, vpnIface); err != nil {
 		writeJSON(w, map[string]interface{}{
 			"status":  false,
 			"error":   err.Error(),
@@ -538,48 +977,80 @@ func (h *Handler) getVPN(w http.ResponseWriter, r *http.Request) {
 		"enabled":   vpnCfg.Enabled,
 		"protocol":  vpnCfg.Protocol,
 		"interface": vpnCfg.Interface,
+		"tunnel_dns": vpnCfg.TunnelDNS,
+		"dns":       vpnCfg.DNS,
+		"kill_switch":        vpnCfg.KillSwitch,
+		"kill_switch_allow":  vpnCfg.KillSwitchAllow,
+		"kill_switch_shared": vpnCfg.KillSwitchShared,
 	}
 
-	// WireGuard: return config with has_* booleans instead of secrets
 	if vpnCfg.WireGuard != nil {
-		wg := vpnCfg.WireGuard
-		resp["wireguard"] = map[string]interface{}{
-			"has_private_key":    wg.PrivateKey != "",
-			"address":           wg.Address,
-			"dns":               wg.DNS,
-			"listen_port":       wg.ListenPort,
-			"has_peer_public_key": wg.PeerPublicKey != "",
-			"peer_endpoint":     wg.PeerEndpoint,
-			"has_preshared_key": wg.PresharedKey != "",
-			"allowed_ips":       wg.AllowedIPs,
-			"persistent_keepalive": wg.PersistentKeepalive,
-		}
+		resp["wireguard"] = maskWireGuard(vpnCfg.WireGuard)
 	}
-
-	// OpenVPN: return config with has_* booleans instead of secrets
 	if vpnCfg.OpenVPN != nil {
-		ov := vpnCfg.OpenVPN
-		resp["openvpn"] = map[string]interface{}{
-			"remote_host":      ov.RemoteHost,
-			"remote_port":      ov.RemotePort,
-			"protocol":         ov.Protocol,
-			"auth_type":        ov.AuthType,
-			"has_username":     ov.Username != "",
-			"has_password":     ov.Password != "",
-			"has_ca_cert":      ov.CACert != "",
-			"has_client_cert":  ov.ClientCert != "",
-			"has_client_key":   ov.ClientKey != "",
-			"has_tls_auth":     ov.TLSAuth != "",
-			"cipher":           ov.Cipher,
-			"auth":             ov.Auth,
-			"compress":         ov.Compress,
-			"device_type":      ov.DeviceType,
+		resp["openvpn"] = maskOpenVPN(vpnCfg.OpenVPN)
+	}
+
+	profiles := make([]map[string]interface{}, 0, len(vpnCfg.Profiles))
+	for _, p := range vpnCfg.Profiles {
+		profile := map[string]interface{}{"name": p.Name, "protocol": p.Protocol}
+		if p.WireGuard != nil {
+			profile["wireguard"] = maskWireGuard(p.WireGuard)
+		}
+		if p.OpenVPN != nil {
+			profile["openvpn"] = maskOpenVPN(p.OpenVPN)
 		}
+		profiles = append(profiles, profile)
 	}
+	resp["profiles"] = profiles
+	resp["active_profile"] = vpnCfg.ActiveProfile
+	resp["failover_after"] = vpnCfg.FailoverAttempts()
+	resp["reconnect"] = vpnCfg.Reconnect
 
 	writeJSON(w, resp)
 }
 
+// maskWireGuard returns a WireGuard config with has_* booleans instead of
+// secrets.
+
+func maskWireGuard(wg *config.WireGuardConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"has_private_key":      wg.PrivateKey != "",
+		"address":              wg.Address,
+		"dns":                  wg.DNS,
+		"listen_port":          wg.ListenPort,
+		"has_peer_public_key":  wg.PeerPublicKey != "",
+		"peer_endpoint":        wg.PeerEndpoint,
+		"has_preshared_key":    wg.PresharedKey != "",
+		"allowed_ips":          wg.AllowedIPs,
+		"persistent_keepalive": wg.PersistentKeepalive,
+		"userspace":            wg.Userspace,
+	}
+}
+
+// maskOpenVPN returns an OpenVPN config with has_* booleans instead of
+// secrets.
+
+func maskOpenVPN(ov *config.OpenVPNConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"remote_host":     ov.RemoteHost,
+		"remote_port":     ov.RemotePort,
+		"protocol":        ov.Protocol,
+		"auth_type":       ov.AuthType,
+		"has_username":    ov.Username != "",
+		"has_password":    ov.Password != "",
+		"has_ca_cert":     ov.CACert != "",
+		"has_client_cert": ov.ClientCert != "",
+		"has_client_key":  ov.ClientKey != "",
+		"has_tls_auth":    ov.TLSAuth != "",
+		"cipher":          ov.Cipher,
+		"auth":            ov.Auth,
+		"compress":        ov.Compress,
+		"device_type":     ov.DeviceType,
+		"has_extra":       ov.Extra != "",
+	}
+}
+
 func (h *Handler) updateVPN(w http.ResponseWriter, r *http.Request) {
 	var req config.VPNConfig
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -590,36 +1061,26 @@ func (h *Handler) updateVPN(w http.ResponseWriter, r *http.Request) {
 	existing := h.Config.GetVPN()
 
 	// For managed protocols, merge secrets: empty fields = keep existing
-	if req.WireGuard != nil && existing.WireGuard != nil {
-		if req.WireGuard.PrivateKey == "" {
-			req.WireGuard.PrivateKey = existing.WireGuard.PrivateKey
-		}
-		if req.WireGuard.PeerPublicKey == "" {
-			req.WireGuard.PeerPublicKey = existing.WireGuard.PeerPublicKey
-		}
-		if req.WireGuard.PresharedKey == "" {
-			req.WireGuard.PresharedKey = existing.WireGuard.PresharedKey
+	mergeWireGuardSecrets(req.WireGuard, existing.WireGuard)
+	mergeOpenVPNSecrets(req.OpenVPN, existing.OpenVPN)
+
+	// Profiles are matched by name; a request without any keeps the
+	// existing ones, so forms that only know the top-level fields are safe
+	if req.Profiles == nil {
+		req.Profiles = existing.Profiles
+	} else {
+		for i := range req.Profiles {
+			for _, old := range existing.Profiles {
+				if old.Name == req.Profiles[i].Name {
+					mergeWireGuardSecrets(req.Profiles[i].WireGuard, old.WireGuard)
+					mergeOpenVPNSecrets(req.Profiles[i].OpenVPN, old.OpenVPN)
+					break
+				}
+			}
 		}
 	}
-	if req.OpenVPN != nil && existing.OpenVPN != nil {
-		if req.OpenVPN.Username == "" {
-			req.OpenVPN.Username = existing.OpenVPN.Username
-		}
-		if req.OpenVPN.Password == "" {
-			req.OpenVPN.Password = existing.OpenVPN.Password
-		}
-		if req.OpenVPN.CACert == "" {
-			req.OpenVPN.CACert = existing.OpenVPN.CACert
-		}
-		if req.OpenVPN.ClientCert == "" {
-			req.OpenVPN.ClientCert = existing.OpenVPN.ClientCert
-		}
-		if req.OpenVPN.ClientKey == "" {
-			req.OpenVPN.ClientKey = existing.OpenVPN.ClientKey
-		}
-		if req.OpenVPN.TLSAuth == "" {
-			req.OpenVPN.TLSAuth = existing.OpenVPN.TLSAuth
-		}
+	if req.ActiveProfile == "" {
+		req.ActiveProfile = existing.ActiveProfile
 	}
 
 	h.Config.SetVPN(req)
@@ -638,6 +1099,93 @@ func (h *Handler) updateVPN(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]interface{}{"status": true})
 }
 
+// handleVPNImport adds a VPN profile read from a wg-quick .conf or .ovpn file
+// sent as the request body, replacing the profile of the same name. Files an
+// .ovpn refers to can't be read from here and must be inlined.
+func (h *Handler) handleVPNImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "profile too large"})
+		return
+	}
+	profile, err := vpn.ImportProfile(data, nil)
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": err.Error()})
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = profile.Protocol
+	}
+	vpnCfg := h.Config.GetVPN()
+	profile.Apply(&vpnCfg, name)
+	h.Config.SetVPN(vpnCfg)
+	if err := h.Config.Save(); err != nil {
+		log.Printf("Error saving config: %v", err)
+		writeJSON(w, map[string]interface{}{"status": false, "error": "failed to save config"})
+		return
+	}
+	if h.VPNMgr != nil {
+		h.VPNMgr.Reconfigure()
+	}
+
+	log.Printf("VPN profile imported (protocol: %s, %d settings ignored)", profile.Protocol, len(profile.Ignored))
+	ignored := profile.Ignored
+	if ignored == nil {
+		ignored = []string{}
+	}
+	writeJSON(w, map[string]interface{}{"status": true, "protocol": profile.Protocol, "ignored": ignored})
+}
+
+// mergeWireGuardSecrets fills secrets left empty in req from existing.
+func mergeWireGuardSecrets(req, existing *config.WireGuardConfig) {
+	if req == nil || existing == nil {
+		return
+	}
+	if req.PrivateKey == "" {
+		req.PrivateKey = existing.PrivateKey
+	}
+	if req.PeerPublicKey == "" {
+		req.PeerPublicKey = existing.PeerPublicKey
+	}
+	if req.PresharedKey == "" {
+		req.PresharedKey = existing.PresharedKey
+	}
+}
+
+// mergeOpenVPNSecrets fills secrets left empty in req from existing.
+func mergeOpenVPNSecrets(req, existing *config.OpenVPNConfig) {
+	if req == nil || existing == nil {
+		return
+	}
+	if req.Username == "" {
+		req.Username = existing.Username
+	}
+	if req.Password == "" {
+		req.Password = existing.Password
+	}
+	if req.CACert == "" {
+		req.CACert = existing.CACert
+	}
+	if req.ClientCert == "" {
+		req.ClientCert = existing.ClientCert
+	}
+	if req.ClientKey == "" {
+		req.ClientKey = existing.ClientKey
+	}
+	if req.TLSAuth == "" {
+		req.TLSAuth = existing.TLSAuth
+	}
+	if req.Extra == "" {
+		req.Extra = existing.Extra
+	}
+}
+
 func (h *Handler) handleVPNConnect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -652,7 +1200,7 @@ func (h *Handler) handleVPNConnect(w http.ResponseWriter, r *http.Request) {
 	if !h.VPNMgr.IsManaged() {
 		// Config may have been saved but manager not yet reconfigured
 		vpnCfg := h.Config.GetVPN()
-		if vpnCfg.Protocol == "" {
+		if len(vpnCfg.ProfileList()) == 0 {
 			writeJSON(w, map[string]interface{}{"status": false, "error": "VPN is in passive mode — configure a protocol first, then save"})
 			return
 		}
@@ -704,6 +1252,50 @@ func (h *Handler) handleVPNDisconnect(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]interface{}{"status": true})
 }
 
+// handleVPNProfile switches the tunnel to another configured profile.
+func (h *Handler) handleVPNProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, map[string]interface{}{"status": false, "error": "invalid JSON"})
+		return
+	}
+
+	vpnCfg := h.Config.GetVPN()
+	found := false
+	for _, p := range vpnCfg.ProfileList() {
+		if p.Name == req.Name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeJSON(w, map[string]interface{}{"status": false, "error": fmt.Sprintf("no VPN profile named %q", req.Name)})
+		return
+	}
+
+	vpnCfg.ActiveProfile = req.Name
+	h.Config.SetVPN(vpnCfg)
+	if err := h.Config.Save(); err != nil {
+		log.Printf("Error saving config: %v", err)
+		writeJSON(w, map[string]interface{}{"status": false, "error": "failed to save config"})
+		return
+	}
+
+	if h.VPNMgr != nil {
+		h.VPNMgr.Reconfigure()
+	}
+
+	log.Printf("VPN profile switched to %q", req.Name)
+	writeJSON(w, map[string]interface{}{"status": true})
+}
+
 func (h *Handler) handleVPNStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -725,6 +1317,23 @@ func (h *Handler) handleVPNStatus(w http.ResponseWriter, r *http.Request) {
 		"error":          cs.Error,
 		"managed":        h.VPNMgr.IsManaged(),
 	}
+	if health := h.VPNMgr.Health(); health != nil {
+		resp["health"] = health
+	}
+	if checks := h.VPNMgr.LeakChecks(); len(checks) > 0 {
+		resp["leak_check"] = checks[len(checks)-1]
+		resp["leak_checks"] = checks
+	}
+	if attempts := h.VPNMgr.Reconnects(); len(attempts) > 0 {
+		resp["reconnects"] = attempts
+	}
+	if next := h.VPNMgr.NextReconnect(); !next.IsZero() {
+		resp["next_reconnect"] = next.Format(time.RFC3339)
+	}
+	if profiles := h.VPNMgr.Profiles(); len(profiles) > 0 {
+		resp["profile"] = h.VPNMgr.ActiveProfile()
+		resp["profiles"] = profiles
+	}
 	if !cs.ConnectedAt.IsZero() {
 		resp["connected_at"] = cs.ConnectedAt.Format(time.RFC3339)
 		resp["uptime_seconds"] = int(time.Since(cs.ConnectedAt).Seconds())
@@ -737,13 +1346,21 @@ func timeoutContext(d time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), d)
 }
 
-func mapStatusToSAB(status string) string {
+func mapStatusToSAB(status, stage string) string {
 	switch status {
 	case queue.StatusQueued:
 		return "Queued"
+	case queue.StatusPaused:
+		return "Paused"
 	case queue.StatusDownloading:
 		return "Downloading"
 	case queue.StatusProcessing:
+		switch stage {
+		case queue.StageVerifying:
+			return "Verifying"
+		case queue.StageRepairing:
+			return "Repairing"
+		}
 		return "Extracting"
 	default:
 		return status
@@ -761,6 +1378,11 @@ func mapStatusToSABHistory(status string) string {
 	}
 }
 
+// writeSABError writes a SABnzbd API error.
+func writeSABError(w http.ResponseWriter, msg string) {
+	writeJSON(w, map[string]interface{}{"status": false, "error": msg})
+}
+
 func writeJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(v)
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nzb-connect/internal/downloader"
+	"nzb-connect/internal/queue"
+)
+
+// newTestHandler returns a Handler on a fresh queue, with an engine that
+// isn't started so nothing downloads.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	qm, err := queue.NewManager(filepath.Join(dir, "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qm.Close() })
+	h := newAuthHandler("", "")
+	h.QueueMgr = qm
+	h.PoolMgr = downloader.NewPoolManager("")
+	h.Engine = downloader.NewEngine(h.PoolMgr, qm, filepath.Join(dir, "incomplete"), filepath.Join(dir, "tmp"))
+	return h
+}
+
+// sabCall makes a SABnzbd API request with the API key and returns the
+// decoded response.
+func sabCall(t *testing.T, h *Handler, params url.Values) map[string]interface{} {
+	t.Helper()
+	params.Set("apikey", testAPIKey)
+	w := httptest.NewRecorder()
+	h.handleSABnzbd(w, httptest.NewRequest(http.MethodGet, "/api?"+params.Encode(), nil))
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: decoding %q: %v", params, w.Body, err)
+	}
+	return resp
+}
+
+// addTestDownload queues a download whose files are in a directory of its
+// own, and returns that directory.
+func addTestDownload(t *testing.T, h *Handler, id string) string {
+	t.Helper()
+	if err := h.QueueMgr.Add(&queue.Download{ID: id, Name: id}); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.QueueMgr.UpdatePath(id, dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func status(t *testing.T, h *Handler, id string) string {
+	t.Helper()
+	dl, err := h.QueueMgr.Get(id)
+	if err != nil {
+		return "deleted"
+	}
+	return dl.Status
+}
+
+func TestSABPriority(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		name string
+	}{
+		{"2", queue.PriorityForce, "Force"},
+		{"1", queue.PriorityHigh, "High"},
+		{"0", queue.PriorityNormal, "Normal"},
+		{"-1", queue.PriorityLow, "Low"},
+		{"-100", queue.PriorityNormal, "Normal"}, // "default"
+		{"-2", queue.PriorityNormal, "Normal"},   // paused, not supported
+		{"3", queue.PriorityNormal, "Normal"},
+		{"", queue.PriorityNormal, "Normal"},
+		{"high", queue.PriorityNormal, "Normal"},
+	}
+	for _, tt := range tests {
+		got := parsePriority(tt.in)
+		if got != tt.want {
+			t.Errorf("parsePriority(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if name := priorityName(got); name != tt.name {
+			t.Errorf("priorityName(%d) = %q, want %q", got, name, tt.name)
+		}
+	}
+}
+
+func TestSABQueueDelete(t *testing.T) {
+	h := newTestHandler(t)
+	keep := addTestDownload(t, h, "keep")
+	remove := addTestDownload(t, h, "remove")
+	addTestDownload(t, h, "done")
+	if err := h.QueueMgr.UpdateStatus("done", queue.StatusCompleted); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"delete"}, "value": {"keep"}})
+	if resp["status"] != true || status(t, h, "keep") != "deleted" {
+		t.Errorf("delete: %v, keep is %s", resp, status(t, h, "keep"))
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Error("files deleted without del_files")
+	}
+
+	resp = sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"delete"}, "value": {"remove"}, "del_files": {"1"}})
+	if resp["status"] != true || status(t, h, "remove") != "deleted" {
+		t.Errorf("delete with del_files: %v", resp)
+	}
+	if _, err := os.Stat(remove); err == nil {
+		t.Error("files kept with del_files=1")
+	}
+
+	// The queue can't delete from the history
+	resp = sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"delete"}, "value": {"done"}})
+	if resp["status"] != false || status(t, h, "done") != queue.StatusCompleted {
+		t.Errorf("delete of a history item through the queue: %v", resp)
+	}
+	if resp := sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"delete"}}); resp["error"] != "no nzo_id given" {
+		t.Errorf("delete without value: %v", resp)
+	}
+}
+
+func TestSABQueuePauseResume(t *testing.T) {
+	h := newTestHandler(t)
+	addTestDownload(t, h, "a")
+	addTestDownload(t, h, "b")
+
+	resp := sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"pause"}, "value": {"a,b"}})
+	if resp["status"] != true {
+		t.Fatalf("pause: %v", resp)
+	}
+	for _, id := range []string{"a", "b"} {
+		if got := status(t, h, id); got != queue.StatusPaused {
+			t.Errorf("%s is %s after pause", id, got)
+		}
+	}
+	// Pausing single jobs leaves the queue running
+	if h.QueueMgr.IsPaused() {
+		t.Error("the whole queue was paused")
+	}
+
+	resp = sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"resume"}, "value": {"b"}})
+	if resp["status"] != true || status(t, h, "a") != queue.StatusPaused || status(t, h, "b") != queue.StatusQueued {
+		t.Errorf("resume b: %v, a %s, b %s", resp, status(t, h, "a"), status(t, h, "b"))
+	}
+	if resp := sabCall(t, h, url.Values{"mode": {"queue"}, "name": {"resume"}, "value": {"b"}}); resp["status"] != false {
+		t.Errorf("resuming a queued job: %v", resp)
+	}
+}
+
+func TestSABRetry(t *testing.T) {
+	h := newTestHandler(t)
+	addTestDownload(t, h, "a")
+	if err := h.QueueMgr.SetError("a", "missing articles"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := sabCall(t, h, url.Values{"mode": {"retry"}, "value": {"a"}})
+	if resp["status"] != true || resp["nzo_id"] != "a" || status(t, h, "a") != queue.StatusQueued {
+		t.Errorf("retry: %v, a is %s", resp, status(t, h, "a"))
+	}
+	if resp := sabCall(t, h, url.Values{"mode": {"retry"}, "value": {"a"}}); resp["status"] != false {
+		t.Errorf("retrying a queued job: %v", resp)
+	}
+}
+
+func TestSABSpeedLimit(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config.Downloads.LineSpeed = 10000 // KB/s
+
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"500K", 500 * 1024},
+		{"2M", 2 * 1024 * 1024},
+		{"50", 5000 * 1024}, // percent of the line speed
+		{"100", 0},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		resp := sabCall(t, h, url.Values{"mode": {"config"}, "name": {"speedlimit"}, "value": {tt.value}})
+		if resp["status"] != true {
+			t.Errorf("speedlimit %s: %v", tt.value, resp)
+		}
+		if got := h.Engine.SpeedLimit(); got != tt.want {
+			t.Errorf("speedlimit %s: limit %d, want %d", tt.value, got, tt.want)
+		}
+	}
+
+	if resp := sabCall(t, h, url.Values{"mode": {"config"}, "name": {"speedlimit"}, "value": {"fast"}}); resp["status"] != false {
+		t.Errorf("invalid speed limit accepted: %v", resp)
+	}
+	h.Config.Downloads.LineSpeed = 0
+	if resp := sabCall(t, h, url.Values{"mode": {"config"}, "name": {"speedlimit"}, "value": {"50"}}); resp["status"] != false {
+		t.Errorf("percentage accepted without a line speed: %v", resp)
+	}
+}
+
+func TestSABNotImplemented(t *testing.T) {
+	h := newTestHandler(t)
+	for _, params := range []url.Values{
+		{"mode": {"restart"}},
+		{"mode": {"config"}, "name": {"set_colorscheme"}},
+	} {
+		resp := sabCall(t, h, params)
+		if resp["status"] != false || resp["error"] != "not implemented" {
+			t.Errorf("%v: %v, want not implemented", params, resp)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A minimal XML-RPC codec for the NZBGet API. Decoded values use the same Go
+// types as encoding/json (string, bool, float64, []interface{},
+// map[string]interface{}), so methods can share their argument handling
+// between the JSON-RPC and XML-RPC endpoints. base64 values are kept as
+// their base64 text, as NZBGet clients send NZB content either way.
+
+type xmlrpcCall struct {
+	MethodName string        `xml:"methodName"`
+	Params     []xmlrpcValue `xml:"params>param>value"`
+}
+
+type xmlrpcValue struct {
+	String   *string       `xml:"string"`
+	Int      *string       `xml:"int"`
+	I4       *string       `xml:"i4"`
+	I8       *string       `xml:"i8"`
+	Boolean  *string       `xml:"boolean"`
+	Double   *string       `xml:"double"`
+	Base64   *string       `xml:"base64"`
+	DateTime *string       `xml:"dateTime.iso8601"`
+	Array    *xmlrpcArray  `xml:"array"`
+	Struct   *xmlrpcStruct `xml:"struct"`
+	Text     string        `xml:",chardata"`
+}
+
+type xmlrpcArray struct {
+	Values []xmlrpcValue `xml:"data>value"`
+}
+
+type xmlrpcStruct struct {
+	Members []struct {
+		Name  string      `xml:"name"`
+		Value xmlrpcValue `xml:"value"`
+	} `xml:"member"`
+}
+
+// decodeXMLRPCCall reads a methodCall and returns the method and parameters.
+func decodeXMLRPCCall(r io.Reader) (string, []interface{}, error) {
+	var call xmlrpcCall
+	if err := xml.NewDecoder(r).Decode(&call); err != nil {
+		return "", nil, fmt.Errorf("invalid XML-RPC request: %w", err)
+	}
+	params := make([]interface{}, len(call.Params))
+	for i, v := range call.Params {
+		p, err := v.decode()
+		if err != nil {
+			return "", nil, err
+		}
+		params[i] = p
+	}
+	return call.MethodName, params, nil
+}
+
+func (v xmlrpcValue) decode() (interface{}, error) {
+	number := func(s string) (interface{}, error) {
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML-RPC number %q", s)
+		}
+		return n, nil
+	}
+	switch {
+	case v.String != nil:
+		return *v.String, nil
+	case v.Int != nil:
+		return number(*v.Int)
+	case v.I4 != nil:
+		return number(*v.I4)
+	case v.I8 != nil:
+		return number(*v.I8)
+	case v.Double != nil:
+		return number(*v.Double)
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1", nil
+	case v.Base64 != nil:
+		return strings.TrimSpace(*v.Base64), nil
+	case v.DateTime != nil:
+		return *v.DateTime, nil
+	case v.Array != nil:
+		arr := make([]interface{}, len(v.Array.Values))
+		for i, e := range v.Array.Values {
+			d, err := e.decode()
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = d
+		}
+		return arr, nil
+	case v.Struct != nil:
+		m := make(map[string]interface{}, len(v.Struct.Members))
+		for _, mem := range v.Struct.Members {
+			d, err := mem.Value.decode()
+			if err != nil {
+				return nil, err
+			}
+			m[mem.Name] = d
+		}
+		return m, nil
+	default:
+		// A value without a type element is a string
+		return v.Text, nil
+	}
+}
+
+// encodeXMLRPCResponse writes a methodResponse holding result.
+func encodeXMLRPCResponse(w io.Writer, result interface{}) error {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><methodResponse><params><param>`)
+	if err := encodeXMLRPCValue(&b, result); err != nil {
+		return err
+	}
+	b.WriteString(`</param></params></methodResponse>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// encodeXMLRPCFault writes a methodResponse holding a fault.
+func encodeXMLRPCFault(w io.Writer, code int, msg string) error {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><methodResponse><fault>`)
+	encodeXMLRPCValue(&b, map[string]interface{}{"faultCode": code, "faultString": msg})
+	b.WriteString(`</fault></methodResponse>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func encodeXMLRPCValue(b *bytes.Buffer, v interface{}) error {
+	b.WriteString("<value>")
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("<nil/>")
+	case string:
+		b.WriteString("<string>")
+		xml.EscapeText(b, []byte(v))
+		b.WriteString("</string>")
+	case bool:
+		if v {
+			b.WriteString("<boolean>1</boolean>")
+		} else {
+			b.WriteString("<boolean>0</boolean>")
+		}
+	case int:
+		writeXMLRPCInt(b, int64(v))
+	case int64:
+		writeXMLRPCInt(b, v)
+	case float64:
+		fmt.Fprintf(b, "<double>%s</double>", strconv.FormatFloat(v, 'f', -1, 64))
+	case []interface{}:
+		b.WriteString("<array><data>")
+		for _, e := range v {
+			if err := encodeXMLRPCValue(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteString("</data></array>")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("<struct>")
+		for _, k := range keys {
+			b.WriteString("<member><name>")
+			xml.EscapeText(b, []byte(k))
+			b.WriteString("</name>")
+			if err := encodeXMLRPCValue(b, v[k]); err != nil {
+				return err
+			}
+			b.WriteString("</member>")
+		}
+		b.WriteString("</struct>")
+	default:
+		return fmt.Errorf("xmlrpc: can't encode %T", v)
+	}
+	b.WriteString("</value>")
+	return nil
+}
+
+func writeXMLRPCInt(b *bytes.Buffer, n int64) {
+	if n >= math.MinInt32 && n <= math.MaxInt32 {
+		fmt.Fprintf(b, "<i4>%d</i4>", n)
+	} else {
+		fmt.Fprintf(b, "<i8>%d</i8>", n)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeXMLRPCCall(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<methodCall>
+  <methodName>append</methodName>
+  <params>
+    <param><value><string>a &amp; b.nzb</string></value></param>
+    <param><value>untyped</value></param>
+    <param><value><int>-50</int></value></param>
+    <param><value><i4>7</i4></value></param>
+    <param><value><i8>6000000000</i8></value></param>
+    <param><value><double>1.5</double></value></param>
+    <param><value><boolean>1</boolean></value></param>
+    <param><value><boolean>0</boolean></value></param>
+    <param><value><base64>
+      PG56Yi8+
+    </base64></value></param>
+    <param><value><array><data>
+      <value><i4>1</i4></value>
+      <value><string>x</string></value>
+    </data></array></value></param>
+    <param><value><struct>
+      <member><name>Name</name><value><string>drone</string></value></member>
+      <member><name>Value</name><value><string>abc</string></value></member>
+    </struct></value></param>
+  </params>
+</methodCall>`
+	method, params, err := decodeXMLRPCCall(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "append" {
+		t.Errorf("method = %q", method)
+	}
+	want := []interface{}{
+		"a & b.nzb",
+		"untyped",
+		-50.0,
+		7.0,
+		6000000000.0,
+		1.5,
+		true,
+		false,
+		"PG56Yi8+",
+		[]interface{}{1.0, "x"},
+		map[string]interface{}{"Name": "drone", "Value": "abc"},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params =\n%#v\nwant\n%#v", params, want)
+	}
+
+	for _, bad := range []string{
+		`<methodCall><methodName>x</methodName><params><param><value><int>abc</int></value></param></params></methodCall>`,
+		`<methodCall><methodName>x`,
+	} {
+		if _, _, err := decodeXMLRPCCall(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error decoding %q", bad)
+		}
+	}
+}
+
+func TestEncodeXMLRPCResponse(t *testing.T) {
+	var b bytes.Buffer
+	err := encodeXMLRPCResponse(&b, []interface{}{
+		map[string]interface{}{"b": int64(6000000000), "a": 42, "c": "<&>"},
+		true, 1.25, nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0"?><methodResponse><params><param><value><array><data>` +
+		`<value><struct>` +
+		`<member><name>a</name><value><i4>42</i4></value></member>` +
+		`<member><name>b</name><value><i8>6000000000</i8></value></member>` +
+		`<member><name>c</name><value><string>&lt;&amp;&gt;</string></value></member>` +
+		`</struct></value>` +
+		`<value><boolean>1</boolean></value><value><double>1.25</double></value><value><nil/></value>` +
+		`</data></array></value></param></params></methodResponse>`
+	if b.String() != want {
+		t.Errorf("response =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	if err := encodeXMLRPCResponse(&b, struct{}{}); err == nil {
+		t.Error("expected an error encoding an unsupported type")
+	}
+}
+
+func TestEncodeXMLRPCFault(t *testing.T) {
+	var b bytes.Buffer
+	if err := encodeXMLRPCFault(&b, 1, "access denied"); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0"?><methodResponse><fault><value><struct>` +
+		`<member><name>faultCode</name><value><i4>1</i4></value></member>` +
+		`<member><name>faultString</name><value><string>access denied</string></value></member>` +
+		`</struct></value></fault></methodResponse>`
+	if b.String() != want {
+		t.Errorf("fault =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHandleXMLRPC(t *testing.T) {
+	h := newTestHandler(t)
+	call := func(pass, method, params string) (int, string) {
+		t.Helper()
+		body := `<?xml version="1.0"?><methodCall><methodName>` + method +
+			`</methodName><params>` + params + `</params></methodCall>`
+		r := httptest.NewRequest(http.MethodPost, "/xmlrpc", strings.NewReader(body))
+		r.SetBasicAuth("nzbget", pass)
+		w := httptest.NewRecorder()
+		h.handleXMLRPC(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := call(testAPIKey, "version", ""); code != http.StatusOK ||
+		!strings.Contains(body, "<params><param><value><string>"+nzbgetVersion+"</string>") {
+		t.Errorf("version: %d %s", code, body)
+	}
+	add := `<param><value><string>show.nzb</string></value></param>` +
+		`<param><value><base64>` + testNZBBase64 + `</base64></value></param>`
+	if code, body := call(testNZBKey, "append", add); code != http.StatusOK || !strings.Contains(body, "<i4>1</i4>") {
+		t.Errorf("append with the NZB key: %d %s", code, body)
+	}
+	if code, body := call(testAPIKey, "listgroups", ""); code != http.StatusOK ||
+		!strings.Contains(body, "<name>NZBName</name><value><string>show</string>") {
+		t.Errorf("listgroups: %d %s", code, body)
+	}
+
+	faults := []struct {
+		name, pass, method, want string
+	}{
+		{"nzb key", testNZBKey, "listgroups", "access denied"},
+		{"unknown method", testAPIKey, "scan", "scan"},
+	}
+	for _, f := range faults {
+		code, body := call(f.pass, f.method, "")
+		if code != http.StatusOK || !strings.Contains(body, "<fault>") || !strings.Contains(body, f.want) {
+			t.Errorf("%s: %d %s, want a fault mentioning %q", f.name, code, body, f.want)
+		}
+	}
+
+	if code, _ := call("wrong", "version", ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", code)
+	}
+}
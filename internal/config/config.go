@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/user"
@@ -82,8 +84,11 @@ type Config struct {
 	VPN         VPNConfig         `yaml:"vpn"`
 	Servers     []ServerConfig    `yaml:"servers"`
 	Paths       PathsConfig       `yaml:"paths"`
+	Downloads   DownloadsConfig   `yaml:"downloads"`
+	Categories  []string          `yaml:"categories,omitempty"`
 	Web         WebConfig         `yaml:"web"`
 	PostProcess PostProcessConfig `yaml:"postprocess"`
+	Preflight   PreflightConfig   `yaml:"preflight"`
 }
 
 type VPNConfig struct {
@@ -92,8 +97,85 @@ type VPNConfig struct {
 	Protocol    string           `yaml:"protocol" json:"protocol"`     // "wireguard", "openvpn", or "" (passive/legacy)
 	Interface   string           `yaml:"interface" json:"interface"`   // legacy passive mode only
 	AutoConnect *bool            `yaml:"auto_connect,omitempty" json:"auto_connect,omitempty"` // nil = connect (default); false = stay disconnected on restart
+	TunnelDNS   bool             `yaml:"tunnel_dns,omitempty" json:"tunnel_dns,omitempty"`     // resolve news servers through the tunnel instead of the system resolver
+	DNS         string           `yaml:"dns,omitempty" json:"dns,omitempty"`                   // tunnel DNS servers, comma-separated; default: the WireGuard DNS or what OpenVPN pushes
 	WireGuard   *WireGuardConfig `yaml:"wireguard,omitempty" json:"wireguard,omitempty"`
 	OpenVPN     *OpenVPNConfig   `yaml:"openvpn,omitempty" json:"openvpn,omitempty"`
+
+	KillSwitch       string   `yaml:"kill_switch,omitempty" json:"kill_switch,omitempty"`               // "nftables" to reject our traffic outside the tunnel, "dry-run" to only log the rules
+	KillSwitchAllow  []string `yaml:"kill_switch_allow,omitempty" json:"kill_switch_allow,omitempty"`   // networks (CIDR) still reachable with the kill switch on, e.g. the LAN
+	KillSwitchShared bool     `yaml:"kill_switch_shared,omitempty" json:"kill_switch_shared,omitempty"` // apply the kill switch even without a cgroup of our own, blocking the processes we share it (or our UID) with
+
+	Profiles      []VPNProfile    `yaml:"profiles,omitempty" json:"profiles,omitempty"`             // tunnels in priority order; replaces protocol/wireguard/openvpn above
+	ActiveProfile string          `yaml:"active_profile,omitempty" json:"active_profile,omitempty"` // profile to connect with first; set when switching by hand
+	FailoverAfter int             `yaml:"failover_after,omitempty" json:"failover_after,omitempty"` // failed reconnects before moving to the next profile (default 3)
+	Reconnect     ReconnectConfig `yaml:"reconnect,omitempty" json:"reconnect,omitempty"`           // how a dropped tunnel is brought back
+
+	Health          HealthConfig `yaml:"health,omitempty" json:"health,omitempty"`                       // active probes through the tunnel
+	LeakCheckURL    string       `yaml:"leak_check_url,omitempty" json:"leak_check_url,omitempty"`       // echo endpoint returning the caller's IP; downloads stay paused while it's the same through the tunnel, and resume when it differs or there's no direct address to compare
+	LeakCheckStrict bool         `yaml:"leak_check_strict,omitempty" json:"leak_check_strict,omitempty"` // also stay paused when there's no direct address to compare
+}
+
+// ReconnectConfig sets how a managed tunnel that dropped is brought back: a
+// round of attempts with a doubling wait between them and, with a cooldown,
+// another round after it rather than giving up.
+type ReconnectConfig struct {
+	MaxAttempts    int     `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`       // attempts per round (default 10); at least failover_after × profiles, so every profile gets its turn; -1 = unlimited
+	InitialBackoff int     `yaml:"initial_backoff,omitempty" json:"initial_backoff,omitempty"` // seconds before the first attempt (default 5)
+	MaxBackoff     int     `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`         // seconds the wait doubles up to (default 60)
+	Jitter         float64 `yaml:"jitter,omitempty" json:"jitter,omitempty"`                   // fraction of each wait added or taken off at random, 0 to 1 (default 0)
+	Cooldown       int     `yaml:"cooldown,omitempty" json:"cooldown,omitempty"`               // seconds before another round once one has failed; 0 = give up
+}
+
+// HealthConfig configures probes that check the tunnel actually carries
+// traffic. Without any, only the interface's state is watched.
+type HealthConfig struct {
+	Interval int           `yaml:"interval,omitempty" json:"interval,omitempty"` // seconds between rounds (default 30)
+	Timeout  int           `yaml:"timeout,omitempty" json:"timeout,omitempty"`   // seconds per probe (default 10)
+	Failures int           `yaml:"failures,omitempty" json:"failures,omitempty"` // failures in a row before the tunnel counts as down (default 3)
+	Probes   []ProbeConfig `yaml:"probes,omitempty" json:"probes,omitempty"`
+}
+
+// ProbeConfig is one health probe.
+type ProbeConfig struct {
+	Type   string `yaml:"type" json:"type"`                           // "tcp", "handshake" or "http"
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`   // tcp: host:port, default the first enabled news server; http: URL
+	MaxAge int    `yaml:"max_age,omitempty" json:"max_age,omitempty"` // handshake: seconds since the last WireGuard handshake (default 180)
+}
+
+// VPNProfile is one tunnel the VPN manager can connect with.
+type VPNProfile struct {
+	Name      string           `yaml:"name" json:"name"`
+	Protocol  string           `yaml:"protocol" json:"protocol"` // "wireguard" or "openvpn"
+	WireGuard *WireGuardConfig `yaml:"wireguard,omitempty" json:"wireguard,omitempty"`
+	OpenVPN   *OpenVPNConfig   `yaml:"openvpn,omitempty" json:"openvpn,omitempty"`
+}
+
+// ProfileList returns the tunnels to connect with, in priority order: the
+// profiles list or, without one, the tunnel defined by Protocol, WireGuard
+// and OpenVPN, named after its protocol if it has no name. It is empty in
+// passive mode.
+func (v VPNConfig) ProfileList() []VPNProfile {
+	if len(v.Profiles) > 0 {
+		return v.Profiles
+	}
+	if v.Protocol == "" {
+		return nil
+	}
+	name := v.Name
+	if name == "" {
+		name = v.Protocol
+	}
+	return []VPNProfile{{Name: name, Protocol: v.Protocol, WireGuard: v.WireGuard, OpenVPN: v.OpenVPN}}
+}
+
+// FailoverAttempts returns how many reconnects fail before the next profile
+// is tried.
+func (v VPNConfig) FailoverAttempts() int {
+	if v.FailoverAfter > 0 {
+		return v.FailoverAfter
+	}
+	return 3
 }
 
 type WireGuardConfig struct {
@@ -106,6 +188,8 @@ type WireGuardConfig struct {
 	PresharedKey        string `yaml:"preshared_key,omitempty" json:"preshared_key,omitempty"`
 	AllowedIPs          string `yaml:"allowed_ips,omitempty" json:"allowed_ips,omitempty"`
 	PersistentKeepalive int    `yaml:"persistent_keepalive,omitempty" json:"persistent_keepalive,omitempty"`
+	Userspace           bool   `yaml:"userspace,omitempty" json:"userspace,omitempty"` // run in-process without root (builds with -tags wgnetstack)
+	Namespace           string `yaml:"namespace,omitempty" json:"namespace,omitempty"` // run the interface in this network namespace, leaving host routes and DNS alone
 }
 
 type OpenVPNConfig struct {
@@ -123,6 +207,7 @@ type OpenVPNConfig struct {
 	Auth       string `yaml:"auth,omitempty" json:"auth,omitempty"`
 	Compress   string `yaml:"compress,omitempty" json:"compress,omitempty"`
 	DeviceType string `yaml:"device_type,omitempty" json:"device_type,omitempty"` // "tun" or "tap"
+	Extra      string `yaml:"extra,omitempty" json:"extra,omitempty"`             // further config lines passed to openvpn as they are, e.g. from an imported .ovpn
 }
 
 type ServerConfig struct {
@@ -134,6 +219,8 @@ type ServerConfig struct {
 	Username    string `yaml:"username" json:"username"`
 	Password    string `yaml:"password" json:"password"`
 	Connections int    `yaml:"connections" json:"connections"`
+	Pipeline    int    `yaml:"pipeline,omitempty" json:"pipeline,omitempty"` // BODY requests kept in flight per connection; 0 = default
+	Priority    int    `yaml:"priority" json:"priority"`                     // 0 = primary; higher tiers only get articles the lower ones lack
 	Enabled     bool   `yaml:"enabled" json:"enabled"`
 }
 
@@ -143,25 +230,53 @@ type PathsConfig struct {
 	Temp       string `yaml:"temp"`
 }
 
+type DownloadsConfig struct {
+	MaxJobs   int `yaml:"max_jobs"`             // NZBs downloaded at once, sharing the server connections
+	LineSpeed int `yaml:"line_speed,omitempty"` // connection speed in KB/s; percentage speed limits are relative to it
+}
+
 type WebConfig struct {
 	Port     int    `yaml:"port"`
-	Username string `yaml:"username"`
+	Username string `yaml:"username"` // web UI login; empty disables it
 	Password string `yaml:"password"`
+	APIKey   string `yaml:"api_key"` // full access to the SABnzbd API; generated if empty
+	NZBKey   string `yaml:"nzb_key"` // may only add NZBs; generated if empty
 }
 
 type PostProcessConfig struct {
 	Unrar          string `yaml:"unrar"`
 	SevenZip       string `yaml:"sevenzip"`
+	Par2           string `yaml:"par2"`
 	DeleteArchives bool   `yaml:"delete_archives"`
 }
 
+// PreflightConfig controls the STAT availability check run before a job
+// starts downloading.
+type PreflightConfig struct {
+	Enabled      bool    `yaml:"enabled" json:"enabled"`
+	Sample       int     `yaml:"sample" json:"sample"`               // segments to check, spread across the job; 0 = all
+	MinAvailable float64 `yaml:"min_available" json:"min_available"` // percent of checked segments that must exist
+	// Categories overrides MinAvailable per category.
+	Categories map[string]float64 `yaml:"categories,omitempty" json:"categories,omitempty"`
+}
+
+// Threshold returns the minimum availability percentage for a category.
+func (p PreflightConfig) Threshold(category string) float64 {
+	if t, ok := p.Categories[category]; ok {
+		return t
+	}
+	return p.MinAvailable
+}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
-	cfg := &Config{filePath: path}
+	// Defaults that 0 is a valid setting for are filled in before parsing,
+	// so they only apply when the key is absent.
+	cfg := &Config{filePath: path, Preflight: PreflightConfig{MinAvailable: 95}}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parsing config file: %w", err)
 	}
@@ -170,6 +285,38 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// EnsureKeys generates missing API keys and saves the config if it did. Load
+// leaves them alone so that commands which only read the config don't
+// rewrite it.
+func (c *Config) EnsureKeys() error {
+	c.mu.Lock()
+	generated := false
+	if c.Web.APIKey == "" {
+		c.Web.APIKey = newKey()
+		generated = true
+	}
+	if c.Web.NZBKey == "" {
+		c.Web.NZBKey = newKey()
+		generated = true
+	}
+	c.mu.Unlock()
+
+	if !generated {
+		return nil
+	}
+	if err := c.Save(); err != nil {
+		return fmt.Errorf("saving generated API keys: %w", err)
+	}
+	return nil
+}
+
+// newKey returns a random 32-character hex key, like SABnzbd's.
+func newKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func (c *Config) setDefaults() {
 	if c.Web.Port == 0 {
 		c.Web.Port = 6789
@@ -199,6 +346,9 @@ func (c *Config) setDefaults() {
 			c.Paths.Temp = "/tmp/nzb-connect"
 		}
 	}
+	if c.Downloads.MaxJobs <= 0 {
+		c.Downloads.MaxJobs = 2
+	}
 	for i := range c.Servers {
 		if c.Servers[i].Connections == 0 {
 			c.Servers[i].Connections = 10
@@ -239,6 +389,47 @@ func (c *Config) SetVPN(vpn VPNConfig) {
 	c.VPN = vpn
 }
 
+func (c *Config) GetWeb() WebConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Web
+}
+
+// RotateKey replaces the API key ("apikey") or NZB key ("nzbkey") with a new
+// random one and returns it. The caller saves the config.
+func (c *Config) RotateKey(which string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := newKey()
+	switch which {
+	case "apikey":
+		c.Web.APIKey = key
+	case "nzbkey":
+		c.Web.NZBKey = key
+	default:
+		return "", fmt.Errorf("unknown key %q", which)
+	}
+	return key, nil
+}
+
+func (c *Config) GetPreflight() PreflightConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Preflight
+}
+
+func (c *Config) GetDownloads() DownloadsConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Downloads
+}
+
+func (c *Config) GetCategories() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.Categories...)
+}
+
 func (c *Config) GetServers() []ServerConfig {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
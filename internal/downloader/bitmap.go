@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// segmentBitmap records which segments of a file have been written to disk.
+// It is not safe for concurrent use; callers serialise access.
+type segmentBitmap struct {
+	bits []uint64
+	n    int
+}
+
+func newSegmentBitmap(n int) *segmentBitmap {
+	return &segmentBitmap{bits: make([]uint64, (n+63)/64), n: n}
+}
+
+// segmentBitmapFromBytes restores a bitmap saved with Bytes. Data of the
+// wrong length (e.g. from a different NZB) yields an empty bitmap.
+func segmentBitmapFromBytes(n int, data []byte) *segmentBitmap {
+	b := newSegmentBitmap(n)
+	if len(data) != len(b.bits)*8 {
+		return b
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	if rem := n % 64; rem != 0 {
+		b.bits[len(b.bits)-1] &= 1<<uint(rem) - 1
+	}
+	return b
+}
+
+// Bytes serialises the bitmap for persistence.
+func (b *segmentBitmap) Bytes() []byte {
+	out := make([]byte, len(b.bits)*8)
+	for i, w := range b.bits {
+		binary.LittleEndian.PutUint64(out[i*8:], w)
+	}
+	return out
+}
+
+// Set marks segment i as written.
+func (b *segmentBitmap) Set(i int) {
+	b.bits[i/64] |= 1 << (uint(i) % 64)
+}
+
+// Has reports whether segment i has been written.
+func (b *segmentBitmap) Has(i int) bool {
+	return b.bits[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// Count returns the number of segments written.
+func (b *segmentBitmap) Count() int {
+	total := 0
+	for _, w := range b.bits {
+		total += bits.OnesCount64(w)
+	}
+	return total
+}
+
+// Len returns the number of segments tracked.
+func (b *segmentBitmap) Len() int {
+	return b.n
+}
+
+// FirstMissing returns the index of the first segment not yet written, or -1
+// if every segment is present.
+func (b *segmentBitmap) FirstMissing() int {
+	for i := 0; i < b.n; i++ {
+		if !b.Has(i) {
+			return i
+		}
+	}
+	return -1
+}
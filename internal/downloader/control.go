@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"nzb-connect/internal/queue"
+)
+
+// Pause pauses the whole queue. Running downloads stop after their segments
+// in flight and resume where they left off; force-priority downloads carry
+// on.
+func (e *Engine) Pause() {
+	e.queueMgr.SetUserPaused(true)
+}
+
+// Resume resumes a queue paused with Pause.
+func (e *Engine) Resume() {
+	e.queueMgr.SetUserPaused(false)
+	e.Notify()
+}
+
+// PauseDownload holds one download in the queue, stopping it if it is
+// running. Progress is kept.
+func (e *Engine) PauseDownload(id string) error {
+	if err := e.queueMgr.Pause(id); err != nil {
+		return err
+	}
+	e.stopJob(id)
+	return nil
+}
+
+// ResumeDownload requeues a download paused with PauseDownload.
+func (e *Engine) ResumeDownload(id string) error {
+	if err := e.queueMgr.Resume(id); err != nil {
+		return err
+	}
+	e.Notify()
+	return nil
+}
+
+// Retry requeues a failed download.
+func (e *Engine) Retry(id string) error {
+	if err := e.queueMgr.Retry(id); err != nil {
+		return err
+	}
+	e.Notify()
+	return nil
+}
+
+// Delete removes a download from the queue or the history, stopping it first
+// if it is running. With deleteFiles set its files are removed as well,
+// unless it completed: finished downloads belong to the user.
+func (e *Engine) Delete(id string, deleteFiles bool) error {
+	dl, err := e.queueMgr.Get(id)
+	if err != nil {
+		return err
+	}
+	if dl.Status == queue.StatusProcessing {
+		return fmt.Errorf("%s is being post-processed", dl.Name)
+	}
+	e.stopJob(id)
+
+	// The job may have finished downloading before it was stopped
+	if dl, err = e.queueMgr.Get(id); err != nil {
+		return err
+	}
+	if dl.Status == queue.StatusProcessing {
+		return fmt.Errorf("%s is being post-processed", dl.Name)
+	}
+	if err := e.queueMgr.Delete(id); err != nil {
+		return err
+	}
+	if deleteFiles && dl.Path != "" && dl.Status != queue.StatusCompleted {
+		if err := os.RemoveAll(dl.Path); err != nil {
+			log.Printf("Error deleting files of %s: %v", dl.Name, err)
+		}
+	}
+	log.Printf("Deleted %s", dl.Name)
+	return nil
+}
+
+// SetSpeedLimit caps the combined download speed in bytes/sec; 0 removes the
+// cap.
+func (e *Engine) SetSpeedLimit(bytesPerSec int64) {
+	e.limiter.setRate(bytesPerSec)
+	if bytesPerSec > 0 {
+		log.Printf("Speed limit set to %d KB/s", bytesPerSec/1024)
+	} else {
+		log.Println("Speed limit removed")
+	}
+}
+
+// SpeedLimit returns the download speed cap in bytes/sec, or 0 if there is
+// none.
+func (e *Engine) SpeedLimit() int64 {
+	return e.limiter.getRate()
+}
+
+// stopJob cancels a running download and waits for it to stop. It does
+// nothing if the download isn't running.
+func (e *Engine) stopJob(id string) {
+	e.mu.Lock()
+	j, ok := e.activeDownloads[id]
+	e.mu.Unlock()
+	if ok {
+		j.cancel()
+		<-j.done
+	}
+}
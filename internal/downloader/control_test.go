@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nzb-connect/internal/queue"
+)
+
+func newTestEngine(t *testing.T) (*Engine, *queue.Manager) {
+	t.Helper()
+	dir := t.TempDir()
+	qm, err := queue.NewManager(filepath.Join(dir, "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qm.Close() })
+	return NewEngine(NewPoolManager(""), qm, filepath.Join(dir, "incomplete"), filepath.Join(dir, "tmp")), qm
+}
+
+// addWithFiles queues a download whose files are in a directory of its own
+// and returns that directory.
+func addWithFiles(t *testing.T, qm *queue.Manager, id string) string {
+	t.Helper()
+	if err := qm.Add(&queue.Download{ID: id, Name: id}); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file.rar"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := qm.UpdatePath(id, dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestEngineDelete(t *testing.T) {
+	e, qm := newTestEngine(t)
+	keep := addWithFiles(t, qm, "keep")
+	remove := addWithFiles(t, qm, "remove")
+	completed := addWithFiles(t, qm, "completed")
+	if err := qm.UpdateStatus("completed", queue.StatusCompleted); err != nil {
+		t.Fatal(err)
+	}
+	processing := addWithFiles(t, qm, "processing")
+	if err := qm.UpdateStatus("processing", queue.StatusProcessing); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Delete("keep", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qm.Get("keep"); err == nil {
+		t.Error("keep still in the queue")
+	}
+	if !exists(keep) {
+		t.Error("files deleted without deleteFiles")
+	}
+
+	if err := e.Delete("remove", true); err != nil {
+		t.Fatal(err)
+	}
+	if exists(remove) {
+		t.Error("files kept with deleteFiles")
+	}
+
+	// Finished downloads belong to the user
+	if err := e.Delete("completed", true); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(completed) {
+		t.Error("files of a completed download deleted")
+	}
+
+	if err := e.Delete("processing", true); err == nil {
+		t.Error("expected an error deleting a download being post-processed")
+	}
+	if _, err := qm.Get("processing"); err != nil || !exists(processing) {
+		t.Errorf("download being post-processed was removed: %v", err)
+	}
+
+	if err := e.Delete("missing", false); err == nil {
+		t.Error("expected an error for an unknown download")
+	}
+}
+
+func TestEnginePauseResumeDownload(t *testing.T) {
+	e, qm := newTestEngine(t)
+	if err := qm.Add(&queue.Download{ID: "a", Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := qm.UpdateProgress("a", 100, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.PauseDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	dl, _ := qm.Get("a")
+	if dl.Status != queue.StatusPaused {
+		t.Errorf("status = %q, want %q", dl.Status, queue.StatusPaused)
+	}
+	if next, err := qm.GetNextQueued(false); err != nil || next != nil {
+		t.Errorf("paused download still picked: %v, %v", next, err)
+	}
+	if err := e.PauseDownload("a"); err == nil {
+		t.Error("expected an error pausing a paused download")
+	}
+
+	if err := e.ResumeDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	dl, _ = qm.Get("a")
+	if dl.Status != queue.StatusQueued || dl.DownloadedBytes != 100 {
+		t.Errorf("after resume: status %q, %d bytes; want %q with progress kept", dl.Status, dl.DownloadedBytes, queue.StatusQueued)
+	}
+	if err := e.ResumeDownload("a"); err == nil {
+		t.Error("expected an error resuming a queued download")
+	}
+}
+
+func TestEngineRetry(t *testing.T) {
+	e, qm := newTestEngine(t)
+	for _, id := range []string{"failed", "queued"} {
+		if err := qm.Add(&queue.Download{ID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := qm.UpdateProgress("failed", 100, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := qm.SetError("failed", "missing articles"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Retry("failed"); err != nil {
+		t.Fatal(err)
+	}
+	dl, _ := qm.Get("failed")
+	if dl.Status != queue.StatusQueued || dl.ErrorMsg != "" || dl.DownloadedBytes != 0 {
+		t.Errorf("after retry: %+v, want queued from scratch", dl)
+	}
+	// Retried downloads go to the end of the queue
+	q, _ := qm.GetQueue()
+	if len(q) != 2 || q[1].ID != "failed" {
+		t.Errorf("queue = %v, want failed last", q)
+	}
+
+	if err := e.Retry("queued"); err == nil {
+		t.Error("expected an error retrying a download that hasn't failed")
+	}
+}
@@ -1,8 +1,8 @@
 package downloader
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +11,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"nzb-connect/internal/config"
+	"nzb-connect/internal/events"
 	"nzb-connect/internal/nzb"
 	"nzb-connect/internal/queue"
 )
@@ -24,14 +26,27 @@ type Engine struct {
 	workers      int
 
 	mu              sync.Mutex
-	currentSpeed    atomic.Int64 // bytes per second
 	cancel          context.CancelFunc
 	ctx             context.Context
 	wakeUp          chan struct{}
 	onComplete      func(dl *queue.Download)
-	activeDownloads map[string]context.CancelFunc // id → cancel, protected by mu
+	activeDownloads map[string]*job        // protected by mu
+	maxJobs         int                    // downloads run at once; protected by mu
+	preflight       config.PreflightConfig // protected by mu
+	limiter         rateLimiter
+	events          *events.Bus
 }
 
+// job is a download the engine is currently running.
+type job struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the job has stopped
+	speed  atomic.Int64  // bytes per second
+}
+
+// defaultMaxJobs is the number of downloads run at once unless configured.
+const defaultMaxJobs = 2
+
 // NewEngine creates a new download engine.
 func NewEngine(poolMgr *PoolManager, queueMgr *queue.Manager, incompleteDir, tempDir string) *Engine {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,19 +59,37 @@ func NewEngine(poolMgr *PoolManager, queueMgr *queue.Manager, incompleteDir, tem
 		ctx:             ctx,
 		cancel:          cancel,
 		wakeUp:          make(chan struct{}, 1),
-		activeDownloads: make(map[string]context.CancelFunc),
+		activeDownloads: make(map[string]*job),
+		maxJobs:         defaultMaxJobs,
 	}
 }
 
+// SetMaxJobs sets how many downloads run at once. They share the connection
+// pool, each getting an equal part of it. n <= 0 restores the default.
+func (e *Engine) SetMaxJobs(n int) {
+	if n <= 0 {
+		n = defaultMaxJobs
+	}
+	e.mu.Lock()
+	e.maxJobs = n
+	e.mu.Unlock()
+	e.Notify()
+}
+
+// SetEvents sets the bus download progress is published to.
+func (e *Engine) SetEvents(bus *events.Bus) {
+	e.events = bus
+}
+
 // CancelDownload stops a queued or in-progress download and marks it failed.
 func (e *Engine) CancelDownload(id string) {
 	// Mark as failed immediately so it won't be picked up by the process loop
-	_ = e.queueMgr.SetError(id, "cancelled by user")
+	_ = e.queueMgr.Cancel(id)
 
 	// If the download is actively running, cancel its context to stop goroutines
 	e.mu.Lock()
-	if cancel, ok := e.activeDownloads[id]; ok {
-		cancel()
+	if j, ok := e.activeDownloads[id]; ok {
+		j.cancel()
 	}
 	e.mu.Unlock()
 }
@@ -84,9 +117,26 @@ func (e *Engine) Notify() {
 	}
 }
 
-// CurrentSpeed returns the current download speed in bytes/sec.
+// CurrentSpeed returns the combined download speed of all jobs in bytes/sec.
 func (e *Engine) CurrentSpeed() int64 {
-	return e.currentSpeed.Load()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var total int64
+	for _, j := range e.activeDownloads {
+		total += j.speed.Load()
+	}
+	return total
+}
+
+// JobSpeed returns the download speed of one job in bytes/sec, or 0 if it
+// isn't downloading.
+func (e *Engine) JobSpeed(id string) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if j, ok := e.activeDownloads[id]; ok {
+		return j.speed.Load()
+	}
+	return 0
 }
 
 func (e *Engine) processLoop() {
@@ -98,44 +148,76 @@ func (e *Engine) processLoop() {
 		case <-time.After(5 * time.Second):
 		}
 
-		if e.queueMgr.IsPaused() {
-			continue
-		}
+		// Start queued downloads until every job slot is busy
+		for e.ctx.Err() == nil && e.hasFreeSlot() {
+			// While paused, only force-priority downloads start
+			dl, err := e.queueMgr.GetNextQueued(e.queueMgr.IsPaused())
+			if err != nil {
+				log.Printf("Error getting next queued: %v", err)
+				break
+			}
+			if dl == nil {
+				break
+			}
 
-		dl, err := e.queueMgr.GetNextQueued()
-		if err != nil {
-			log.Printf("Error getting next queued: %v", err)
-			continue
-		}
-		if dl == nil {
-			continue
-		}
+			// Claim it before starting so the next GetNextQueued skips it
+			ok, err := e.queueMgr.Claim(dl.ID)
+			if err != nil {
+				log.Printf("Error updating status: %v", err)
+				break
+			}
+			if !ok {
+				continue // paused or deleted meanwhile
+			}
 
-		e.processDownload(dl)
+			// Per-download context so individual downloads can be cancelled
+			// without stopping the whole engine.
+			dlCtx, dlCancel := context.WithCancel(e.ctx)
+			j := &job{cancel: dlCancel, done: make(chan struct{})}
+			e.mu.Lock()
+			e.activeDownloads[dl.ID] = j
+			e.mu.Unlock()
+
+			go func(dl *queue.Download) {
+				defer func() {
+					dlCancel()
+					e.mu.Lock()
+					delete(e.activeDownloads, dl.ID)
+					e.mu.Unlock()
+					close(j.done)
+					e.Notify() // a slot is free
+				}()
+				e.processDownload(dlCtx, j, dl)
+			}(dl)
+		}
 	}
 }
 
-func (e *Engine) processDownload(dl *queue.Download) {
-	log.Printf("Starting download: %s", dl.Name)
-
-	// Per-download context so individual downloads can be cancelled without
-	// stopping the whole engine.
-	dlCtx, dlCancel := context.WithCancel(e.ctx)
-	defer dlCancel()
+// hasFreeSlot reports whether fewer than maxJobs downloads are running.
+func (e *Engine) hasFreeSlot() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.activeDownloads) < e.maxJobs
+}
 
+// segmentWorkers returns how many segments one job may have in flight: an
+// equal share of the pool's pipeline slots between the running jobs.
+func (e *Engine) segmentWorkers() int {
+	workers := e.poolMgr.Capacity()
+	if workers < e.workers {
+		workers = e.workers
+	}
 	e.mu.Lock()
-	e.activeDownloads[dl.ID] = dlCancel
+	running := len(e.activeDownloads)
 	e.mu.Unlock()
-	defer func() {
-		e.mu.Lock()
-		delete(e.activeDownloads, dl.ID)
-		e.mu.Unlock()
-	}()
-
-	if err := e.queueMgr.UpdateStatus(dl.ID, queue.StatusDownloading); err != nil {
-		log.Printf("Error updating status: %v", err)
-		return
+	if running > 1 {
+		workers /= running
 	}
+	return max(workers, 1)
+}
+
+func (e *Engine) processDownload(dlCtx context.Context, j *job, dl *queue.Download) {
+	log.Printf("Starting download: %s", dl.Name)
 
 	// Parse the NZB data
 	nzbFile, err := nzb.ParseBytes(dl.NZBData)
@@ -157,9 +239,55 @@ func (e *Engine) processDownload(dl *queue.Download) {
 		log.Printf("Error updating path: %v", err)
 	}
 
-	// Download all files in the NZB
+	// Segments already on disk from an earlier run are skipped
+	saved, err := e.queueMgr.GetFileProgress(dl.ID)
+	if err != nil {
+		log.Printf("Error loading resume state for %s: %v", dl.Name, err)
+	}
+
+	// Only wanted files are fetched; recovery volumes wait until a repair
+	// asks for them (see QueueRecoveryBlocks).
+	recorded, err := e.queueMgr.GetFileStates(dl.ID)
+	if err != nil {
+		log.Printf("Error loading file states for %s: %v", dl.Name, err)
+	}
+	states := fileStates(nzbFile, recorded)
+	var wantBytes int64
+	wantSegments := 0
+	for i, file := range nzbFile.Files {
+		if states[i] == queue.FileWanted {
+			wantBytes += file.TotalSize()
+			wantSegments += len(file.Segments)
+		}
+	}
+	if wantBytes != dl.TotalBytes || wantSegments != dl.TotalSegments {
+		dl.TotalBytes, dl.TotalSegments = wantBytes, wantSegments
+		if err := e.queueMgr.UpdateTotals(dl.ID, wantBytes, wantSegments); err != nil {
+			log.Printf("Error updating totals: %v", err)
+		}
+	}
+
+	// Download all wanted files in the NZB
 	var totalDone atomic.Int32
 	var totalBytes atomic.Int64
+	resumed := 0
+	for i, file := range nzbFile.Files {
+		if states[i] == queue.FileWanted {
+			resumed += segmentBitmapFromBytes(len(file.Segments), saved[i]).Count()
+		}
+	}
+	if resumed > 0 {
+		log.Printf("Resuming %s: %d/%d segments already on disk", dl.Name, resumed, dl.TotalSegments)
+		totalDone.Store(int32(resumed))
+		totalBytes.Store(dl.DownloadedBytes)
+	} else if err := e.checkAvailability(dlCtx, dl, nzbFile, states); err != nil {
+		// Shutdown or cancel: leave the row for RecoverInterrupted / CancelDownload
+		if dlCtx.Err() == nil {
+			log.Printf("Failing %s: %v", dl.Name, err)
+			e.queueMgr.SetError(dl.ID, err.Error())
+		}
+		return
+	}
 	startTime := time.Now()
 
 	// Speed tracking goroutine
@@ -168,28 +296,52 @@ func (e *Engine) processDownload(dl *queue.Download) {
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
 		defer ticker.Stop()
-		var lastBytes int64
+		lastBytes := totalBytes.Load()
 		for {
 			select {
 			case <-speedCtx.Done():
-				e.currentSpeed.Store(0)
+				j.speed.Store(0)
 				return
 			case <-ticker.C:
 				current := totalBytes.Load()
 				speed := current - lastBytes
 				lastBytes = current
-				e.currentSpeed.Store(speed)
+				j.speed.Store(speed)
+				e.events.Publish(events.Progress, map[string]interface{}{
+					"id":               dl.ID,
+					"downloaded_bytes": current,
+					"total_bytes":      dl.TotalBytes,
+					"done_segments":    totalDone.Load(),
+					"total_segments":   dl.TotalSegments,
+					"speed":            speed,
+				})
 			}
 		}
 	}()
 
 	var downloadErr error
-	for _, file := range nzbFile.Files {
-		if dlCtx.Err() != nil || e.queueMgr.IsPaused() {
+	interrupted := false
+	repairable := hasPar2(nzbFile)
+	for i, file := range nzbFile.Files {
+		if dlCtx.Err() != nil || e.paused(dl) {
+			interrupted = true
 			break
 		}
+		if states[i] != queue.FileWanted {
+			continue
+		}
 
-		err := e.downloadFile(dlCtx, file, dlDir, &totalDone, &totalBytes, dl)
+		err := e.downloadFile(dlCtx, i, file, dlDir, saved[i], &totalDone, &totalBytes, dl)
+		if errors.Is(err, errInterrupted) {
+			interrupted = true
+			break
+		}
+		if errors.Is(err, ErrArticleNotFound) && repairable {
+			// Leave it to par2: post-processing verifies and, if needed,
+			// asks for recovery volumes.
+			log.Printf("Continuing %s despite missing articles: %v", dl.Name, err)
+			continue
+		}
 		if err != nil {
 			downloadErr = err
 			log.Printf("Error downloading file %s: %v", file.Filename(), err)
@@ -199,6 +351,25 @@ func (e *Engine) processDownload(dl *queue.Download) {
 
 	speedCancel()
 
+	if interrupted {
+		e.queueMgr.UpdateProgress(dl.ID, totalBytes.Load(), int(totalDone.Load()))
+		switch {
+		case e.ctx.Err() != nil:
+			// Shutting down: the row stays "downloading" and is requeued by
+			// RecoverInterrupted on the next start.
+			log.Printf("Download %s interrupted by shutdown — progress saved", dl.Name)
+		case dlCtx.Err() != nil:
+			// Cancelled, paused or deleted by the user, who has already
+			// updated the row.
+		default:
+			log.Printf("Download %s paused — requeued with progress saved", dl.Name)
+			if err := e.queueMgr.UpdateStatus(dl.ID, queue.StatusQueued); err != nil {
+				log.Printf("Error updating status: %v", err)
+			}
+		}
+		return
+	}
+
 	elapsed := time.Since(startTime)
 	log.Printf("Download %s finished in %s (%.2f MB/s)",
 		dl.Name, elapsed.Round(time.Second),
@@ -225,34 +396,91 @@ func (e *Engine) processDownload(dl *queue.Download) {
 	}
 }
 
-func (e *Engine) downloadFile(ctx context.Context, file nzb.File, dlDir string, totalDone *atomic.Int32, totalBytes *atomic.Int64, dl *queue.Download) error {
+// paused reports whether dl should stop because the queue is paused.
+// Force-priority downloads ignore the pause.
+func (e *Engine) paused(dl *queue.Download) bool {
+	return e.queueMgr.IsPaused() && dl.Priority < queue.PriorityForce
+}
+
+// errInterrupted is returned by downloadFile when the download was paused or
+// cancelled before every segment was written.
+var errInterrupted = errors.New("download interrupted")
+
+func (e *Engine) downloadFile(ctx context.Context, fileIdx int, file nzb.File, dlDir string, saved []byte, totalDone *atomic.Int32, totalBytes *atomic.Int64, dl *queue.Download) error {
 	filename := file.Filename()
 	segments := file.SortedSegments()
 
-	// Pre-allocate result slice for ordered assembly
-	results := make([][]byte, len(segments))
-	var resultsMu sync.Mutex
+	// Decoded segments are written straight to their =ypart offset, so memory
+	// use is bounded by the number of segments in flight, not the file size.
+	filePath := filepath.Join(dlDir, filename)
+	out, err := resumeSegmentFile(filePath, len(segments), saved)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Persist which segments are on disk so a restart can pick up from here
+	saveProgress := func() {
+		if err := e.queueMgr.SaveFileProgress(dl.ID, fileIdx, out.Snapshot()); err != nil {
+			log.Printf("Error saving resume state for %s: %v", filename, err)
+		}
+	}
+	defer saveProgress()
+
+	if out.FirstMissing() < 0 {
+		return nil // completed by an earlier run
+	}
+
 	var downloadErr error
 	var errOnce sync.Once
-
-	// Worker pool for segments
-	sem := make(chan struct{}, e.workers)
+	var failed atomic.Bool // stop launching segments after a fatal error
+	var notFound atomic.Int32
+
+	// Segments in flight are capped at this job's share of the pipeline
+	// slots. The share is rechecked for every segment, so it shrinks when
+	// another job starts and grows again when one finishes.
+	var slotMu sync.Mutex
+	slotFree := sync.NewCond(&slotMu)
+	inFlight := 0
 	var wg sync.WaitGroup
 
 	for i, seg := range segments {
-		if ctx.Err() != nil || e.queueMgr.IsPaused() {
+		if ctx.Err() != nil || e.paused(dl) || failed.Load() {
 			break
 		}
+		if out.Has(i) {
+			continue
+		}
 
+		slotMu.Lock()
+		for inFlight >= e.segmentWorkers() {
+			slotFree.Wait()
+		}
+		inFlight++
+		slotMu.Unlock()
 		wg.Add(1)
-		sem <- struct{}{}
 
 		go func(idx int, segment nzb.Segment) {
 			defer wg.Done()
-			defer func() { <-sem }()
+			defer func() {
+				slotMu.Lock()
+				inFlight--
+				slotMu.Unlock()
+				slotFree.Signal()
+			}()
 
 			data, err := e.poolMgr.FetchSegment(ctx, segment.MessageID)
+			if err == nil {
+				err = e.limiter.wait(ctx, len(data))
+			}
+			if errors.Is(err, ErrArticleNotFound) {
+				// Missing everywhere: keep going, the rest may be repairable
+				log.Printf("Segment %d of %s: %v", segment.Number, filename, err)
+				notFound.Add(1)
+				return
+			}
 			if err != nil {
+				failed.Store(true)
 				errOnce.Do(func() {
 					downloadErr = fmt.Errorf("segment %d (%s): %w", segment.Number, segment.MessageID, err)
 				})
@@ -262,15 +490,20 @@ func (e *Engine) downloadFile(ctx context.Context, file nzb.File, dlDir string,
 			// Decode yEnc
 			decoded, err := DecodeYEnc(data)
 			if err != nil {
+				failed.Store(true)
 				errOnce.Do(func() {
 					downloadErr = fmt.Errorf("yenc decode segment %d: %w", segment.Number, err)
 				})
 				return
 			}
 
-			resultsMu.Lock()
-			results[idx] = decoded.Data
-			resultsMu.Unlock()
+			if err := out.WriteSegment(idx, decoded); err != nil {
+				failed.Store(true)
+				errOnce.Do(func() {
+					downloadErr = fmt.Errorf("writing segment %d: %w", segment.Number, err)
+				})
+				return
+			}
 
 			totalBytes.Add(int64(len(decoded.Data)))
 			done := int(totalDone.Add(1))
@@ -278,31 +511,30 @@ func (e *Engine) downloadFile(ctx context.Context, file nzb.File, dlDir string,
 			// Update progress periodically
 			if done%10 == 0 || done == dl.TotalSegments {
 				e.queueMgr.UpdateProgress(dl.ID, totalBytes.Load(), done)
+				saveProgress()
 			}
 		}(i, seg)
 	}
 
 	wg.Wait()
 
+	// Fetch errors while pausing are usually the pool being torn down (e.g.
+	// VPN drop), so keep what we have and let the job resume later.
+	if (ctx.Err() != nil || e.paused(dl)) && out.FirstMissing() >= 0 {
+		return errInterrupted
+	}
+
 	if downloadErr != nil {
 		return downloadErr
 	}
 
-	// Assemble file from segments
-	filePath := filepath.Join(dlDir, filename)
-	f, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("creating file %s: %w", filePath, err)
+	if n := notFound.Load(); n > 0 {
+		return fmt.Errorf("%s: %d of %d segments missing from all servers: %w",
+			filename, n, len(segments), ErrArticleNotFound)
 	}
-	defer f.Close()
 
-	for i, data := range results {
-		if data == nil {
-			return fmt.Errorf("missing segment %d for %s", i+1, filename)
-		}
-		if _, err := bytes.NewReader(data).WriteTo(f); err != nil {
-			return fmt.Errorf("writing segment %d: %w", i+1, err)
-		}
+	if missing := out.FirstMissing(); missing >= 0 {
+		return fmt.Errorf("missing segment %d for %s", segments[missing].Number, filename)
 	}
 
 	log.Printf("Assembled file: %s", filename)
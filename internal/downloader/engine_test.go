@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"nzb-connect/internal/config"
+	"nzb-connect/internal/nzb"
+	"nzb-connect/internal/queue"
+)
+
+func TestSegmentWorkersSharesPool(t *testing.T) {
+	pm := NewPoolManager("")
+	pm.UpdateServers([]config.ServerConfig{{
+		Name: "a", Host: "127.0.0.1", Port: 1, Connections: 20, Pipeline: 3, Enabled: true,
+	}})
+	defer pm.CloseAll()
+
+	e := NewEngine(pm, nil, t.TempDir(), t.TempDir())
+	if got := e.segmentWorkers(); got != 60 {
+		t.Errorf("one job: got %d workers, want 60", got)
+	}
+
+	e.activeDownloads["a"] = &job{}
+	e.activeDownloads["b"] = &job{}
+	e.activeDownloads["c"] = &job{}
+	if got := e.segmentWorkers(); got != 20 {
+		t.Errorf("three jobs: got %d workers, want 20", got)
+	}
+
+	e.activeDownloads["a"].speed.Store(1000)
+	e.activeDownloads["c"].speed.Store(500)
+	if got := e.CurrentSpeed(); got != 1500 {
+		t.Errorf("CurrentSpeed = %d, want 1500", got)
+	}
+	if got := e.JobSpeed("c"); got != 500 {
+		t.Errorf("JobSpeed(c) = %d, want 500", got)
+	}
+}
+
+func TestDownloadFileStopsOnDecodeError(t *testing.T) {
+	srv := newFakeNNTPServer(t, 1) // bodies are not yEnc
+	e, qm := newTestEngine(t)
+	e.poolMgr.UpdateServers([]config.ServerConfig{srv.config()})
+	e.workers = 1 // one segment in flight at a time
+	defer e.poolMgr.CloseAll()
+
+	dl := &queue.Download{ID: "bad", Name: "bad", TotalSegments: 10}
+	if err := qm.Add(dl); err != nil {
+		t.Fatal(err)
+	}
+	file := nzb.File{Subject: `"bad.bin" yEnc (1/10)`}
+	for i := 1; i <= 10; i++ {
+		file.Segments = append(file.Segments, nzb.Segment{Bytes: 100, Number: i, MessageID: fmt.Sprintf("seg%d@test", i)})
+	}
+
+	var done atomic.Int32
+	var bytes atomic.Int64
+	err := e.downloadFile(context.Background(), 0, file, t.TempDir(), nil, &done, &bytes, dl)
+	if err == nil || !strings.Contains(err.Error(), "yenc decode") {
+		t.Fatalf("err = %v, want a yenc decode error", err)
+	}
+	// The next segment may already be waiting for the slot when the first
+	// one fails, but nothing after it is started.
+	if got := srv.servedCount(); got > 2 {
+		t.Errorf("served %d of 10 segments after a decode error, want at most 2", got)
+	}
+}
@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors for NNTP failures. Errors returned by NNTPConn and
+// PoolManager wrap one of these (via *NNTPError) so callers can use errors.Is
+// to decide whether to retry, fail over, or give up on a server.
+var (
+	// ErrArticleNotFound means the server does not have the article (430/423).
+	// It is permanent for that server but another server may have it.
+	ErrArticleNotFound = errors.New("article not found")
+
+	// ErrAuthFailed means the server rejected our credentials (481/482/480).
+	// Retrying with the same credentials is pointless.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrServerBusy means the server refused service for now, usually because
+	// the account has too many connections open (400/502).
+	ErrServerBusy = errors.New("server busy")
+
+	// ErrProtocol means the server sent something we could not understand or
+	// did not expect.
+	ErrProtocol = errors.New("protocol error")
+)
+
+// NNTPError is an NNTP failure carrying the server and response code.
+type NNTPError struct {
+	Server string
+	Code   int    // NNTP response code, or 0 if the response was unparseable
+	Msg    string // response text or description
+	Err    error  // one of the sentinel errors above
+}
+
+func (e *NNTPError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("%s: %v: %s", e.Server, e.Err, e.Msg)
+	}
+	return fmt.Sprintf("%s: %v (%d %s)", e.Server, e.Err, e.Code, e.Msg)
+}
+
+func (e *NNTPError) Unwrap() error {
+	return e.Err
+}
+
+// newNNTPError classifies an unexpected response code.
+func newNNTPError(server string, code int, msg string) *NNTPError {
+	return &NNTPError{Server: server, Code: code, Msg: msg, Err: classifyCode(code, msg)}
+}
+
+// classifyCode maps an NNTP response code to a sentinel error. Some providers
+// report connection limits with 481 or 502, so the message text is consulted
+// to tell "too many connections" apart from bad credentials.
+func classifyCode(code int, msg string) error {
+	lower := strings.ToLower(msg)
+	tooMany := strings.Contains(lower, "connection")
+	badAuth := strings.Contains(lower, "auth") || strings.Contains(lower, "password") ||
+		strings.Contains(lower, "permission")
+	switch code {
+	case 430, 423:
+		return ErrArticleNotFound
+	case 481, 482, 480:
+		if tooMany {
+			return ErrServerBusy
+		}
+		return ErrAuthFailed
+	case 400, 502:
+		if badAuth && !tooMany {
+			return ErrAuthFailed
+		}
+		return ErrServerBusy
+	default:
+		return ErrProtocol
+	}
+}
@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyCode(t *testing.T) {
+	tests := []struct {
+		code int
+		msg  string
+		want error
+	}{
+		{430, "No Such Article Found", ErrArticleNotFound},
+		{423, "No article with that number", ErrArticleNotFound},
+		{481, "Authentication failed", ErrAuthFailed},
+		{482, "Authentication commands issued out of sequence", ErrAuthFailed},
+		{480, "Authentication required", ErrAuthFailed},
+		{481, "Too many connections for your user", ErrServerBusy},
+		{502, "Too many connections", ErrServerBusy},
+		{400, "Service temporarily unavailable", ErrServerBusy},
+		{502, "Permission denied", ErrAuthFailed},
+		{500, "Command not recognized", ErrProtocol},
+	}
+	for _, tt := range tests {
+		err := newNNTPError("srv", tt.code, tt.msg)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%d %q: got %v, want %v", tt.code, tt.msg, err.Err, tt.want)
+		}
+		if err.Server != "srv" || err.Code != tt.code {
+			t.Errorf("%d %q: server/code not preserved: %+v", tt.code, tt.msg, err)
+		}
+	}
+}
@@ -4,67 +4,101 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nzb-connect/internal/config"
 	"nzb-connect/internal/vpn"
 )
 
+// Pipeline depth limits. A depth of 1 disables pipelining.
+const (
+	defaultPipelineDepth = 2
+	maxPipelineDepth     = 20
+)
+
+// pipelineDepth returns the number of requests to keep in flight per connection.
+func pipelineDepth(server config.ServerConfig) int {
+	depth := server.Pipeline
+	if depth <= 0 {
+		depth = defaultPipelineDepth
+	}
+	if depth > maxPipelineDepth {
+		depth = maxPipelineDepth
+	}
+	return depth
+}
+
 // NNTPConn represents a single NNTP connection.
+//
+// Once connected, commands are pipelined: each command is written and queued
+// on pending under writeMu, and readLoop answers the queued requests in order
+// as responses arrive. Several callers can therefore share one connection
+// without waiting a full round trip per command.
 type NNTPConn struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
 	server config.ServerConfig
+
+	writeMu sync.Mutex
+	pending chan *request
+	closed  bool
+	broken  atomic.Bool
+
+	users int // callers holding this connection, protected by ConnectionPool.mu
+}
+
+// request is a command waiting for its response on a pipelined connection.
+type request struct {
+	bodyCode int // response code that is followed by a dot-terminated body
+	result   chan response
+}
+
+type response struct {
+	code int
+	msg  string
+	body []byte
+	err  error
 }
 
+// DialFunc opens the TCP connection to a news server.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
 // Connect establishes an NNTP connection, optionally through a VPN-bound dialer.
 func Connect(ctx context.Context, server config.ServerConfig, vpnInterface string) (*NNTPConn, error) {
-	addr := fmt.Sprintf("%s:%d", server.Host, server.Port)
+	dial := (&net.Dialer{}).DialContext
+	if vpnInterface != "" {
+		dial = vpn.BindToInterface(vpnInterface).DialContext
+	}
+	return ConnectWith(ctx, server, dial)
+}
 
-	var conn net.Conn
-	var err error
+// ConnectWith establishes an NNTP connection opened by dial, such as a
+// vpn.Resolver that also looks the server up through the tunnel.
+func ConnectWith(ctx context.Context, server config.ServerConfig, dial DialFunc) (*NNTPConn, error) {
+	addr := fmt.Sprintf("%s:%d", server.Host, server.Port)
 
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
 	if server.SSL {
-		tlsConfig := &tls.Config{
-			ServerName: server.Host,
-		}
-		if vpnInterface != "" {
-			dialer := vpn.BindToInterface(vpnInterface)
-			netConn, dialErr := dialer.DialContext(ctx, "tcp", addr)
-			if dialErr != nil {
-				return nil, fmt.Errorf("dial %s: %w", addr, dialErr)
-			}
-			conn = tls.Client(netConn, tlsConfig)
-			if err := conn.(*tls.Conn).HandshakeContext(ctx); err != nil {
-				netConn.Close()
-				return nil, fmt.Errorf("TLS handshake: %w", err)
-			}
-		} else {
-			dialer := &tls.Dialer{Config: tlsConfig}
-			conn, err = dialer.DialContext(ctx, "tcp", addr)
-			if err != nil {
-				return nil, fmt.Errorf("TLS dial %s: %w", addr, err)
-			}
-		}
-	} else {
-		if vpnInterface != "" {
-			dialer := vpn.BindToInterface(vpnInterface)
-			conn, err = dialer.DialContext(ctx, "tcp", addr)
-		} else {
-			var d net.Dialer
-			conn, err = d.DialContext(ctx, "tcp", addr)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("dial %s: %w", addr, err)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: server.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake: %w", err)
 		}
+		conn = tlsConn
 	}
 
 	nc := &NNTPConn{
@@ -75,14 +109,14 @@ func Connect(ctx context.Context, server config.ServerConfig, vpnInterface strin
 	}
 
 	// Read welcome banner
-	code, _, err := nc.readResponse()
+	code, msg, err := nc.readResponse()
 	if err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("reading welcome: %w", err)
 	}
 	if code != 200 && code != 201 {
 		conn.Close()
-		return nil, fmt.Errorf("unexpected welcome code: %d", code)
+		return nil, newNNTPError(server.Name, code, msg)
 	}
 
 	// Authenticate
@@ -93,6 +127,10 @@ func Connect(ctx context.Context, server config.ServerConfig, vpnInterface strin
 		}
 	}
 
+	// Welcome and auth are strict request/response; everything after is pipelined.
+	nc.pending = make(chan *request, pipelineDepth(server))
+	go nc.readLoop()
+
 	return nc, nil
 }
 
@@ -113,11 +151,11 @@ func (nc *NNTPConn) readResponse() (int, string, error) {
 	}
 	line = strings.TrimRight(line, "\r\n")
 	if len(line) < 3 {
-		return 0, "", fmt.Errorf("short response: %q", line)
+		return 0, "", &NNTPError{Server: nc.server.Name, Msg: fmt.Sprintf("short response %q", line), Err: ErrProtocol}
 	}
 	code, err := strconv.Atoi(line[:3])
 	if err != nil {
-		return 0, "", fmt.Errorf("invalid response code: %q", line[:3])
+		return 0, "", &NNTPError{Server: nc.server.Name, Msg: fmt.Sprintf("invalid response code %q", line[:3]), Err: ErrProtocol}
 	}
 	msg := ""
 	if len(line) > 4 {
@@ -153,7 +191,7 @@ func (nc *NNTPConn) authenticate() error {
 	if err := nc.sendCommand("AUTHINFO USER " + nc.server.Username); err != nil {
 		return fmt.Errorf("sending username: %w", err)
 	}
-	code, _, err := nc.readResponse()
+	code, msg, err := nc.readResponse()
 	if err != nil {
 		return fmt.Errorf("reading user response: %w", err)
 	}
@@ -161,60 +199,160 @@ func (nc *NNTPConn) authenticate() error {
 		return nil // No password needed
 	}
 	if code != 381 {
-		return fmt.Errorf("unexpected user response: %d", code)
+		return nc.authError(code, msg)
 	}
 
 	if err := nc.sendCommand("AUTHINFO PASS " + nc.server.Password); err != nil {
 		return fmt.Errorf("sending password: %w", err)
 	}
-	code, _, err = nc.readResponse()
+	code, msg, err = nc.readResponse()
 	if err != nil {
 		return fmt.Errorf("reading pass response: %w", err)
 	}
 	if code != 281 {
-		return fmt.Errorf("authentication failed: %d", code)
+		return nc.authError(code, msg)
 	}
 	return nil
 }
 
-// FetchBody fetches the body of an article by message ID.
+// authError classifies a rejected AUTHINFO. Anything that is not a connection
+// limit is treated as bad credentials.
+func (nc *NNTPConn) authError(code int, msg string) error {
+	e := newNNTPError(nc.server.Name, code, msg)
+	if e.Err != ErrServerBusy {
+		e.Err = ErrAuthFailed
+	}
+	return e
+}
+
+// readLoop reads responses in the order their commands were sent and hands
+// each one to the waiting caller. After the first I/O error the connection is
+// out of sync, so it is closed and every remaining request fails immediately.
+func (nc *NNTPConn) readLoop() {
+	var failed error
+	for req := range nc.pending {
+		if failed != nil {
+			req.result <- response{err: failed}
+			continue
+		}
+
+		var resp response
+		resp.code, resp.msg, resp.err = nc.readResponse()
+		if resp.err == nil && resp.code == req.bodyCode {
+			resp.body, resp.err = nc.readMultiLine()
+		}
+		if resp.err != nil {
+			failed = resp.err
+			nc.broken.Store(true)
+			nc.conn.Close()
+		}
+		req.result <- resp
+	}
+}
+
+// do sends a command on the pipeline and waits for its response.
+func (nc *NNTPConn) do(cmd string, bodyCode int) response {
+	req := &request{bodyCode: bodyCode, result: make(chan response, 1)}
+
+	nc.writeMu.Lock()
+	if nc.closed {
+		nc.writeMu.Unlock()
+		return response{err: fmt.Errorf("connection closed")}
+	}
+	// Queue before writing so the read loop always knows about a request
+	// by the time its response can arrive.
+	nc.pending <- req
+	if err := nc.sendCommand(cmd); err != nil {
+		// The read loop will fail on the closed socket and answer req.
+		nc.broken.Store(true)
+		nc.conn.Close()
+	}
+	nc.writeMu.Unlock()
+
+	return <-req.result
+}
+
+// Broken reports whether the connection hit an I/O error and must be discarded.
+func (nc *NNTPConn) Broken() bool {
+	return nc.broken.Load()
+}
+
+// FetchBody fetches the body of an article by message ID. It is safe to call
+// concurrently; requests are pipelined on the connection.
 func (nc *NNTPConn) FetchBody(messageID string) ([]byte, error) {
 	// Ensure message ID is wrapped in angle brackets
 	if !strings.HasPrefix(messageID, "<") {
 		messageID = "<" + messageID + ">"
 	}
 
-	if err := nc.sendCommand("BODY " + messageID); err != nil {
-		return nil, fmt.Errorf("sending BODY: %w", err)
+	resp := nc.do("BODY "+messageID, 222)
+	if resp.err != nil {
+		return nil, fmt.Errorf("BODY %s: %w", messageID, resp.err)
 	}
-
-	code, _, err := nc.readResponse()
-	if err != nil {
-		return nil, fmt.Errorf("reading BODY response: %w", err)
+	if resp.code != 222 {
+		return nil, newNNTPError(nc.server.Name, resp.code, resp.msg)
 	}
-	if code != 222 {
-		return nil, fmt.Errorf("BODY failed with code %d", code)
+
+	return resp.body, nil
+}
+
+// Stat checks whether the server has an article without fetching it. It
+// returns nil if it does, and an error wrapping ErrArticleNotFound if not.
+func (nc *NNTPConn) Stat(messageID string) error {
+	if !strings.HasPrefix(messageID, "<") {
+		messageID = "<" + messageID + ">"
 	}
 
-	return nc.readMultiLine()
+	resp := nc.do("STAT "+messageID, 0)
+	if resp.err != nil {
+		return fmt.Errorf("STAT %s: %w", messageID, resp.err)
+	}
+	if resp.code != 223 {
+		return newNNTPError(nc.server.Name, resp.code, resp.msg)
+	}
+	return nil
 }
 
-// Close closes the NNTP connection.
+// Close closes the NNTP connection. Requests still in flight fail.
 func (nc *NNTPConn) Close() error {
+	nc.writeMu.Lock()
+	if nc.closed {
+		nc.writeMu.Unlock()
+		return nil
+	}
+	nc.closed = true
+	if nc.pending != nil {
+		close(nc.pending)
+	}
 	nc.sendCommand("QUIT")
+	nc.writeMu.Unlock()
 	return nc.conn.Close()
 }
 
-// ConnectionPool manages a pool of NNTP connections to a server.
+// ConnectionPool manages a pool of NNTP connections to a server. Each
+// connection is shared by up to depth callers so that several requests can be
+// pipelined on it.
 type ConnectionPool struct {
 	server       config.ServerConfig
 	vpnInterface string
+	resolver     *vpn.Resolver // looks the server up through the tunnel; nil = system resolver
+	dial         DialFunc      // opens connections through a tunnel with no host interface
 	maxConns     int
-	mu           sync.Mutex
-	conns        chan *NNTPConn
-	active       int
+	depth        int
+
+	mu        sync.Mutex
+	conns     []*NNTPConn
+	dialing   int
+	changed   chan struct{} // closed and replaced whenever a slot frees up
+	closed    bool
+	busyUntil time.Time // no new connections before this (server said busy)
+	disabled  error     // set on auth failure; the pool stops serving
 }
 
+// serverBusyBackoff is how long a pool stops opening new connections after
+// the server reports it is busy or over its connection limit.
+const serverBusyBackoff = 30 * time.Second
+
 // NewConnectionPool creates a new pool for the given server.
 func NewConnectionPool(server config.ServerConfig, vpnInterface string) *ConnectionPool {
 	maxConns := server.Connections
@@ -228,69 +366,203 @@ func NewConnectionPool(server config.ServerConfig, vpnInterface string) *Connect
 		server:       server,
 		vpnInterface: vpnInterface,
 		maxConns:     maxConns,
-		conns:        make(chan *NNTPConn, maxConns),
+		depth:        pipelineDepth(server),
+		changed:      make(chan struct{}),
 	}
 }
 
-// Get retrieves a connection from the pool or creates a new one.
-func (p *ConnectionPool) Get(ctx context.Context) (*NNTPConn, error) {
-	// Try to get an existing connection
-	select {
-	case conn := <-p.conns:
-		return conn, nil
-	default:
+// Capacity returns the number of requests the pool can have in flight.
+func (p *ConnectionPool) Capacity() int {
+	return p.maxConns * p.depth
+}
+
+// connect opens a new connection to the pool's server.
+func (p *ConnectionPool) connect(ctx context.Context) (*NNTPConn, error) {
+	if p.resolver != nil {
+		return ConnectWith(ctx, p.server, p.resolver.DialContext)
 	}
+	if p.dial != nil {
+		return ConnectWith(ctx, p.server, p.dial)
+	}
+	return Connect(ctx, p.server, p.vpnInterface)
+}
 
-	// Create a new connection if under the limit
-	p.mu.Lock()
-	if p.active >= p.maxConns {
+// Get returns a connection with a free pipeline slot. Idle connections are
+// preferred, then opening a new one, then pipelining onto the least busy one.
+// Every successful Get must be paired with Put or Discard.
+func (p *ConnectionPool) Get(ctx context.Context) (*NNTPConn, error) {
+	return p.acquire(ctx, true)
+}
+
+// TryGet is like Get but returns (nil, nil) instead of waiting when every
+// pipeline slot is in use.
+func (p *ConnectionPool) TryGet(ctx context.Context) (*NNTPConn, error) {
+	return p.acquire(ctx, false)
+}
+
+func (p *ConnectionPool) acquire(ctx context.Context, wait bool) (*NNTPConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("connection pool for %s is closed", p.server.Name)
+		}
+		if p.disabled != nil {
+			err := p.disabled
+			p.mu.Unlock()
+			return nil, err
+		}
+
+		var best *NNTPConn
+		for _, c := range p.conns {
+			if c.users < p.depth && (best == nil || c.users < best.users) {
+				best = c
+			}
+		}
+		backoff := time.Until(p.busyUntil)
+		canDial := len(p.conns)+p.dialing < p.maxConns && backoff <= 0
+		if best != nil && (best.users == 0 || !canDial) {
+			best.users++
+			p.mu.Unlock()
+			return best, nil
+		}
+
+		if canDial {
+			p.dialing++
+			p.mu.Unlock()
+
+			conn, err := p.connect(ctx)
+
+			p.mu.Lock()
+			p.dialing--
+			if err != nil {
+				p.noteErrorLocked(err)
+				p.notify()
+				p.mu.Unlock()
+				return nil, err
+			}
+			if p.closed {
+				p.mu.Unlock()
+				conn.Close()
+				return nil, fmt.Errorf("connection pool for %s is closed", p.server.Name)
+			}
+			conn.users = 1
+			p.conns = append(p.conns, conn)
+			p.mu.Unlock()
+			return conn, nil
+		}
+
+		// Every slot is busy — wait for one to be released, or for the
+		// busy backoff to expire if that is what stopped us dialing.
+		changed := p.changed
 		p.mu.Unlock()
-		// Wait for one to be returned
+		if !wait {
+			return nil, nil
+		}
+		var timer *time.Timer
+		var retry <-chan time.Time
+		if backoff > 0 {
+			timer = time.NewTimer(backoff)
+			retry = timer.C
+		}
 		select {
-		case conn := <-p.conns:
-			return conn, nil
+		case <-changed:
+		case <-retry:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 	}
-	p.active++
-	p.mu.Unlock()
+}
 
-	conn, err := Connect(ctx, p.server, p.vpnInterface)
-	if err != nil {
-		p.mu.Lock()
-		p.active--
-		p.mu.Unlock()
-		return nil, err
+// NoteError updates the pool's state after a server-level failure: auth
+// failures disable the pool, busy responses pause new connections.
+func (p *ConnectionPool) NoteError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.noteErrorLocked(err)
+}
+
+func (p *ConnectionPool) noteErrorLocked(err error) {
+	switch {
+	case errors.Is(err, ErrAuthFailed):
+		if p.disabled == nil {
+			log.Printf("Disabling server %s: %v", p.server.Name, err)
+		}
+		p.disabled = err
+	case errors.Is(err, ErrServerBusy):
+		log.Printf("Server %s is busy, not opening new connections for %s: %v",
+			p.server.Name, serverBusyBackoff, err)
+		p.busyUntil = time.Now().Add(serverBusyBackoff)
 	}
-	return conn, nil
 }
 
-// Put returns a connection to the pool.
+// Disabled returns the error that disabled the pool, or nil.
+func (p *ConnectionPool) Disabled() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disabled
+}
+
+// Put releases a pipeline slot on conn. A broken connection is removed from
+// the pool and closed on the first Put after it broke, even while other
+// requests are still pipelined on it; those fail with it, as its stream is
+// unusable anyway.
 func (p *ConnectionPool) Put(conn *NNTPConn) {
-	select {
-	case p.conns <- conn:
-	default:
-		// Pool is full, close connection
+	p.mu.Lock()
+	conn.users--
+	drop := conn.Broken() && p.remove(conn)
+	p.notify()
+	p.mu.Unlock()
+
+	if drop {
 		conn.Close()
-		p.mu.Lock()
-		p.active--
-		p.mu.Unlock()
 	}
 }
 
-// Discard removes a broken connection from the pool count.
+// Discard removes a broken connection from the pool. Other requests still in
+// flight on it will fail.
 func (p *ConnectionPool) Discard(conn *NNTPConn) {
-	conn.Close()
 	p.mu.Lock()
-	p.active--
+	conn.users--
+	p.remove(conn)
+	p.notify()
 	p.mu.Unlock()
+
+	conn.Close()
+}
+
+// remove deletes conn from the pool. Must be called with p.mu held.
+func (p *ConnectionPool) remove(conn *NNTPConn) bool {
+	for i, c := range p.conns {
+		if c == conn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes callers waiting in Get. Must be called with p.mu held.
+func (p *ConnectionPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
 }
 
 // Close closes all connections in the pool.
 func (p *ConnectionPool) Close() {
-	close(p.conns)
-	for conn := range p.conns {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.closed = true
+	p.notify()
+	p.mu.Unlock()
+
+	for _, conn := range conns {
 		conn.Close()
 	}
 }
@@ -305,11 +577,16 @@ func TestConnection(ctx context.Context, server config.ServerConfig, vpnInterfac
 	return nil
 }
 
-// PoolManager manages connection pools for multiple servers.
+// PoolManager manages connection pools for multiple servers. Servers are
+// used in priority order: lower-priority tiers (backup/fill accounts) are only
+// asked for articles that every higher tier has reported missing.
 type PoolManager struct {
 	mu           sync.RWMutex
 	pools        map[string]*ConnectionPool
+	order        []*ConnectionPool // sorted by server priority, then name
 	vpnInterface string
+	resolver     *vpn.Resolver
+	dial         DialFunc
 }
 
 // NewPoolManager creates a new pool manager.
@@ -321,19 +598,20 @@ func NewPoolManager(vpnInterface string) *PoolManager {
 }
 
 // UpdateServers reconfigures pools based on the current server list.
+// Pools whose server settings changed are recreated.
 func (pm *PoolManager) UpdateServers(servers []config.ServerConfig) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	// Close pools for removed servers
-	newNames := make(map[string]bool)
+	// Close pools for removed or changed servers
+	wanted := make(map[string]config.ServerConfig)
 	for _, s := range servers {
 		if s.Enabled {
-			newNames[s.Name] = true
+			wanted[s.Name] = s
 		}
 	}
 	for name, pool := range pm.pools {
-		if !newNames[name] {
+		if s, ok := wanted[name]; !ok || s != pool.server {
 			pool.Close()
 			delete(pm.pools, name)
 		}
@@ -345,58 +623,214 @@ func (pm *PoolManager) UpdateServers(servers []config.ServerConfig) {
 			continue
 		}
 		if _, exists := pm.pools[s.Name]; !exists {
-			pm.pools[s.Name] = NewConnectionPool(s, pm.vpnInterface)
-			log.Printf("Created connection pool for server %s (%d connections)", s.Name, s.Connections)
+			pool := NewConnectionPool(s, pm.vpnInterface)
+			pool.resolver = pm.resolver
+			pool.dial = pm.dial
+			pm.pools[s.Name] = pool
+			log.Printf("Created connection pool for server %s (priority %d, %d connections, pipeline %d)",
+				s.Name, s.Priority, s.Connections, pipelineDepth(s))
 		}
 	}
+	pm.sortPools()
 }
 
-// GetConnection gets a connection from any available server.
-func (pm *PoolManager) GetConnection(ctx context.Context) (*NNTPConn, *ConnectionPool, error) {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
+// sortPools rebuilds the priority order. Must be called with pm.mu held.
+func (pm *PoolManager) sortPools() {
+	pm.order = pm.order[:0]
 	for _, pool := range pm.pools {
-		conn, err := pool.Get(ctx)
-		if err != nil {
-			log.Printf("Failed to get connection from pool: %v", err)
+		pm.order = append(pm.order, pool)
+	}
+	sort.Slice(pm.order, func(i, j int) bool {
+		a, b := pm.order[i].server, pm.order[j].server
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Name < b.Name
+	})
+}
+
+// errAllServersTried is returned by GetConnection when every usable server
+// has been excluded.
+var errAllServersTried = errors.New("article not available on any server")
+
+// GetConnection gets a connection from the highest-priority tier that has a
+// server not listed in exclude. Within a tier, a server with a free slot is
+// preferred; otherwise it waits on the first server of the tier. Servers that
+// fail to connect are skipped in favour of the next one, and servers disabled
+// after an auth failure are never used.
+func (pm *PoolManager) GetConnection(ctx context.Context, exclude map[string]bool) (*NNTPConn, *ConnectionPool, error) {
+	pm.mu.RLock()
+	var candidates []*ConnectionPool
+	usable := 0
+	var disabledErr error
+	for _, pool := range pm.order {
+		if err := pool.Disabled(); err != nil {
+			disabledErr = err
 			continue
 		}
-		return conn, pool, nil
+		usable++
+		if !exclude[pool.server.Name] {
+			candidates = append(candidates, pool)
+		}
+	}
+	pm.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		if usable > 0 {
+			return nil, nil, errAllServersTried
+		}
+		if disabledErr != nil {
+			return nil, nil, fmt.Errorf("no usable NNTP servers: %w", disabledErr)
+		}
+		return nil, nil, fmt.Errorf("no NNTP connections available")
+	}
+
+	var lastErr error
+	for len(candidates) > 0 {
+		tier := candidates[0].server.Priority
+		n := 1
+		for n < len(candidates) && candidates[n].server.Priority == tier {
+			n++
+		}
+
+		for _, pool := range candidates[:n] {
+			conn, err := pool.TryGet(ctx)
+			if err != nil {
+				log.Printf("Failed to get connection from %s: %v", pool.server.Name, err)
+				lastErr = err
+				continue
+			}
+			if conn != nil {
+				return conn, pool, nil
+			}
+		}
+
+		conn, err := candidates[0].Get(ctx)
+		if err == nil {
+			return conn, candidates[0], nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		log.Printf("Failed to get connection from %s: %v", candidates[0].server.Name, err)
+		lastErr = err
+		candidates = candidates[1:]
 	}
-	return nil, nil, fmt.Errorf("no NNTP connections available")
+	return nil, nil, fmt.Errorf("no NNTP connections available: %w", lastErr)
 }
 
-// FetchSegment fetches a segment from any available server with retries.
+// FetchSegment fetches a segment, trying servers in priority order.
+//
+//   - ErrArticleNotFound: the connection is kept and the next server is tried
+//     straight away; that server is not asked again for this article.
+//   - ErrServerBusy: the connection is dropped and the server stops opening
+//     new connections for a while; the fetch is retried with backoff.
+//   - ErrAuthFailed: the server is disabled until its config changes.
+//   - Anything else (I/O, protocol): retried with backoff.
+//
+// Once every server has reported the article missing, the returned error
+// wraps ErrArticleNotFound.
 func (pm *PoolManager) FetchSegment(ctx context.Context, messageID string) ([]byte, error) {
+	var data []byte
+	err := pm.tryServers(ctx, messageID, func(conn *NNTPConn) error {
+		var err error
+		data, err = conn.FetchBody(messageID)
+		return err
+	})
+	return data, err
+}
+
+// StatSegment reports whether any server has the article, without fetching
+// it. Servers are tried and errors handled as in FetchSegment.
+func (pm *PoolManager) StatSegment(ctx context.Context, messageID string) (bool, error) {
+	err := pm.tryServers(ctx, messageID, func(conn *NNTPConn) error {
+		return conn.Stat(messageID)
+	})
+	if errors.Is(err, ErrArticleNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// tryServers runs op for one article on servers in priority order until it
+// succeeds; see FetchSegment for how each kind of error is handled.
+func (pm *PoolManager) tryServers(ctx context.Context, messageID string, op func(*NNTPConn) error) error {
+	missing := make(map[string]bool) // servers that returned 430 for this article
 	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		if attempt > 0 {
+	for failures := 0; failures < 3; {
+		if failures > 0 {
 			// Exponential backoff
 			select {
-			case <-time.After(time.Duration(1<<attempt) * time.Second):
+			case <-time.After(time.Duration(1<<failures) * time.Second):
 			case <-ctx.Done():
-				return nil, ctx.Err()
+				return ctx.Err()
 			}
 		}
 
-		conn, pool, err := pm.GetConnection(ctx)
+		conn, pool, err := pm.GetConnection(ctx, missing)
+		if errors.Is(err, errAllServersTried) {
+			return fmt.Errorf("%s not found on any server: %w", messageID, lastErr)
+		}
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
 		if err != nil {
 			lastErr = err
+			failures++
 			continue
 		}
 
-		data, err := conn.FetchBody(messageID)
+		err = op(conn)
 		if err != nil {
-			pool.Discard(conn)
-			lastErr = fmt.Errorf("fetch body: %w", err)
+			lastErr = err
+			switch {
+			case errors.Is(err, ErrArticleNotFound):
+				// Permanent for this server only; the pipeline is still in sync.
+				pool.Put(conn)
+				missing[pool.server.Name] = true
+				continue
+			case errors.Is(err, ErrServerBusy), errors.Is(err, ErrAuthFailed):
+				pool.Discard(conn)
+				pool.NoteError(err)
+			case conn.Broken():
+				pool.Discard(conn)
+			default:
+				pool.Put(conn)
+			}
+			failures++
 			continue
 		}
 
 		pool.Put(conn)
-		return data, nil
+		return nil
+	}
+	return fmt.Errorf("all retries failed for %s: %w", messageID, lastErr)
+}
+
+// ServerErrors returns the reason each disabled server was taken out of
+// service, keyed by server name.
+func (pm *PoolManager) ServerErrors() map[string]string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	result := make(map[string]string)
+	for name, pool := range pm.pools {
+		if err := pool.Disabled(); err != nil {
+			result[name] = err.Error()
+		}
 	}
-	return nil, fmt.Errorf("all retries failed for %s: %w", messageID, lastErr)
+	return result
+}
+
+// Capacity returns the total number of requests that can be in flight across
+// all pools (connections × pipeline depth).
+func (pm *PoolManager) Capacity() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	total := 0
+	for _, pool := range pm.pools {
+		total += pool.Capacity()
+	}
+	return total
 }
 
 // SetVPNInterface changes the VPN interface used for new connections.
@@ -405,11 +839,50 @@ func (pm *PoolManager) SetVPNInterface(iface string) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	pm.vpnInterface = iface
+	pm.resetLocked()
+	log.Printf("Pool manager VPN interface updated to: %s", iface)
+}
+
+// SetTunnel points new connections at a tunnel in one step: iface is the VPN
+// interface to bind to, dial, if not nil, opens connections instead for a
+// tunnel that can't be reached by binding to an interface, and r, if not nil,
+// looks servers up instead of the system resolver. Existing connections are
+// closed and pools are reset once.
+func (pm *PoolManager) SetTunnel(iface string, dial DialFunc, r *vpn.Resolver) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.vpnInterface = iface
+	pm.dial = dial
+	pm.resolver = r
+	pm.resetLocked()
+	log.Printf("Pool manager VPN interface updated to: %s", iface)
+}
+
+// resetLocked closes every pool so the next UpdateServers rebuilds them with
+// the current settings. pm.mu must be held.
+func (pm *PoolManager) resetLocked() {
 	for _, pool := range pm.pools {
 		pool.Close()
 	}
 	pm.pools = make(map[string]*ConnectionPool)
-	log.Printf("Pool manager VPN interface updated to: %s", iface)
+	pm.order = nil
+}
+
+// TestServer tests connectivity to an NNTP server through vpnInterface, or
+// the dialer if one is set, looking it up with the tunnel resolver if one is
+// set.
+func (pm *PoolManager) TestServer(ctx context.Context, server config.ServerConfig, vpnInterface string) error {
+	pm.mu.RLock()
+	pool := NewConnectionPool(server, vpnInterface)
+	pool.resolver = pm.resolver
+	pool.dial = pm.dial
+	pm.mu.RUnlock()
+	conn, err := pool.connect(ctx)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
 }
 
 // CloseAll closes all connection pools.
@@ -420,6 +893,7 @@ func (pm *PoolManager) CloseAll() {
 		pool.Close()
 	}
 	pm.pools = make(map[string]*ConnectionPool)
+	pm.order = nil
 }
 
 // Ensure NNTPConn implements io.Closer
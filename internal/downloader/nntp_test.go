@@ -0,0 +1,365 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// fakeNNTPServer accepts connections and answers BODY and STAT commands. It
+// waits until it has read batch commands before replying to any of them, so a
+// client that does not pipeline will stall and the test times out.
+type fakeNNTPServer struct {
+	ln      net.Listener
+	batch   int
+	missing map[string]bool // message IDs answered with 430
+
+	mu     sync.Mutex
+	served int // BODY commands answered with an article
+}
+
+func newFakeNNTPServer(t *testing.T, batch int) *fakeNNTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNNTPServer{ln: ln, batch: batch, missing: make(map[string]bool)}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeNNTPServer) config() config.ServerConfig {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return config.ServerConfig{
+		Name:        "fake",
+		Host:        "127.0.0.1",
+		Port:        addr.Port,
+		Connections: 1,
+		Pipeline:    s.batch,
+		Enabled:     true,
+	}
+}
+
+func (s *fakeNNTPServer) setMissing(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.missing[id] = true
+}
+
+func (s *fakeNNTPServer) servedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.served
+}
+
+func (s *fakeNNTPServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeNNTPServer) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	fmt.Fprintf(w, "200 fake server ready\r\n")
+	w.Flush()
+
+	var queued []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "QUIT" {
+			fmt.Fprintf(w, "205 bye\r\n")
+			w.Flush()
+			return
+		}
+		queued = append(queued, line)
+		if len(queued) < s.batch {
+			continue
+		}
+		for _, cmd := range queued {
+			verb, id, _ := strings.Cut(cmd, " ")
+			s.mu.Lock()
+			missing := s.missing[id]
+			if !missing && verb == "BODY" {
+				s.served++
+			}
+			s.mu.Unlock()
+			if missing {
+				fmt.Fprintf(w, "430 no such article\r\n")
+				continue
+			}
+			if verb == "STAT" {
+				fmt.Fprintf(w, "223 0 %s\r\n", id)
+				continue
+			}
+			fmt.Fprintf(w, "222 0 %s\r\n", id)
+			fmt.Fprintf(w, "body of %s\r\n", id)
+			fmt.Fprintf(w, "..dot-stuffed\r\n")
+			fmt.Fprintf(w, ".\r\n")
+		}
+		w.Flush()
+		queued = queued[:0]
+	}
+}
+
+func TestFetchBodyPipelined(t *testing.T) {
+	const depth = 4
+	srv := newFakeNNTPServer(t, depth)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Connect(ctx, srv.config(), "")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	results := make([]string, depth)
+	errs := make([]error, depth)
+	for i := 0; i < depth; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := conn.FetchBody("seg" + strconv.Itoa(i) + "@test")
+			results[i], errs[i] = string(data), err
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("requests were not pipelined: server never received a full batch")
+	}
+
+	for i := 0; i < depth; i++ {
+		if errs[i] != nil {
+			t.Fatalf("request %d: %v", i, errs[i])
+		}
+		want := fmt.Sprintf("body of <seg%d@test>\r\n.dot-stuffed\r\n", i)
+		if results[i] != want {
+			t.Errorf("request %d: got %q, want %q", i, results[i], want)
+		}
+	}
+}
+
+func TestFetchBodyPipelinedMissingArticle(t *testing.T) {
+	srv := newFakeNNTPServer(t, 3)
+	srv.setMissing("<gone@test>")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Connect(ctx, srv.config(), "")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer conn.Close()
+
+	ids := []string{"a@test", "gone@test", "b@test"}
+	errs := make([]error, len(ids))
+	bodies := make([]string, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			data, err := conn.FetchBody(id)
+			bodies[i], errs[i] = string(data), err
+		}(i, id)
+		// Stagger the sends so the command order is deterministic
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if !errors.Is(errs[1], ErrArticleNotFound) {
+		t.Errorf("expected ErrArticleNotFound for the missing article, got %v", errs[1])
+	}
+	for _, i := range []int{0, 2} {
+		if errs[i] != nil {
+			t.Errorf("request %d: %v", i, errs[i])
+		}
+		if !strings.Contains(bodies[i], ids[i]) {
+			t.Errorf("request %d: response matched to wrong caller: %q", i, bodies[i])
+		}
+	}
+	if conn.Broken() {
+		t.Error("a 430 response must not break the connection")
+	}
+}
+
+func TestConnectionPoolSharesConnections(t *testing.T) {
+	const depth = 3
+	srv := newFakeNNTPServer(t, depth)
+	pool := NewConnectionPool(srv.config(), "")
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conns []*NNTPConn
+	for i := 0; i < depth; i++ {
+		c, err := pool.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns[1:] {
+		if c != conns[0] {
+			t.Fatal("expected all callers to share the single connection")
+		}
+	}
+
+	// Pool is full: the next Get must wait until a slot is released.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	if _, err := pool.Get(waitCtx); err == nil {
+		t.Fatal("expected Get to block when every pipeline slot is in use")
+	}
+
+	pool.Put(conns[0])
+	c, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get after Put: %v", err)
+	}
+	if c != conns[0] {
+		t.Error("expected the released slot to be reused")
+	}
+}
+
+func TestFetchSegmentFailsOverOnMissingArticle(t *testing.T) {
+	primary := newFakeNNTPServer(t, 1)
+	primary.setMissing("<old@test>")
+	backup := newFakeNNTPServer(t, 1)
+
+	primaryCfg := primary.config()
+	primaryCfg.Name = "primary"
+	backupCfg := backup.config()
+	backupCfg.Name = "backup"
+	backupCfg.Priority = 1
+
+	pm := NewPoolManager("")
+	pm.UpdateServers([]config.ServerConfig{backupCfg, primaryCfg})
+	defer pm.CloseAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Articles the primary has must never touch the backup.
+	if _, err := pm.FetchSegment(ctx, "new@test"); err != nil {
+		t.Fatalf("FetchSegment(new): %v", err)
+	}
+	if n := backup.servedCount(); n != 0 {
+		t.Errorf("backup served %d articles, want 0", n)
+	}
+
+	// A 430 from the primary goes straight to the backup, without backoff.
+	start := time.Now()
+	data, err := pm.FetchSegment(ctx, "old@test")
+	if err != nil {
+		t.Fatalf("FetchSegment(old): %v", err)
+	}
+	if !strings.Contains(string(data), "old@test") {
+		t.Errorf("unexpected body %q", data)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("failover took %v, expected no backoff", elapsed)
+	}
+	if n := backup.servedCount(); n != 1 {
+		t.Errorf("backup served %d articles, want 1", n)
+	}
+
+	// Missing everywhere fails fast with a clear error.
+	backup.setMissing("<gone@test>")
+	primary.setMissing("<gone@test>")
+	if _, err := pm.FetchSegment(ctx, "gone@test"); !errors.Is(err, ErrArticleNotFound) {
+		t.Errorf("expected ErrArticleNotFound, got %v", err)
+	}
+}
+
+func TestStatSegment(t *testing.T) {
+	primary := newFakeNNTPServer(t, 1)
+	primary.setMissing("<old@test>")
+	primary.setMissing("<gone@test>")
+	backup := newFakeNNTPServer(t, 1)
+	backup.setMissing("<gone@test>")
+
+	primaryCfg := primary.config()
+	primaryCfg.Name = "primary"
+	backupCfg := backup.config()
+	backupCfg.Name = "backup"
+	backupCfg.Priority = 1
+
+	pm := NewPoolManager("")
+	pm.UpdateServers([]config.ServerConfig{primaryCfg, backupCfg})
+	defer pm.CloseAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for id, want := range map[string]bool{"new@test": true, "old@test": true, "gone@test": false} {
+		ok, err := pm.StatSegment(ctx, id)
+		if err != nil {
+			t.Fatalf("StatSegment(%s): %v", id, err)
+		}
+		if ok != want {
+			t.Errorf("StatSegment(%s) = %v, want %v", id, ok, want)
+		}
+	}
+	if primary.servedCount()+backup.servedCount() != 0 {
+		t.Error("STAT must not fetch article bodies")
+	}
+}
+
+func TestPoolManagerDialer(t *testing.T) {
+	srv := newFakeNNTPServer(t, 1)
+	cfg := srv.config()
+	cfg.Host = "news.invalid" // only reachable through the dialer
+
+	var dialed []string
+	var mu sync.Mutex
+	pm := NewPoolManager("")
+	pm.SetTunnel("", func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, address)
+		mu.Unlock()
+		return (&net.Dialer{}).DialContext(ctx, network, srv.ln.Addr().String())
+	}, nil)
+	pm.UpdateServers([]config.ServerConfig{cfg})
+	defer pm.CloseAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if ok, err := pm.StatSegment(ctx, "a@test"); err != nil || !ok {
+		t.Fatalf("StatSegment: %v, %v", ok, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) == 0 || dialed[0] != fmt.Sprintf("news.invalid:%d", cfg.Port) {
+		t.Errorf("dialed %v", dialed)
+	}
+}
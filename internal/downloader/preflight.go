@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"nzb-connect/internal/config"
+	"nzb-connect/internal/nzb"
+	"nzb-connect/internal/queue"
+)
+
+// SetPreflight configures the STAT availability check run before a new job
+// starts downloading.
+func (e *Engine) SetPreflight(p config.PreflightConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.preflight = p
+}
+
+// errPreflight is returned by checkAvailability when too few articles exist.
+type errPreflight struct {
+	available, threshold float64
+	checked              int
+}
+
+func (e *errPreflight) Error() string {
+	return fmt.Sprintf("pre-flight check failed: only %.1f%% of %d sampled articles available (need %.0f%%)",
+		e.available, e.checked, e.threshold)
+}
+
+// checkAvailability sends STAT for a sample of the wanted segments and
+// returns an *errPreflight if the share that exists on any server is below
+// the category's threshold. Articles the servers can't answer for (e.g. all
+// servers down) are left out of the count.
+func (e *Engine) checkAvailability(ctx context.Context, dl *queue.Download, n *nzb.NZB, states []string) error {
+	e.mu.Lock()
+	p := e.preflight
+	e.mu.Unlock()
+	if !p.Enabled {
+		return nil
+	}
+
+	var ids []string
+	for i, file := range n.Files {
+		if states[i] != queue.FileWanted {
+			continue
+		}
+		for _, seg := range file.Segments {
+			ids = append(ids, seg.MessageID)
+		}
+	}
+	ids = sampleSegments(ids, p.Sample)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	log.Printf("Pre-flight check for %s: checking %d article(s)", dl.Name, len(ids))
+	var found, checked atomic.Int32
+	sem := make(chan struct{}, max(e.poolMgr.Capacity(), e.workers))
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ok, err := e.poolMgr.StatSegment(ctx, id)
+			if err != nil {
+				return
+			}
+			checked.Add(1)
+			if ok {
+				found.Add(1)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if checked.Load() == 0 {
+		log.Printf("Pre-flight check for %s: no server answered, skipping", dl.Name)
+		return nil
+	}
+	available := float64(found.Load()) / float64(checked.Load()) * 100
+	threshold := p.Threshold(dl.Category)
+	log.Printf("Pre-flight check for %s: %.1f%% of %d article(s) available (need %.0f%%)",
+		dl.Name, available, checked.Load(), threshold)
+	if available < threshold {
+		return &errPreflight{available: available, threshold: threshold, checked: int(checked.Load())}
+	}
+	return nil
+}
+
+// sampleSegments returns up to n message IDs spread evenly across ids, so
+// that damage anywhere in the job is likely to be seen. n <= 0 means all.
+func sampleSegments(ids []string, n int) []string {
+	if n <= 0 || n >= len(ids) {
+		return ids
+	}
+	sample := make([]string, n)
+	for i := range sample {
+		sample[i] = ids[i*len(ids)/n]
+	}
+	return sample
+}
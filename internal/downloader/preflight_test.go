@@ -0,0 +1,20 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSampleSegments(t *testing.T) {
+	ids := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	if got := sampleSegments(ids, 0); !reflect.DeepEqual(got, ids) {
+		t.Errorf("sample 0: got %v, want all", got)
+	}
+	if got := sampleSegments(ids, 20); !reflect.DeepEqual(got, ids) {
+		t.Errorf("sample 20: got %v, want all", got)
+	}
+	want := []string{"0", "2", "5", "7"}
+	if got := sampleSegments(ids, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("sample 4: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter caps the combined download rate of all jobs. Each caller
+// reserves the time its bytes take at the current rate and sleeps until that
+// reservation ends, so the limit holds however many segments are in flight.
+// The zero value is unlimited.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64     // bytes per second; 0 = unlimited
+	next time.Time // end of the last reservation
+}
+
+func (l *rateLimiter) setRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = max(bytesPerSec, 0)
+	l.next = time.Time{}
+}
+
+func (l *rateLimiter) getRate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// wait blocks until n bytes may be passed on, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
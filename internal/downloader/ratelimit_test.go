@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var l rateLimiter
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		l.wait(ctx, 1<<20)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited limiter waited %v", elapsed)
+	}
+
+	// 200 KB at 1 MB/s from concurrent callers takes about 200ms
+	l.setRate(1_000_000)
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.wait(ctx, 40_000)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("5 × 40 KB at 1 MB/s took %v, want about 200ms", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.wait(cancelled, 10_000_000); err == nil {
+		t.Error("expected wait to return the context error")
+	}
+}
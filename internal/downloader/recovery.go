@@ -0,0 +1,194 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"nzb-connect/internal/nzb"
+	"nzb-connect/internal/queue"
+)
+
+// FileInfo describes one file of a queued download.
+type FileInfo struct {
+	Index        int    `json:"index"`
+	Filename     string `json:"filename"`
+	Bytes        int64  `json:"bytes"`
+	Segments     int    `json:"segments"`
+	DoneSegments int    `json:"done_segments"`
+	State        string `json:"state"`       // queue.File* value
+	Par2Blocks   int    `json:"par2_blocks"` // recovery blocks, for par2 volumes
+}
+
+// fileStates returns the state of every file in the NZB. Files without a
+// recorded state are wanted, except par2 recovery volumes, which are held
+// back while the NZB also has a par2 index to verify with: they are usually
+// 10–15% of the job and rarely needed.
+func fileStates(n *nzb.NZB, recorded map[int]string) []string {
+	hasIndex := false
+	for i := range n.Files {
+		f := &n.Files[i]
+		if strings.HasSuffix(strings.ToLower(f.Filename()), ".par2") && !f.IsPar2Volume() {
+			hasIndex = true
+			break
+		}
+	}
+
+	states := make([]string, len(n.Files))
+	for i := range n.Files {
+		switch {
+		case recorded[i] != "":
+			states[i] = recorded[i]
+		case hasIndex && n.Files[i].IsPar2Volume():
+			states[i] = queue.FileHeld
+		default:
+			states[i] = queue.FileWanted
+		}
+	}
+	return states
+}
+
+// hasPar2 reports whether the NZB includes any par2 files.
+func hasPar2(n *nzb.NZB) bool {
+	for i := range n.Files {
+		if strings.HasSuffix(strings.ToLower(n.Files[i].Filename()), ".par2") {
+			return true
+		}
+	}
+	return false
+}
+
+// Files lists the files of a download with their state and progress.
+func (e *Engine) Files(id string) ([]FileInfo, error) {
+	dl, err := e.queueMgr.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	nzbFile, err := nzb.ParseBytes(dl.NZBData)
+	if err != nil {
+		return nil, fmt.Errorf("parsing NZB: %w", err)
+	}
+	recorded, err := e.queueMgr.GetFileStates(id)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := e.queueMgr.GetFileProgress(id)
+	if err != nil {
+		return nil, err
+	}
+
+	states := fileStates(nzbFile, recorded)
+	files := make([]FileInfo, len(nzbFile.Files))
+	for i := range nzbFile.Files {
+		f := &nzbFile.Files[i]
+		files[i] = FileInfo{
+			Index:        i,
+			Filename:     f.Filename(),
+			Bytes:        f.TotalSize(),
+			Segments:     len(f.Segments),
+			DoneSegments: segmentBitmapFromBytes(len(f.Segments), saved[i]).Count(),
+			State:        states[i],
+			Par2Blocks:   f.Par2Blocks(),
+		}
+	}
+	return files, nil
+}
+
+// QueueRecoveryBlocks releases held-back par2 volumes holding at least blocks
+// recovery blocks, choosing the combination that downloads the fewest extra
+// blocks, and requeues the download to fetch them. It returns the number of
+// blocks queued, or 0 if the held-back volumes can't cover the shortfall.
+func (e *Engine) QueueRecoveryBlocks(id string, blocks int) (int, error) {
+	dl, err := e.queueMgr.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	nzbFile, err := nzb.ParseBytes(dl.NZBData)
+	if err != nil {
+		return 0, fmt.Errorf("parsing NZB: %w", err)
+	}
+	recorded, err := e.queueMgr.GetFileStates(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var held []recoveryVolume
+	for i, state := range fileStates(nzbFile, recorded) {
+		if state == queue.FileHeld {
+			held = append(held, recoveryVolume{index: i, blocks: nzbFile.Files[i].Par2Blocks()})
+		}
+	}
+	pick, total := pickRecoveryVolumes(held, blocks)
+	if len(pick) == 0 {
+		return 0, nil
+	}
+
+	for _, idx := range pick {
+		if err := e.queueMgr.SetFileState(id, idx, queue.FileWanted); err != nil {
+			return 0, err
+		}
+	}
+	if err := e.queueMgr.UpdateStatus(id, queue.StatusQueued); err != nil {
+		return 0, err
+	}
+	log.Printf("Queued %d par2 volume(s) with %d recovery block(s) for %s (%d needed)",
+		len(pick), total, dl.Name, blocks)
+	e.Notify()
+	return total, nil
+}
+
+// recoveryVolume is a held-back par2 volume.
+type recoveryVolume struct {
+	index  int
+	blocks int
+}
+
+// pickRecoveryVolumes returns the file indexes of the volumes whose block
+// counts add up to the smallest total that is at least need, and that total.
+// It returns nil if all volumes together fall short.
+func pickRecoveryVolumes(volumes []recoveryVolume, need int) ([]int, int) {
+	if need <= 0 {
+		return nil, 0
+	}
+	sum := 0
+	for _, v := range volumes {
+		sum += v.blocks
+	}
+	if sum < need {
+		return nil, 0
+	}
+
+	// Subset sum over block counts; from[s] is the volume that first reached
+	// total s (and prev[s] the total before it), so the choice can be rebuilt.
+	from := make([]int, sum+1)
+	prev := make([]int, sum+1)
+	for s := range from {
+		from[s] = -1
+	}
+	reached := make([]bool, sum+1)
+	reached[0] = true
+	for vi, v := range volumes {
+		if v.blocks <= 0 {
+			continue
+		}
+		for s := sum; s >= v.blocks; s-- {
+			if !reached[s] && reached[s-v.blocks] {
+				reached[s] = true
+				from[s] = vi
+				prev[s] = s - v.blocks
+			}
+		}
+	}
+
+	best := need
+	for !reached[best] {
+		best++
+	}
+	var pick []int
+	for s := best; s > 0; s = prev[s] {
+		pick = append(pick, volumes[from[s]].index)
+	}
+	sort.Ints(pick)
+	return pick, best
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+
+	"nzb-connect/internal/nzb"
+	"nzb-connect/internal/queue"
+)
+
+func TestPickRecoveryVolumes(t *testing.T) {
+	// Typical par2 layout: volumes of 1, 2, 4, 8, 16 blocks
+	volumes := []recoveryVolume{
+		{index: 3, blocks: 1},
+		{index: 4, blocks: 2},
+		{index: 5, blocks: 4},
+		{index: 6, blocks: 8},
+		{index: 7, blocks: 16},
+	}
+	tests := []struct {
+		need  int
+		pick  []int
+		total int
+	}{
+		{0, nil, 0},
+		{1, []int{3}, 1},
+		{3, []int{3, 4}, 3},
+		{5, []int{3, 5}, 5},
+		{16, []int{7}, 16},
+		{17, []int{3, 7}, 17},
+		{31, []int{3, 4, 5, 6, 7}, 31},
+		{32, nil, 0},
+	}
+	for _, tt := range tests {
+		pick, total := pickRecoveryVolumes(volumes, tt.need)
+		if !reflect.DeepEqual(pick, tt.pick) || total != tt.total {
+			t.Errorf("need %d: got %v (%d blocks), want %v (%d blocks)", tt.need, pick, total, tt.pick, tt.total)
+		}
+	}
+}
+
+func TestFileStatesHoldsRecoveryVolumes(t *testing.T) {
+	files := func(names ...string) *nzb.NZB {
+		n := &nzb.NZB{}
+		for _, name := range names {
+			n.Files = append(n.Files, nzb.File{Subject: `"` + name + `" yEnc (1/1)`})
+		}
+		return n
+	}
+
+	n := files("a.rar", "a.par2", "a.vol0+1.par2", "a.vol1+2.par2")
+	got := fileStates(n, map[int]string{3: queue.FileWanted})
+	want := []string{queue.FileWanted, queue.FileWanted, queue.FileHeld, queue.FileWanted}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without an index there is nothing to verify with, so nothing is held
+	n = files("a.rar", "a.vol0+1.par2")
+	got = fileStates(n, nil)
+	want = []string{queue.FileWanted, queue.FileWanted}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("no index: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// segmentFile assembles a file on disk from decoded yEnc parts. Each part is
+// written at its =ypart offset as soon as it is decoded, so parts can arrive
+// in any order and nothing has to be buffered in memory.
+type segmentFile struct {
+	f    *os.File
+	path string
+
+	mu      sync.Mutex
+	sized   bool
+	written *segmentBitmap
+}
+
+// newSegmentFile creates (or truncates) path for a file of the given number
+// of segments.
+func newSegmentFile(path string, segments int) (*segmentFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("creating file %s: %w", path, err)
+	}
+	return &segmentFile{
+		f:       f,
+		path:    path,
+		written: newSegmentBitmap(segments),
+	}, nil
+}
+
+// resumeSegmentFile reopens a partially written file, trusting the saved
+// bitmap for which segments are already on disk. If there is nothing to
+// resume, or the file has gone missing, it starts from scratch.
+func resumeSegmentFile(path string, segments int, saved []byte) (*segmentFile, error) {
+	written := segmentBitmapFromBytes(segments, saved)
+	if written.Count() == 0 {
+		return newSegmentFile(path, segments)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return newSegmentFile(path, segments)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reopening file %s: %w", path, err)
+	}
+	return &segmentFile{
+		f:       f,
+		path:    path,
+		sized:   true,
+		written: written,
+	}, nil
+}
+
+// WriteSegment writes the decoded part for segment idx (0-based, in segment
+// number order) at its offset and marks it as written.
+func (sf *segmentFile) WriteSegment(idx int, part *YEncPart) error {
+	offset, err := sf.partOffset(part)
+	if err != nil {
+		return err
+	}
+
+	// The first part to arrive tells us the full file size; preallocate so
+	// later WriteAt calls never extend the file piecemeal.
+	sf.mu.Lock()
+	if !sf.sized && part.Size > 0 {
+		if err := sf.f.Truncate(int64(part.Size)); err != nil {
+			sf.mu.Unlock()
+			return fmt.Errorf("preallocating %s: %w", sf.path, err)
+		}
+		sf.sized = true
+	}
+	sf.mu.Unlock()
+
+	if part.Size > 0 && offset+int64(len(part.Data)) > int64(part.Size) {
+		return fmt.Errorf("part %d-%d exceeds file size %d", offset+1, offset+int64(len(part.Data)), part.Size)
+	}
+
+	if _, err := sf.f.WriteAt(part.Data, offset); err != nil {
+		return err
+	}
+
+	sf.mu.Lock()
+	sf.written.Set(idx)
+	sf.mu.Unlock()
+	return nil
+}
+
+// partOffset returns the zero-based file offset for a decoded part. yEnc
+// =ypart ranges are 1-based and inclusive.
+func (sf *segmentFile) partOffset(part *YEncPart) (int64, error) {
+	if part.Begin == 0 {
+		// Single-part posts have no =ypart line and start at zero.
+		if sf.written.Len() == 1 {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("part %d has no =ypart offset", part.Part)
+	}
+	if part.End > 0 && part.End-part.Begin+1 != int64(len(part.Data)) {
+		return 0, fmt.Errorf("=ypart range %d-%d does not match %d decoded bytes", part.Begin, part.End, len(part.Data))
+	}
+	return part.Begin - 1, nil
+}
+
+// Has reports whether segment idx is already on disk.
+func (sf *segmentFile) Has(idx int) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.written.Has(idx)
+}
+
+// Count returns the number of segments on disk.
+func (sf *segmentFile) Count() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.written.Count()
+}
+
+// Snapshot returns the serialised bitmap of segments on disk.
+func (sf *segmentFile) Snapshot() []byte {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.written.Bytes()
+}
+
+// FirstMissing returns the index of the first segment not yet written, or -1
+// if the file is complete.
+func (sf *segmentFile) FirstMissing() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.written.FirstMissing()
+}
+
+// Close closes the underlying file.
+func (sf *segmentFile) Close() error {
+	return sf.f.Close()
+}
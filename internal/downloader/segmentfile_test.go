@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegmentFileOutOfOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	content := []byte("abcdefghijklmnopqrstuvwxyz")
+
+	sf, err := newSegmentFile(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := []*YEncPart{
+		{Part: 1, Size: len(content), Begin: 1, End: 10, Data: content[0:10]},
+		{Part: 2, Size: len(content), Begin: 11, End: 20, Data: content[10:20]},
+		{Part: 3, Size: len(content), Begin: 21, End: 26, Data: content[20:26]},
+	}
+
+	// Write the last part first, then the first; the middle one is still missing.
+	for _, idx := range []int{2, 0} {
+		if err := sf.WriteSegment(idx, parts[idx]); err != nil {
+			t.Fatalf("WriteSegment(%d): %v", idx, err)
+		}
+	}
+	if got := sf.FirstMissing(); got != 1 {
+		t.Errorf("FirstMissing = %d, want 1", got)
+	}
+
+	if err := sf.WriteSegment(1, parts[1]); err != nil {
+		t.Fatalf("WriteSegment(1): %v", err)
+	}
+	if got := sf.FirstMissing(); got != -1 {
+		t.Errorf("FirstMissing = %d, want -1", got)
+	}
+	sf.Close()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("assembled %q, want %q", got, content)
+	}
+}
+
+func TestSegmentFileRejectsBadRange(t *testing.T) {
+	sf, err := newSegmentFile(filepath.Join(t.TempDir(), "out.bin"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	// Range claims 10 bytes but only 5 were decoded.
+	bad := &YEncPart{Part: 1, Size: 20, Begin: 1, End: 10, Data: []byte("short")}
+	if err := sf.WriteSegment(0, bad); err == nil {
+		t.Error("expected an error for a mismatched =ypart range")
+	}
+
+	// Multi-segment file with no =ypart offset cannot be placed.
+	noOffset := &YEncPart{Part: 2, Size: 20, Data: []byte("data")}
+	if err := sf.WriteSegment(1, noOffset); err == nil {
+		t.Error("expected an error for a part without an offset")
+	}
+}
+
+func TestSegmentBitmap(t *testing.T) {
+	b := newSegmentBitmap(130)
+	for _, i := range []int{0, 63, 64, 129} {
+		b.Set(i)
+	}
+	if b.Count() != 4 {
+		t.Errorf("Count = %d, want 4", b.Count())
+	}
+	if !b.Has(64) || b.Has(65) {
+		t.Error("Has returned wrong values around a word boundary")
+	}
+	if b.FirstMissing() != 1 {
+		t.Errorf("FirstMissing = %d, want 1", b.FirstMissing())
+	}
+}
+
+func TestResumeSegmentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bin")
+	content := []byte("0123456789abcdefghij")
+	parts := []*YEncPart{
+		{Part: 1, Size: 20, Begin: 1, End: 10, Data: content[0:10]},
+		{Part: 2, Size: 20, Begin: 11, End: 20, Data: content[10:20]},
+	}
+
+	sf, err := newSegmentFile(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.WriteSegment(1, parts[1]); err != nil {
+		t.Fatal(err)
+	}
+	saved := sf.Snapshot()
+	sf.Close()
+
+	// Simulate a restart: the second segment must survive and be skipped.
+	sf, err = resumeSegmentFile(path, 2, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sf.Has(1) || sf.Has(0) {
+		t.Fatalf("resumed bitmap wrong: has(0)=%v has(1)=%v", sf.Has(0), sf.Has(1))
+	}
+	if err := sf.WriteSegment(0, parts[0]); err != nil {
+		t.Fatal(err)
+	}
+	sf.Close()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("assembled %q, want %q", got, content)
+	}
+
+	// A saved bitmap whose file has vanished starts from scratch.
+	os.Remove(path)
+	sf, err = resumeSegmentFile(path, 2, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+	if sf.Count() != 0 {
+		t.Errorf("expected an empty bitmap when the file is missing, got %d", sf.Count())
+	}
+}
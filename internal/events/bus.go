@@ -0,0 +1,74 @@
+// Package events is an in-process publish/subscribe bus. The queue, engine,
+// post-processor and VPN manager publish what changes, and the web UI follows
+// along through /api/events instead of polling.
+package events
+
+import "sync"
+
+// Event types.
+const (
+	Queue       = "queue"       // a download was added, removed, reordered or changed status
+	Progress    = "progress"    // download progress of a running job, once a second
+	PostProcess = "postprocess" // verify/repair/extract stage and progress
+	Status      = "status"      // the queue was paused or resumed
+	VPN         = "vpn"         // the VPN connection changed state
+)
+
+// Event is something that happened. Data is marshalled to JSON for clients.
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind by before
+// it is dropped.
+const subscriberBuffer = 256
+
+// Bus fans events out to subscribers. A nil *Bus is valid and discards
+// everything, so publishers don't need to check whether one was set.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewBus creates an empty bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber without blocking. A subscriber
+// whose buffer is full is dropped: its channel is closed, and it should
+// resubscribe and reload its state rather than carry on with gaps.
+func (b *Bus) Publish(typ string, data interface{}) {
+	if b == nil {
+		return
+	}
+	ev := Event{Type: typ, Data: data}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function that unsubscribes.
+// The channel is closed on unsubscribe or if the subscriber falls behind.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package events
+
+import "testing"
+
+func TestBusDelivers(t *testing.T) {
+	b := NewBus()
+	a, unsubA := b.Subscribe()
+	c, unsubC := b.Subscribe()
+	defer unsubC()
+
+	b.Publish(Queue, "x")
+	for _, ch := range []<-chan Event{a, c} {
+		ev := <-ch
+		if ev.Type != Queue || ev.Data != "x" {
+			t.Errorf("got %+v", ev)
+		}
+	}
+
+	unsubA()
+	unsubA() // harmless twice
+	if _, ok := <-a; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	b.Publish(Queue, "y")
+	if ev := <-c; ev.Data != "y" {
+		t.Errorf("got %+v", ev)
+	}
+}
+
+func TestBusDropsSlowSubscriber(t *testing.T) {
+	b := NewBus()
+	ch, unsub := b.Subscribe()
+	defer unsub()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(Progress, i)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("got %d events before close, want %d", n, subscriberBuffer)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var b *Bus
+	b.Publish(VPN, nil) // must not panic
+}
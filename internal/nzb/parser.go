@@ -83,6 +83,33 @@ func (f *File) Filename() string {
 	return subj
 }
 
+// IsPar2Volume reports whether the file is a par2 recovery volume
+// (name.vol03+04.par2), as opposed to the par2 index or a data file.
+func (f *File) IsPar2Volume() bool {
+	name := strings.ToLower(f.Filename())
+	return strings.HasSuffix(name, ".par2") && strings.Contains(name, ".vol")
+}
+
+// Par2Blocks returns the number of recovery blocks in a par2 volume, taken
+// from the "+NN" in its name. It returns 0 if the file is not a volume or the
+// name doesn't say.
+func (f *File) Par2Blocks() int {
+	if !f.IsPar2Volume() {
+		return 0
+	}
+	name := strings.ToLower(f.Filename())
+	name = strings.TrimSuffix(name, ".par2")
+	plus := strings.LastIndex(name, "+")
+	if plus < 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(name[plus+1:])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
 // SortedSegments returns segments sorted by number.
 func (f *File) SortedSegments() []Segment {
 	sorted := make([]Segment, len(f.Segments))
@@ -100,6 +100,29 @@ func TestFilename(t *testing.T) {
 	}
 }
 
+func TestPar2Volume(t *testing.T) {
+	tests := []struct {
+		subject string
+		volume  bool
+		blocks  int
+	}{
+		{`Release [3/9] - "release.par2" yEnc (1/1)`, false, 0},
+		{`Release [4/9] - "release.vol00+01.par2" yEnc (1/1)`, true, 1},
+		{`Release [5/9] - "release.vol07+08.PAR2" yEnc (1/9)`, true, 8},
+		{`Release [6/9] - "release.vol15+16.par2" yEnc (1/17)`, true, 16},
+		{`Release [1/9] - "release.part01.rar" yEnc (1/50)`, false, 0},
+	}
+	for _, tt := range tests {
+		f := File{Subject: tt.subject}
+		if got := f.IsPar2Volume(); got != tt.volume {
+			t.Errorf("%s: IsPar2Volume = %v, want %v", f.Filename(), got, tt.volume)
+		}
+		if got := f.Par2Blocks(); got != tt.blocks {
+			t.Errorf("%s: Par2Blocks = %d, want %d", f.Filename(), got, tt.blocks)
+		}
+	}
+}
+
 func TestTotalSize(t *testing.T) {
 	nzb, err := Parse(strings.NewReader(testNZB))
 	if err != nil {
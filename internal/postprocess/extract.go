@@ -2,6 +2,7 @@ package postprocess
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +16,7 @@ import (
 	"github.com/nwaples/rardecode/v2"
 
 	"nzb-connect/internal/config"
+	"nzb-connect/internal/events"
 	"nzb-connect/internal/nzb"
 	"nzb-connect/internal/queue"
 )
@@ -116,8 +118,10 @@ func parseUnrarLine(line string) (float64, string) {
 
 // Processor handles post-processing of completed downloads.
 type Processor struct {
-	cfg      *config.Config
-	queueMgr *queue.Manager
+	cfg          *config.Config
+	queueMgr     *queue.Manager
+	needRecovery func(id string, blocks int) (int, error)
+	events       *events.Bus
 }
 
 // NewProcessor creates a new post-processor.
@@ -125,6 +129,25 @@ func NewProcessor(cfg *config.Config, queueMgr *queue.Manager) *Processor {
 	return &Processor{cfg: cfg, queueMgr: queueMgr}
 }
 
+// SetEvents sets the bus post-processing progress is published to.
+func (p *Processor) SetEvents(bus *events.Bus) {
+	p.events = bus
+}
+
+// setStage records the post-processing stage a download has reached.
+func (p *Processor) setStage(id, stage string) {
+	p.queueMgr.SetProcessingStage(id, stage)
+	p.events.Publish(events.PostProcess, map[string]interface{}{"id": id, "stage": stage})
+}
+
+// OnNeedRecovery sets a callback for when a repair is short of recovery
+// blocks. It should queue at least blocks more and return how many it queued,
+// or 0 if it can't; the download then comes back through Process once they
+// have been fetched.
+func (p *Processor) OnNeedRecovery(fn func(id string, blocks int) (int, error)) {
+	p.needRecovery = fn
+}
+
 // Process runs post-processing on a completed download.
 func (p *Processor) Process(dl *queue.Download) {
 	log.Printf("Post-processing: %s", dl.Name)
@@ -156,6 +179,35 @@ func (p *Processor) Process(dl *queue.Download) {
 		}
 	}
 
+	// Progress is published once per whole percent or file, not per line
+	lastPct, lastFile := -1, ""
+	onProgress := ProgressFunc(func(pct float64, file string) {
+		p.queueMgr.SetExtractProgress(dl.ID, pct, file)
+		if int(pct) != lastPct || file != lastFile {
+			lastPct, lastFile = int(pct), file
+			p.events.Publish(events.PostProcess, map[string]interface{}{"id": dl.ID, "pct": pct, "file": file})
+		}
+	})
+
+	// Verify (and if needed repair) before touching the archives, so a few
+	// missing segments don't turn into a broken RAR set.
+	repairErr := p.repair(dl.ID, srcDir, onProgress)
+	var short *needBlocksError
+	if errors.As(repairErr, &short) && p.needRecovery != nil {
+		queued, err := p.needRecovery(dl.ID, short.damaged-short.available)
+		if err != nil {
+			log.Printf("Error queueing recovery blocks for %s: %v", dl.Name, err)
+		} else if queued > 0 {
+			// Files stay in the incomplete dir; we'll be back once the
+			// volumes are downloaded.
+			p.queueMgr.ClearExtractProgress(dl.ID)
+			return
+		}
+	}
+	if repairErr != nil {
+		log.Printf("Par2 repair failed for %s: %v", dl.Name, repairErr)
+	}
+
 	// Find and extract archives
 	archives, err := findArchives(srcDir)
 	if err != nil {
@@ -163,12 +215,19 @@ func (p *Processor) Process(dl *queue.Download) {
 	}
 
 	extractStart := time.Now()
-	onProgress := ProgressFunc(func(pct float64, file string) {
-		p.queueMgr.SetExtractProgress(dl.ID, pct, file)
-	})
+	p.setStage(dl.ID, queue.StageExtracting)
 
 	extractOK := true
-	if len(archives) > 0 {
+	if repairErr != nil {
+		// Extraction would only fail on the damaged files — hand over the raw
+		// files as-is, like an extraction failure.
+		extractOK = false
+		p.queueMgr.ClearExtractProgress(dl.ID)
+		log.Printf("Moving raw files to complete dir after repair failure: %s", destDir)
+		if err := moveAllFiles(srcDir, destDir); err != nil {
+			log.Printf("Error moving files to complete: %v", err)
+		}
+	} else if len(archives) > 0 {
 		for _, archive := range archives {
 			if err := p.extractArchive(archive, destDir, archivePassword, onProgress); err != nil {
 				log.Printf("Extraction failed for %s: %v", filepath.Base(archive), err)
@@ -207,6 +266,7 @@ func (p *Processor) Process(dl *queue.Download) {
 		}
 	} else {
 		// No archives — move everything as-is
+		p.queueMgr.ClearExtractProgress(dl.ID)
 		if err := moveAllFiles(srcDir, destDir); err != nil {
 			log.Printf("Error moving files: %v", err)
 			p.queueMgr.SetError(dl.ID, fmt.Sprintf("move error: %v", err))
@@ -224,6 +284,12 @@ func (p *Processor) Process(dl *queue.Download) {
 	// Always update the path so history shows the complete directory
 	p.queueMgr.UpdatePath(dl.ID, destDir)
 
+	if repairErr != nil {
+		p.queueMgr.SetError(dl.ID, fmt.Sprintf("repair failed — raw files moved to complete dir: %v", repairErr))
+		log.Printf("Post-processing partial: %s -> %s (repair failed, raw files moved)", dl.Name, destDir)
+		return
+	}
+
 	if !extractOK {
 		// Mark as failed so the ARR stack knows extraction didn't complete,
 		// but the path is already updated to the complete dir for inspection.
@@ -0,0 +1,598 @@
+package postprocess
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nzb-connect/internal/queue"
+)
+
+// PAR2 packet header (all integers little-endian):
+//
+//	magic "PAR2\0PKT" [8] | length uint64 [8] | packet MD5 [16] | set ID [16] | type [16]
+//
+// The packet MD5 covers everything from the set ID to the end of the body.
+var par2Magic = []byte("PAR2\x00PKT")
+
+const par2HeaderLen = 64
+
+const (
+	par2TypeMain     = "PAR 2.0\x00Main\x00\x00\x00\x00"
+	par2TypeFileDesc = "PAR 2.0\x00FileDesc"
+	par2TypeIFSC     = "PAR 2.0\x00IFSC\x00\x00\x00\x00"
+	par2TypeRecovery = "PAR 2.0\x00RecvSlic"
+)
+
+// par2Packet is a packet whose checksum has been verified. For recovery
+// slices Body only holds the 4-byte exponent; the slice data is hashed but
+// not kept.
+type par2Packet struct {
+	SetID [16]byte
+	Type  string
+	Body  []byte
+}
+
+// par2Slice is the checksum pair for one input slice (from an IFSC packet).
+type par2Slice struct {
+	MD5 [16]byte
+	CRC uint32
+}
+
+// par2File describes one file protected by the recovery set.
+type par2File struct {
+	ID       [16]byte
+	Name     string
+	Size     int64
+	MD5      [16]byte // whole file
+	MD5First [16]byte // first 16 KiB, used to find renamed files
+	Slices   []par2Slice
+}
+
+// slices returns the number of input slices the file occupies.
+func (f *par2File) slices(sliceSize int64) int {
+	return int((f.Size + sliceSize - 1) / sliceSize)
+}
+
+// par2Set is a parsed recovery set.
+type par2Set struct {
+	SliceSize int64
+	Files     []*par2File
+	Recovery  int // intact recovery slices across all volumes
+}
+
+// par2Verification is the outcome of checking a directory against a set.
+type par2Verification struct {
+	Damaged  int // input slices that are missing or fail their checksum
+	Recovery int // recovery slices available to repair them
+}
+
+// findPar2Files returns the .par2 files in dir, index file first.
+func findPar2Files(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".par2") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return !isPar2Volume(files[i]) && isPar2Volume(files[j])
+	})
+	return files
+}
+
+// isPar2Volume reports whether path is a recovery volume (name.vol00+01.par2)
+// rather than the index file.
+func isPar2Volume(path string) bool {
+	return strings.Contains(strings.ToLower(filepath.Base(path)), ".vol")
+}
+
+// readPar2Packets calls fn for every packet in path whose checksum is valid.
+// Damaged regions (e.g. zero-filled gaps left by missing segments) are
+// skipped by scanning for the next packet header.
+func readPar2Packets(path string, fn func(par2Packet)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	hdr := make([]byte, par2HeaderLen)
+	var off int64
+	for off+par2HeaderLen <= size {
+		if _, err := f.ReadAt(hdr, off); err != nil {
+			return err
+		}
+		length := int64(binary.LittleEndian.Uint64(hdr[8:16]))
+		if !bytes.Equal(hdr[:8], par2Magic) || length < par2HeaderLen || length%4 != 0 || length > size-off {
+			if off = findPar2Magic(f, off+1, size); off < 0 {
+				break
+			}
+			continue
+		}
+
+		pkt := par2Packet{Type: string(hdr[48:64])}
+		copy(pkt.SetID[:], hdr[32:48])
+		h := md5.New()
+		h.Write(hdr[32:])
+		body := io.NewSectionReader(f, off+par2HeaderLen, length-par2HeaderLen)
+		if pkt.Type == par2TypeRecovery {
+			// Volumes are large: hash the slice data without holding it
+			pkt.Body = make([]byte, 4)
+			if _, err := io.ReadFull(body, pkt.Body); err != nil {
+				return err
+			}
+			h.Write(pkt.Body)
+			if _, err := io.Copy(h, body); err != nil {
+				return err
+			}
+		} else {
+			pkt.Body = make([]byte, length-par2HeaderLen)
+			if _, err := io.ReadFull(body, pkt.Body); err != nil {
+				return err
+			}
+			h.Write(pkt.Body)
+		}
+
+		if !bytes.Equal(h.Sum(nil), hdr[16:32]) {
+			if off = findPar2Magic(f, off+1, size); off < 0 {
+				break
+			}
+			continue
+		}
+		fn(pkt)
+		off += length
+	}
+	return nil
+}
+
+// findPar2Magic returns the offset of the next packet header at or after
+// off, or -1 if there is none.
+func findPar2Magic(f *os.File, off, size int64) int64 {
+	buf := make([]byte, 64*1024)
+	for off < size {
+		n, err := f.ReadAt(buf, off)
+		if n == 0 {
+			return -1
+		}
+		if i := bytes.Index(buf[:n], par2Magic); i >= 0 {
+			return off + int64(i)
+		}
+		if err != nil {
+			return -1
+		}
+		// Overlap so a header split across reads is still found
+		off += int64(n - len(par2Magic) + 1)
+	}
+	return -1
+}
+
+// loadPar2Set parses the recovery set described by the given .par2 files.
+// Packets are duplicated across the index and every volume, so any intact
+// copy will do.
+func loadPar2Set(paths []string) (*par2Set, error) {
+	var (
+		setID    [16]byte
+		main     []byte
+		descs    = make(map[[16]byte]*par2File)
+		ifsc     = make(map[[16]byte][]par2Slice)
+		recovery = make(map[[16]byte]map[uint32]bool)
+	)
+	for _, path := range paths {
+		err := readPar2Packets(path, func(pkt par2Packet) {
+			switch pkt.Type {
+			case par2TypeMain:
+				if main == nil && len(pkt.Body) >= 12 {
+					setID, main = pkt.SetID, pkt.Body
+				}
+			case par2TypeFileDesc:
+				if len(pkt.Body) < 56 {
+					return
+				}
+				pf := &par2File{
+					Size: int64(binary.LittleEndian.Uint64(pkt.Body[48:56])),
+					Name: strings.TrimRight(string(pkt.Body[56:]), "\x00"),
+				}
+				copy(pf.ID[:], pkt.Body[0:16])
+				copy(pf.MD5[:], pkt.Body[16:32])
+				copy(pf.MD5First[:], pkt.Body[32:48])
+				descs[pf.ID] = pf
+			case par2TypeIFSC:
+				if len(pkt.Body) < 16 {
+					return
+				}
+				var id [16]byte
+				copy(id[:], pkt.Body[:16])
+				entries := pkt.Body[16:]
+				slices := make([]par2Slice, len(entries)/20)
+				for i := range slices {
+					e := entries[i*20:]
+					copy(slices[i].MD5[:], e[:16])
+					slices[i].CRC = binary.LittleEndian.Uint32(e[16:20])
+				}
+				ifsc[id] = slices
+			case par2TypeRecovery:
+				if recovery[pkt.SetID] == nil {
+					recovery[pkt.SetID] = make(map[uint32]bool)
+				}
+				recovery[pkt.SetID][binary.LittleEndian.Uint32(pkt.Body)] = true
+			}
+		})
+		if err != nil {
+			log.Printf("Error reading %s: %v", filepath.Base(path), err)
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no intact main packet in par2 files")
+	}
+
+	set := &par2Set{
+		SliceSize: int64(binary.LittleEndian.Uint64(main[0:8])),
+		Recovery:  len(recovery[setID]),
+	}
+	if set.SliceSize <= 0 || set.SliceSize%4 != 0 {
+		return nil, fmt.Errorf("invalid par2 slice size %d", set.SliceSize)
+	}
+	count := int(binary.LittleEndian.Uint32(main[8:12]))
+	if len(main) < 12+count*16 {
+		return nil, fmt.Errorf("truncated par2 main packet")
+	}
+	for i := 0; i < count; i++ {
+		var id [16]byte
+		copy(id[:], main[12+i*16:])
+		pf, ok := descs[id]
+		if !ok {
+			return nil, fmt.Errorf("par2 set is missing a file description packet")
+		}
+		pf.Slices = ifsc[id]
+		set.Files = append(set.Files, pf)
+	}
+	return set, nil
+}
+
+// verifyPar2 checks every file in the set against its checksums. Files that
+// are missing under their protected name but match by size and first-16 KiB
+// hash (obfuscated releases) are renamed back first.
+func verifyPar2(dir string, set *par2Set, onProgress ProgressFunc) (*par2Verification, error) {
+	renameMisnamedFiles(dir, set)
+
+	var total, done int64
+	for _, pf := range set.Files {
+		total += pf.Size
+	}
+
+	v := &par2Verification{Recovery: set.Recovery}
+	for _, pf := range set.Files {
+		damaged, err := verifyPar2File(filepath.Join(dir, pf.Name), pf, set.SliceSize, func(n int64) {
+			done += n
+			if onProgress != nil && total > 0 {
+				onProgress(float64(done*100/total), pf.Name)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if damaged > 0 {
+			log.Printf("par2: %s has %d damaged block(s)", pf.Name, damaged)
+		}
+		v.Damaged += damaged
+	}
+	return v, nil
+}
+
+// verifyPar2File returns the number of damaged slices in path. A missing
+// file counts every slice as damaged.
+func verifyPar2File(path string, pf *par2File, sliceSize int64, progress func(int64)) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pf.slices(sliceSize), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	whole := md5.New()
+	buf := make([]byte, sliceSize)
+	damaged := 0
+	r := bufio.NewReaderSize(f, 1<<20)
+	for i := 0; i < pf.slices(sliceSize); i++ {
+		want := sliceSize
+		if rem := pf.Size - int64(i)*sliceSize; rem < want {
+			want = rem
+		}
+		n, err := io.ReadFull(r, buf[:want])
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		whole.Write(buf[:n])
+		progress(int64(n))
+		if int64(n) < want || i >= len(pf.Slices) {
+			damaged++
+			continue
+		}
+		// Slice checksums are over the slice padded with zeros
+		clear(buf[n:])
+		if crc32.ChecksumIEEE(buf) != pf.Slices[i].CRC || md5.Sum(buf) != pf.Slices[i].MD5 {
+			damaged++
+		}
+	}
+
+	// Trailing bytes beyond the protected size also fail the file hash
+	if extra, _ := io.Copy(whole, r); extra > 0 && damaged == 0 {
+		damaged = 1
+	}
+	if damaged == 0 && !bytes.Equal(whole.Sum(nil), pf.MD5[:]) {
+		damaged = pf.slices(sliceSize)
+	}
+	return damaged, nil
+}
+
+// renameMisnamedFiles restores protected names for files that were posted
+// under a different (usually obfuscated) name.
+func renameMisnamedFiles(dir string, set *par2Set) {
+	known := make(map[string]bool)
+	var missing []*par2File
+	for _, pf := range set.Files {
+		known[pf.Name] = true
+		if _, err := os.Stat(filepath.Join(dir, pf.Name)); os.IsNotExist(err) {
+			missing = append(missing, pf)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || known[name] || strings.EqualFold(filepath.Ext(name), ".par2") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		head, err := md5First16k(path)
+		if err != nil {
+			continue
+		}
+		for i, pf := range missing {
+			if pf.Size != info.Size() || head != pf.MD5First {
+				continue
+			}
+			if err := os.Rename(path, filepath.Join(dir, pf.Name)); err == nil {
+				log.Printf("par2: renamed %s -> %s", name, pf.Name)
+				missing = append(missing[:i], missing[i+1:]...)
+			}
+			break
+		}
+		if len(missing) == 0 {
+			return
+		}
+	}
+}
+
+func md5First16k(path string) ([16]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	defer f.Close()
+	buf := make([]byte, 16*1024)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return [16]byte{}, err
+	}
+	return md5.Sum(buf[:n]), nil
+}
+
+// parsePar2Line parses a progress line from par2cmdline, e.g.
+//
+//	Scanning: "movie.part01.rar": 42.3%
+//	Repairing: 17.0%
+//
+// It returns the leading verb, the percentage and the quoted file name, or
+// pct -1 if the line is not a progress line.
+func parsePar2Line(line string) (verb string, pct float64, file string) {
+	t := strings.TrimSpace(line)
+	if !strings.HasSuffix(t, "%") {
+		return "", -1, ""
+	}
+	colon := strings.Index(t, ":")
+	if colon < 0 {
+		return "", -1, ""
+	}
+	verb = t[:colon]
+	last := t[strings.LastIndex(t, " ")+1:]
+	if _, err := fmt.Sscanf(strings.TrimSuffix(last, "%"), "%f", &pct); err != nil {
+		return "", -1, ""
+	}
+	if q1 := strings.Index(t, `"`); q1 >= 0 {
+		if q2 := strings.LastIndex(t, `"`); q2 > q1 {
+			file = filepath.Base(t[q1+1 : q2])
+		}
+	}
+	return verb, pct, file
+}
+
+// scanLinesOrCR splits on \n or \r; par2 redraws its progress with \r.
+func scanLinesOrCR(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// repairPar2Cmd runs par2 repair with live progress parsing. Every other
+// file in the directory is passed along so par2 can find renamed files.
+func (p *Processor) repairPar2Cmd(id, par2, index string, onProgress ProgressFunc) error {
+	dir := filepath.Dir(index)
+	before := listFiles(dir)
+
+	args := []string{"r", "--", index}
+	for name := range before {
+		if !strings.EqualFold(filepath.Ext(name), ".par2") {
+			args = append(args, filepath.Join(dir, name))
+		}
+	}
+	cmd := exec.Command(par2, args...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("par2 stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting par2: %w", err)
+	}
+
+	stage := queue.StageVerifying
+	var lastFile string
+	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := scanner.Text()
+		verb, pct, file := parsePar2Line(line)
+		if pct < 0 {
+			if strings.TrimSpace(line) != "" {
+				log.Printf("[par2] %s", line)
+			}
+			continue
+		}
+		if verb == "Repairing" && stage != queue.StageRepairing {
+			stage = queue.StageRepairing
+			p.setStage(id, stage)
+		}
+		if file != "" {
+			lastFile = file
+		}
+		if onProgress != nil {
+			onProgress(pct, lastFile)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("par2: %w", err)
+	}
+
+	// A repair leaves the damaged originals behind as name.1, name.2, ...
+	for name := range listFiles(dir) {
+		ext := filepath.Ext(name)
+		if before[name] || len(ext) < 2 || strings.Trim(ext[1:], "0123456789") != "" {
+			continue
+		}
+		if before[strings.TrimSuffix(name, ext)] {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	return nil
+}
+
+func listFiles(dir string) map[string]bool {
+	files := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files[entry.Name()] = true
+		}
+	}
+	return files
+}
+
+// needBlocksError is returned by repair when there are fewer recovery blocks
+// on disk than damaged blocks.
+type needBlocksError struct {
+	damaged, available int
+}
+
+func (e *needBlocksError) Error() string {
+	return fmt.Sprintf("%d damaged block(s), only %d recovery block(s) available", e.damaged, e.available)
+}
+
+// repair verifies the download against its par2 set and repairs it if
+// needed. It is a no-op for downloads without .par2 files.
+// Strategy:
+//  1. External par2 (par2cmdline or par2cmdline-turbo) — verifies and repairs.
+//  2. Pure-Go verifier — checks file and slice checksums and restores renamed
+//     files, but cannot rebuild damaged blocks.
+func (p *Processor) repair(id, dir string, onProgress ProgressFunc) error {
+	par2Files := findPar2Files(dir)
+	if len(par2Files) == 0 {
+		return nil
+	}
+	log.Printf("Verifying with par2: %s", filepath.Base(par2Files[0]))
+	p.setStage(id, queue.StageVerifying)
+
+	// 1. External par2 — the only path that can actually repair.
+	if par2 := resolvePar2(p.cfg.PostProcess.Par2); par2 != "" {
+		if err := p.repairPar2Cmd(id, par2, par2Files[0], onProgress); err == nil {
+			return nil
+		} else {
+			log.Printf("par2 failed (%v), falling back to pure-Go verifier", err)
+		}
+		p.setStage(id, queue.StageVerifying)
+	}
+
+	// 2. Pure-Go verifier.
+	set, err := loadPar2Set(par2Files)
+	if err != nil {
+		return fmt.Errorf("reading par2 set: %w", err)
+	}
+	v, err := verifyPar2(dir, set, onProgress)
+	if err != nil {
+		return fmt.Errorf("verifying: %w", err)
+	}
+	switch {
+	case v.Damaged == 0:
+		log.Printf("par2: all %d file(s) verified", len(set.Files))
+		return nil
+	case v.Damaged > v.Recovery:
+		return &needBlocksError{damaged: v.Damaged, available: v.Recovery}
+	default:
+		return fmt.Errorf("%d damaged block(s) are repairable but par2 is not installed", v.Damaged)
+	}
+}
+
+// resolvePar2 finds par2 (par2cmdline or par2cmdline-turbo) from the config
+// path, PATH, or common locations.
+func resolvePar2(configured string) string {
+	candidates := []string{configured, "par2", "par2cmdline-turbo"}
+	for _, dir := range []string{"/usr/bin", "/usr/local/bin", "/bin"} {
+		candidates = append(candidates, filepath.Join(dir, "par2"))
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if path, err := exec.LookPath(c); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+package postprocess
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSliceSize = 1024
+
+// par2Builder writes minimal but valid PAR2 packets for a set of files.
+type par2Builder struct {
+	setID [16]byte
+	buf   bytes.Buffer
+}
+
+func (b *par2Builder) packet(typ string, body []byte) {
+	hdr := make([]byte, par2HeaderLen)
+	copy(hdr, par2Magic)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(par2HeaderLen+len(body)))
+	copy(hdr[32:], b.setID[:])
+	copy(hdr[48:], typ)
+	h := md5.New()
+	h.Write(hdr[32:])
+	h.Write(body)
+	copy(hdr[16:32], h.Sum(nil))
+	b.buf.Write(hdr)
+	b.buf.Write(body)
+}
+
+// writeTestPar2Set protects files (name -> contents) and writes an index
+// file plus one volume holding the given number of recovery slices.
+func writeTestPar2Set(t *testing.T, dir string, names []string, files map[string][]byte, recovery int) {
+	t.Helper()
+	var index par2Builder
+	index.setID = [16]byte{1, 2, 3}
+
+	main := make([]byte, 12)
+	binary.LittleEndian.PutUint64(main, testSliceSize)
+	binary.LittleEndian.PutUint32(main[8:], uint32(len(names)))
+	for i, name := range names {
+		data := files[name]
+		id := [16]byte{byte(i + 1)}
+		main = append(main, id[:]...)
+
+		desc := append([]byte{}, id[:]...)
+		whole := md5.Sum(data)
+		desc = append(desc, whole[:]...)
+		first := md5.Sum(data[:min(len(data), 16*1024)])
+		desc = append(desc, first[:]...)
+		desc = binary.LittleEndian.AppendUint64(desc, uint64(len(data)))
+		nameBytes := []byte(name)
+		for len(nameBytes)%4 != 0 {
+			nameBytes = append(nameBytes, 0)
+		}
+		desc = append(desc, nameBytes...)
+		index.packet(par2TypeFileDesc, desc)
+
+		ifsc := append([]byte{}, id[:]...)
+		for off := 0; off < len(data); off += testSliceSize {
+			slice := make([]byte, testSliceSize)
+			copy(slice, data[off:])
+			sum := md5.Sum(slice)
+			ifsc = append(ifsc, sum[:]...)
+			ifsc = binary.LittleEndian.AppendUint32(ifsc, crc32.ChecksumIEEE(slice))
+		}
+		index.packet(par2TypeIFSC, ifsc)
+	}
+	index.packet(par2TypeMain, main)
+
+	vol := par2Builder{setID: index.setID}
+	vol.buf.Write(index.buf.Bytes())
+	for i := 0; i < recovery; i++ {
+		body := binary.LittleEndian.AppendUint32(nil, uint32(i))
+		body = append(body, bytes.Repeat([]byte{byte(i)}, testSliceSize)...)
+		vol.packet(par2TypeRecovery, body)
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "set.par2"), index.buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "set.vol0+3.par2"), vol.buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testFiles() ([]string, map[string][]byte) {
+	a := make([]byte, 3*testSliceSize+100)
+	b := make([]byte, 2*testSliceSize)
+	for i := range a {
+		a[i] = byte(i * 7)
+	}
+	for i := range b {
+		b[i] = byte(i * 13)
+	}
+	return []string{"a.rar", "a.r00"}, map[string][]byte{"a.rar": a, "a.r00": b}
+}
+
+func verifyDir(t *testing.T, dir string) *par2Verification {
+	t.Helper()
+	files := findPar2Files(dir)
+	if len(files) == 0 || isPar2Volume(files[0]) {
+		t.Fatalf("expected the index file first, got %v", files)
+	}
+	set, err := loadPar2Set(files)
+	if err != nil {
+		t.Fatalf("loadPar2Set: %v", err)
+	}
+	v, err := verifyPar2(dir, set, nil)
+	if err != nil {
+		t.Fatalf("verifyPar2: %v", err)
+	}
+	return v
+}
+
+func TestVerifyPar2Intact(t *testing.T) {
+	dir := t.TempDir()
+	names, files := testFiles()
+	writeTestPar2Set(t, dir, names, files, 3)
+
+	v := verifyDir(t, dir)
+	if v.Damaged != 0 || v.Recovery != 3 {
+		t.Errorf("got %+v, want 0 damaged and 3 recovery", v)
+	}
+}
+
+func TestVerifyPar2Damaged(t *testing.T) {
+	dir := t.TempDir()
+	names, files := testFiles()
+	writeTestPar2Set(t, dir, names, files, 3)
+
+	// Zero out part of the second slice, as a missing segment would
+	path := filepath.Join(dir, "a.rar")
+	data, _ := os.ReadFile(path)
+	copy(data[testSliceSize+10:], make([]byte, 50))
+	os.WriteFile(path, data, 0644)
+
+	// Truncated final slice
+	path = filepath.Join(dir, "a.r00")
+	os.Truncate(path, testSliceSize+1)
+
+	if v := verifyDir(t, dir); v.Damaged != 2 {
+		t.Errorf("damaged = %d, want 2", v.Damaged)
+	}
+
+	os.Remove(path)
+	if v := verifyDir(t, dir); v.Damaged != 3 {
+		t.Errorf("damaged with a missing file = %d, want 3", v.Damaged)
+	}
+}
+
+func TestVerifyPar2RenamesObfuscatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	names, files := testFiles()
+	writeTestPar2Set(t, dir, names, files, 0)
+	os.Rename(filepath.Join(dir, "a.rar"), filepath.Join(dir, "8f3a0c1d"))
+
+	if v := verifyDir(t, dir); v.Damaged != 0 {
+		t.Errorf("damaged = %d, want 0", v.Damaged)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.rar")); err != nil {
+		t.Errorf("expected the file to be renamed back: %v", err)
+	}
+}
+
+func TestLoadPar2SetSkipsDamagedPackets(t *testing.T) {
+	dir := t.TempDir()
+	names, files := testFiles()
+	writeTestPar2Set(t, dir, names, files, 2)
+
+	// Wipe the middle of the index; the volume carries copies of every packet
+	path := filepath.Join(dir, "set.par2")
+	data, _ := os.ReadFile(path)
+	copy(data[len(data)/3:], make([]byte, len(data)/3))
+	os.WriteFile(path, data, 0644)
+
+	// And damage one recovery slice in the volume
+	vol := filepath.Join(dir, "set.vol0+3.par2")
+	data, _ = os.ReadFile(vol)
+	data[len(data)-10] ^= 0xff
+	os.WriteFile(vol, data, 0644)
+
+	v := verifyDir(t, dir)
+	if v.Damaged != 0 || v.Recovery != 1 {
+		t.Errorf("got %+v, want 0 damaged and 1 recovery", v)
+	}
+}
+
+func TestParsePar2Line(t *testing.T) {
+	tests := []struct {
+		line string
+		verb string
+		pct  float64
+		file string
+	}{
+		{`Scanning: "movie.part01.rar": 42.3%`, "Scanning", 42.3, "movie.part01.rar"},
+		{`Repairing: 17.0%`, "Repairing", 17, ""},
+		{`Loading: 100.0%`, "Loading", 100, ""},
+		{`Target: "movie.part01.rar" - found.`, "", -1, ""},
+		{`Repair is required.`, "", -1, ""},
+	}
+	for _, tt := range tests {
+		verb, pct, file := parsePar2Line(tt.line)
+		if verb != tt.verb || pct != tt.pct || file != tt.file {
+			t.Errorf("parsePar2Line(%q) = %q, %v, %q; want %q, %v, %q",
+				tt.line, verb, pct, file, tt.verb, tt.pct, tt.file)
+		}
+	}
+}
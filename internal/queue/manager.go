@@ -4,23 +4,51 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"nzb-connect/internal/events"
 )
 
-type extractProgress struct{ pct float64; file string }
+type extractProgress struct{ pct float64; file string; stage string }
 
 // Status values for downloads.
 const (
 	StatusQueued      = "queued"
+	StatusPaused      = "paused" // held in the queue by the user
 	StatusDownloading = "downloading"
 	StatusProcessing  = "processing"
 	StatusCompleted   = "completed"
 	StatusFailed      = "failed"
 )
 
+// Priorities, using SABnzbd's values.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+	PriorityForce  = 2 // starts even while the queue is paused
+)
+
+// File states for the files inside a download. Files without a recorded
+// state use the engine's default (FileHeld for par2 recovery volumes,
+// FileWanted for everything else).
+const (
+	FileWanted  = "wanted"
+	FileHeld    = "held" // par2 recovery volume, fetched only if repair needs it
+	FileSkipped = "skipped"
+)
+
+// Post-processing stages reported while a download is in StatusProcessing.
+const (
+	StageVerifying  = "verifying"
+	StageRepairing  = "repairing"
+	StageExtracting = "extracting"
+)
+
 // Download represents a download item.
 type Download struct {
 	ID              string
@@ -39,6 +67,10 @@ type Download struct {
 	Speed           float64 // bytes per second (live, not persisted)
 	ExtractPct      float64 // 0–100 during StatusProcessing (in-memory, not persisted)
 	ExtractFile     string  // basename currently being extracted (in-memory, not persisted)
+	ExtractStage    string  // Stage* value during StatusProcessing (in-memory, not persisted)
+	Priority        int     // Priority* value; higher runs first
+	Position        int     // order within the queue, after priority
+	Num             int64   // small numeric ID, for APIs that need one (NZBGet)
 }
 
 // Progress returns the download progress as a percentage.
@@ -53,11 +85,24 @@ func (d *Download) Progress() float64 {
 type Manager struct {
 	db           *sql.DB
 	mu           sync.RWMutex
-	paused       bool
+	paused       bool // VPN down
+	userPaused   bool // paused through the API
 	extractMu    sync.RWMutex
 	extractState map[string]extractProgress
+	warnMu       sync.Mutex
+	warnings     []Warning
+	events       *events.Bus
 }
 
+// Warning is a problem worth showing to the user, such as a failed job.
+type Warning struct {
+	Time time.Time
+	Text string
+}
+
+// maxWarnings is how many warnings are kept; older ones are dropped.
+const maxWarnings = 100
+
 // NewManager creates a new queue manager with a SQLite database.
 func NewManager(dbPath string) (*Manager, error) {
 	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
@@ -72,6 +117,17 @@ func NewManager(dbPath string) (*Manager, error) {
 	return m, nil
 }
 
+// SetEvents sets the bus queue changes are published to.
+func (m *Manager) SetEvents(bus *events.Bus) {
+	m.events = bus
+}
+
+// changed publishes a queue event for a download. status is its new status,
+// "deleted" if it was removed, or "" if something else about it changed.
+func (m *Manager) changed(id, status string) {
+	m.events.Publish(events.Queue, map[string]string{"id": id, "status": status})
+}
+
 func (m *Manager) initDB() error {
 	_, err := m.db.Exec(`
 		CREATE TABLE IF NOT EXISTS downloads (
@@ -90,22 +146,69 @@ func (m *Manager) initDB() error {
 			completed_at DATETIME
 		);
 		CREATE INDEX IF NOT EXISTS idx_downloads_status ON downloads(status);
+		CREATE TABLE IF NOT EXISTS download_files (
+			download_id TEXT NOT NULL,
+			file_index INTEGER NOT NULL,
+			segments BLOB,
+			PRIMARY KEY (download_id, file_index)
+		);
+		CREATE TABLE IF NOT EXISTS download_file_states (
+			download_id TEXT NOT NULL,
+			file_index INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			PRIMARY KEY (download_id, file_index)
+		);
+		CREATE TABLE IF NOT EXISTS download_params (
+			download_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (download_id, name)
+		);
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the first release
+	for _, col := range []string{
+		"priority INTEGER NOT NULL DEFAULT 0",
+		"position INTEGER NOT NULL DEFAULT 0",
+		"num INTEGER NOT NULL DEFAULT 0",
+	} {
+		if err := m.addColumn("downloads", col); err != nil {
+			return err
+		}
+	}
+	_, err = m.db.Exec(`UPDATE downloads SET num = rowid WHERE num = 0`)
 	return err
 }
 
-// Add adds a new download to the queue.
+// addColumn adds a column to an existing table unless it is already there.
+func (m *Manager) addColumn(table, def string) error {
+	_, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, def))
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+	return err
+}
+
+// Add adds a new download to the end of the queue.
 func (m *Manager) Add(dl *Download) error {
 	_, err := m.db.Exec(`
-		INSERT INTO downloads (id, name, category, status, total_bytes, total_segments, nzb_data, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT INTO downloads (id, name, category, status, total_bytes, total_segments, nzb_data, created_at,
+			priority, position, num)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?,
+			(SELECT COALESCE(MAX(position), 0) + 1 FROM downloads),
+			(SELECT COALESCE(MAX(num), 0) + 1 FROM downloads))`,
 		dl.ID, dl.Name, dl.Category, StatusQueued,
 		dl.TotalBytes, dl.TotalSegments, dl.NZBData, time.Now(),
+		dl.Priority,
 	)
 	if err != nil {
 		return fmt.Errorf("inserting download: %w", err)
 	}
 	log.Printf("Added download: %s (%s)", dl.Name, dl.ID)
+	m.changed(dl.ID, StatusQueued)
 	return nil
 }
 
@@ -116,13 +219,13 @@ func (m *Manager) Get(id string) (*Download, error) {
 	err := m.db.QueryRow(`
 		SELECT id, name, category, status, total_bytes, downloaded_bytes,
 			   total_segments, done_segments, path, nzb_data, error_msg,
-			   created_at, completed_at
+			   created_at, completed_at, priority, position, num
 		FROM downloads WHERE id = ?`, id).Scan(
 		&dl.ID, &dl.Name, &dl.Category, &dl.Status,
 		&dl.TotalBytes, &dl.DownloadedBytes,
 		&dl.TotalSegments, &dl.DoneSegments,
 		&dl.Path, &dl.NZBData, &dl.ErrorMsg,
-		&dl.CreatedAt, &completedAt,
+		&dl.CreatedAt, &completedAt, &dl.Priority, &dl.Position, &dl.Num,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying download %s: %w", id, err)
@@ -141,15 +244,31 @@ func (m *Manager) UpdateProgress(id string, downloadedBytes int64, doneSegments
 	return err
 }
 
+// UpdateTotals updates the size of a download, e.g. when files are held back
+// or skipped.
+func (m *Manager) UpdateTotals(id string, totalBytes int64, totalSegments int) error {
+	_, err := m.db.Exec(`
+		UPDATE downloads SET total_bytes = ?, total_segments = ?
+		WHERE id = ?`, totalBytes, totalSegments, id)
+	return err
+}
+
 // UpdateStatus updates the status of a download.
 func (m *Manager) UpdateStatus(id, status string) error {
 	if status == StatusCompleted || status == StatusFailed {
 		_, err := m.db.Exec(`
 			UPDATE downloads SET status = ?, completed_at = ?
 			WHERE id = ?`, status, time.Now(), id)
+		if err == nil {
+			m.changed(id, status)
+			err = m.clearFiles(id)
+		}
 		return err
 	}
 	_, err := m.db.Exec(`UPDATE downloads SET status = ? WHERE id = ?`, status, id)
+	if err == nil {
+		m.changed(id, status)
+	}
 	return err
 }
 
@@ -159,19 +278,324 @@ func (m *Manager) UpdatePath(id, path string) error {
 	return err
 }
 
-// SetError marks a download as failed with an error message.
+// SetError marks a download as failed with an error message and records it
+// as a warning.
 func (m *Manager) SetError(id, errMsg string) error {
+	name := id
+	m.db.QueryRow(`SELECT name FROM downloads WHERE id = ?`, id).Scan(&name)
+	m.Warn(fmt.Sprintf("%s: %s", name, errMsg))
+	return m.fail(id, errMsg)
+}
+
+// Cancel marks a download as failed because the user cancelled it. Unlike
+// SetError it does not record a warning.
+func (m *Manager) Cancel(id string) error {
+	return m.fail(id, "cancelled by user")
+}
+
+func (m *Manager) fail(id, errMsg string) error {
 	_, err := m.db.Exec(`
 		UPDATE downloads SET status = ?, error_msg = ?, completed_at = ?
 		WHERE id = ?`, StatusFailed, errMsg, time.Now(), id)
+	if err == nil {
+		m.changed(id, StatusFailed)
+		err = m.clearFiles(id)
+	}
 	return err
 }
 
+// SaveFileProgress records which segments of one file in a download have
+// been written to disk, as an opaque bitmap owned by the download engine.
+func (m *Manager) SaveFileProgress(id string, fileIndex int, segments []byte) error {
+	_, err := m.db.Exec(`
+		INSERT INTO download_files (download_id, file_index, segments)
+		VALUES (?, ?, ?)
+		ON CONFLICT (download_id, file_index) DO UPDATE SET segments = excluded.segments`,
+		id, fileIndex, segments)
+	return err
+}
+
+// GetFileProgress returns the saved segment bitmaps for a download, keyed by
+// file index.
+func (m *Manager) GetFileProgress(id string) (map[int][]byte, error) {
+	rows, err := m.db.Query(`
+		SELECT file_index, segments FROM download_files WHERE download_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("querying file progress: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int][]byte)
+	for rows.Next() {
+		var idx int
+		var segments []byte
+		if err := rows.Scan(&idx, &segments); err != nil {
+			return nil, fmt.Errorf("scanning file progress row: %w", err)
+		}
+		result[idx] = segments
+	}
+	return result, rows.Err()
+}
+
+// ClearFileProgress removes all saved segment bitmaps for a download.
+func (m *Manager) ClearFileProgress(id string) error {
+	_, err := m.db.Exec(`DELETE FROM download_files WHERE download_id = ?`, id)
+	return err
+}
+
+// SetFileState records the state (FileWanted, FileHeld, FileSkipped) of one
+// file in a download.
+func (m *Manager) SetFileState(id string, fileIndex int, state string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO download_file_states (download_id, file_index, state)
+		VALUES (?, ?, ?)
+		ON CONFLICT (download_id, file_index) DO UPDATE SET state = excluded.state`,
+		id, fileIndex, state)
+	return err
+}
+
+// GetFileStates returns the recorded file states for a download, keyed by
+// file index.
+func (m *Manager) GetFileStates(id string) (map[int]string, error) {
+	rows, err := m.db.Query(`
+		SELECT file_index, state FROM download_file_states WHERE download_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("querying file states: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]string)
+	for rows.Next() {
+		var idx int
+		var state string
+		if err := rows.Scan(&idx, &state); err != nil {
+			return nil, fmt.Errorf("scanning file state row: %w", err)
+		}
+		result[idx] = state
+	}
+	return result, rows.Err()
+}
+
+// clearFiles drops per-file bookkeeping once a download has finished; the
+// files stay available for a post-processing round trip until then.
+func (m *Manager) clearFiles(id string) error {
+	if err := m.ClearFileProgress(id); err != nil {
+		return err
+	}
+	_, err := m.db.Exec(`DELETE FROM download_file_states WHERE download_id = ?`, id)
+	return err
+}
+
+// Claim moves a queued download to StatusDownloading. It reports false if the
+// download is no longer queued, e.g. because it was paused or deleted.
+func (m *Manager) Claim(id string) (bool, error) {
+	res, err := m.db.Exec(`UPDATE downloads SET status = ? WHERE id = ? AND status = ?`,
+		StatusDownloading, id, StatusQueued)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		m.changed(id, StatusDownloading)
+	}
+	return n > 0, nil
+}
+
+// Pause holds a queued or downloading download in the queue until Resume.
+// The caller stops a running download.
+func (m *Manager) Pause(id string) error {
+	return m.transition(id, StatusPaused, StatusQueued, StatusDownloading)
+}
+
+// Resume requeues a paused download.
+func (m *Manager) Resume(id string) error {
+	return m.transition(id, StatusQueued, StatusPaused)
+}
+
+// SetCategory changes the category of a download that hasn't reached
+// post-processing yet.
+func (m *Manager) SetCategory(id, category string) error {
+	res, err := m.db.Exec(`UPDATE downloads SET category = ? WHERE id = ? AND status IN (?, ?, ?)`,
+		category, id, StatusQueued, StatusPaused, StatusDownloading)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("download %s not found or already post-processing", id)
+	}
+	m.changed(id, "")
+	return nil
+}
+
+// Categories returns the distinct categories of all downloads.
+func (m *Manager) Categories() ([]string, error) {
+	rows, err := m.db.Query(`SELECT DISTINCT category FROM downloads WHERE category != '' ORDER BY category`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cats []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		cats = append(cats, c)
+	}
+	return cats, rows.Err()
+}
+
+// Retry requeues a failed download from scratch.
+func (m *Manager) Retry(id string) error {
+	res, err := m.db.Exec(`
+		UPDATE downloads SET status = ?, error_msg = '', completed_at = NULL,
+			downloaded_bytes = 0, done_segments = 0,
+			position = (SELECT COALESCE(MAX(position), 0) + 1 FROM downloads)
+		WHERE id = ? AND status = ?`, StatusQueued, id, StatusFailed)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("download %s not found or not failed", id)
+	}
+	m.changed(id, StatusQueued)
+	return nil
+}
+
+// IDForNum returns the ID of the download with the given Num.
+func (m *Manager) IDForNum(num int64) (string, error) {
+	var id string
+	err := m.db.QueryRow(`SELECT id FROM downloads WHERE num = ?`, num).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("download #%d not found", num)
+	}
+	return id, err
+}
+
+// SetParams stores name/value parameters sent by a client along with a
+// download, such as the tag Sonarr uses to recognise its downloads.
+func (m *Manager) SetParams(id string, params map[string]string) error {
+	for name, value := range params {
+		_, err := m.db.Exec(`
+			INSERT INTO download_params (download_id, name, value) VALUES (?, ?, ?)
+			ON CONFLICT (download_id, name) DO UPDATE SET value = excluded.value`,
+			id, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetParams returns the parameters stored with SetParams.
+func (m *Manager) GetParams(id string) (map[string]string, error) {
+	rows, err := m.db.Query(`SELECT name, value FROM download_params WHERE download_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	params := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		params[name] = value
+	}
+	return params, rows.Err()
+}
+
+// Delete removes a download and its bookkeeping from the database.
+func (m *Manager) Delete(id string) error {
+	if err := m.clearFiles(id); err != nil {
+		return err
+	}
+	if _, err := m.db.Exec(`DELETE FROM download_params WHERE download_id = ?`, id); err != nil {
+		return err
+	}
+	m.ClearExtractProgress(id)
+	res, err := m.db.Exec(`DELETE FROM downloads WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("download %s not found", id)
+	}
+	m.changed(id, "deleted")
+	return nil
+}
+
+// transition moves a download to status if it is in one of from.
+func (m *Manager) transition(id, status string, from ...string) error {
+	args := []interface{}{status, id}
+	for _, f := range from {
+		args = append(args, f)
+	}
+	res, err := m.db.Exec(`UPDATE downloads SET status = ? WHERE id = ? AND status IN (?`+
+		strings.Repeat(", ?", len(from)-1)+`)`, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("download %s not found or not %s", id, strings.Join(from, "/"))
+	}
+	m.changed(id, status)
+	return nil
+}
+
+// RecoverInterrupted repairs rows left behind by a process that died mid-job.
+// Downloads stuck in StatusDownloading are requeued so the engine resumes
+// them; downloads stuck in StatusProcessing are returned so the caller can
+// run post-processing again.
+func (m *Manager) RecoverInterrupted() ([]*Download, error) {
+	res, err := m.db.Exec(`UPDATE downloads SET status = ? WHERE status = ?`,
+		StatusQueued, StatusDownloading)
+	if err != nil {
+		return nil, fmt.Errorf("requeueing interrupted downloads: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d interrupted download(s)", n)
+	}
+
+	rows, err := m.db.Query(`SELECT id FROM downloads WHERE status = ?`, StatusProcessing)
+	if err != nil {
+		return nil, fmt.Errorf("querying interrupted post-processing: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning interrupted row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	var result []*Download
+	for _, id := range ids {
+		dl, err := m.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dl)
+	}
+	return result, nil
+}
+
 // SetExtractProgress updates the in-memory extraction progress for a download.
 func (m *Manager) SetExtractProgress(id string, pct float64, file string) {
 	m.extractMu.Lock()
 	defer m.extractMu.Unlock()
-	m.extractState[id] = extractProgress{pct: pct, file: file}
+	m.extractState[id] = extractProgress{pct: pct, file: file, stage: m.extractState[id].stage}
+}
+
+// SetProcessingStage records which post-processing stage a download is in and
+// resets its progress for that stage.
+func (m *Manager) SetProcessingStage(id, stage string) {
+	m.extractMu.Lock()
+	defer m.extractMu.Unlock()
+	m.extractState[id] = extractProgress{stage: stage}
 }
 
 // ClearExtractProgress removes the extraction progress entry for a download.
@@ -181,22 +605,23 @@ func (m *Manager) ClearExtractProgress(id string) {
 	delete(m.extractState, id)
 }
 
-func (m *Manager) getExtractProgress(id string) (float64, string, bool) {
+func (m *Manager) getExtractProgress(id string) (extractProgress, bool) {
 	m.extractMu.RLock()
 	defer m.extractMu.RUnlock()
 	ep, ok := m.extractState[id]
-	return ep.pct, ep.file, ok
+	return ep, ok
 }
 
 // GetQueue returns all active (non-completed) downloads.
 func (m *Manager) GetQueue() ([]*Download, error) {
 	rows, err := m.db.Query(`
 		SELECT id, name, category, status, total_bytes, downloaded_bytes,
-			   total_segments, done_segments, path, error_msg, created_at
+			   total_segments, done_segments, path, error_msg, created_at,
+			   priority, position, num
 		FROM downloads
-		WHERE status IN (?, ?, ?)
-		ORDER BY created_at ASC`,
-		StatusQueued, StatusDownloading, StatusProcessing,
+		WHERE status IN (?, ?, ?, ?)
+		ORDER BY `+queueOrder,
+		StatusQueued, StatusPaused, StatusDownloading, StatusProcessing,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying queue: %w", err)
@@ -211,6 +636,7 @@ func (m *Manager) GetQueue() ([]*Download, error) {
 			&dl.TotalBytes, &dl.DownloadedBytes,
 			&dl.TotalSegments, &dl.DoneSegments,
 			&dl.Path, &dl.ErrorMsg, &dl.CreatedAt,
+			&dl.Priority, &dl.Position, &dl.Num,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scanning queue row: %w", err)
@@ -218,9 +644,10 @@ func (m *Manager) GetQueue() ([]*Download, error) {
 		result = append(result, dl)
 	}
 	for _, dl := range result {
-		if pct, file, ok := m.getExtractProgress(dl.ID); ok {
-			dl.ExtractPct = pct
-			dl.ExtractFile = file
+		if ep, ok := m.getExtractProgress(dl.ID); ok {
+			dl.ExtractPct = ep.pct
+			dl.ExtractFile = ep.file
+			dl.ExtractStage = ep.stage
 		}
 	}
 	return result, nil
@@ -231,7 +658,7 @@ func (m *Manager) GetHistory() ([]*Download, error) {
 	rows, err := m.db.Query(`
 		SELECT id, name, category, status, total_bytes, downloaded_bytes,
 			   total_segments, done_segments, path, error_msg,
-			   created_at, completed_at
+			   created_at, completed_at, num
 		FROM downloads
 		WHERE status IN (?, ?)
 		ORDER BY completed_at DESC`,
@@ -251,7 +678,7 @@ func (m *Manager) GetHistory() ([]*Download, error) {
 			&dl.TotalBytes, &dl.DownloadedBytes,
 			&dl.TotalSegments, &dl.DoneSegments,
 			&dl.Path, &dl.ErrorMsg,
-			&dl.CreatedAt, &completedAt,
+			&dl.CreatedAt, &completedAt, &dl.Num,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scanning history row: %w", err)
@@ -264,20 +691,131 @@ func (m *Manager) GetHistory() ([]*Download, error) {
 	return result, nil
 }
 
-// GetNextQueued returns the next queued download (FIFO).
-func (m *Manager) GetNextQueued() (*Download, error) {
+// queueOrder is the ORDER BY clause for the queue: priority first, then the
+// position set by the user (new downloads go to the end).
+const queueOrder = "priority DESC, position ASC, created_at ASC"
+
+// SetPriority changes a download's priority and returns its new index in
+// the queue.
+func (m *Manager) SetPriority(id string, priority int) (int, error) {
+	if priority < PriorityLow || priority > PriorityForce {
+		return 0, fmt.Errorf("invalid priority %d", priority)
+	}
+	res, err := m.db.Exec(`UPDATE downloads SET priority = ? WHERE id = ?`, priority, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("download %s not found", id)
+	}
+	m.changed(id, "")
+	return m.queueIndex(id)
+}
+
+// Move puts a download at index in the queue (as returned by GetQueue). It
+// takes the priority of the download it lands in front of (or behind, at the
+// end) so that the order sticks. It returns the download's priority.
+func (m *Manager) Move(id string, index int) (int, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, priority FROM downloads
+		WHERE status IN (?, ?, ?, ?)
+		ORDER BY `+queueOrder, StatusQueued, StatusPaused, StatusDownloading, StatusProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("querying queue: %w", err)
+	}
+	type item struct {
+		id       string
+		priority int
+	}
+	var items []item
+	found := false
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.priority); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if it.id == id {
+			found = true
+			continue
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if !found {
+		return 0, fmt.Errorf("download %s not in queue", id)
+	}
+
+	if index < 0 {
+		index = 0
+	}
+	if index > len(items) {
+		index = len(items)
+	}
+	priority := PriorityNormal
+	switch {
+	case index < len(items):
+		priority = items[index].priority
+	case len(items) > 0:
+		priority = items[len(items)-1].priority
+	}
+	items = append(items[:index], append([]item{{id, priority}}, items[index:]...)...)
+
+	for pos, it := range items {
+		if _, err := tx.Exec(`UPDATE downloads SET position = ?, priority = ? WHERE id = ?`,
+			pos, it.priority, it.id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	m.changed(id, "")
+	return priority, nil
+}
+
+// queueIndex returns the index of a download in queue order.
+func (m *Manager) queueIndex(id string) (int, error) {
+	queue, err := m.GetQueue()
+	if err != nil {
+		return 0, err
+	}
+	for i, dl := range queue {
+		if dl.ID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("download %s not in queue", id)
+}
+
+// GetNextQueued returns the next queued download by priority and position.
+// With forceOnly set (the queue is paused) only PriorityForce downloads are
+// returned.
+func (m *Manager) GetNextQueued(forceOnly bool) (*Download, error) {
+	minPriority := PriorityLow
+	if forceOnly {
+		minPriority = PriorityForce
+	}
 	dl := &Download{}
 	err := m.db.QueryRow(`
 		SELECT id, name, category, status, total_bytes, downloaded_bytes,
-			   total_segments, done_segments, path, nzb_data, error_msg, created_at
+			   total_segments, done_segments, path, nzb_data, error_msg, created_at,
+			   priority, position, num
 		FROM downloads
-		WHERE status = ?
-		ORDER BY created_at ASC
-		LIMIT 1`, StatusQueued).Scan(
+		WHERE status = ? AND priority >= ?
+		ORDER BY `+queueOrder+`
+		LIMIT 1`, StatusQueued, minPriority).Scan(
 		&dl.ID, &dl.Name, &dl.Category, &dl.Status,
 		&dl.TotalBytes, &dl.DownloadedBytes,
 		&dl.TotalSegments, &dl.DoneSegments,
 		&dl.Path, &dl.NZBData, &dl.ErrorMsg, &dl.CreatedAt,
+		&dl.Priority, &dl.Position, &dl.Num,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -288,11 +826,50 @@ func (m *Manager) GetNextQueued() (*Download, error) {
 	return dl, nil
 }
 
-// IsPaused returns whether the queue is paused.
+// IsPaused returns whether the queue is paused, by the VPN or the user.
 func (m *Manager) IsPaused() bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.paused
+	return m.paused || m.userPaused
+}
+
+// SetUserPaused pauses or resumes the queue on the user's behalf. It is
+// tracked apart from the VPN pause so that the VPN coming back up does not
+// resume a queue the user paused.
+func (m *Manager) SetUserPaused(paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.userPaused = paused
+	m.events.Publish(events.Status, map[string]bool{"paused": m.paused || m.userPaused})
+	if paused {
+		log.Println("Download queue paused by user")
+	} else {
+		log.Println("Download queue resumed by user")
+	}
+}
+
+// Warn records a warning.
+func (m *Manager) Warn(text string) {
+	m.warnMu.Lock()
+	defer m.warnMu.Unlock()
+	if len(m.warnings) == maxWarnings {
+		m.warnings = m.warnings[1:]
+	}
+	m.warnings = append(m.warnings, Warning{Time: time.Now(), Text: text})
+}
+
+// Warnings returns the recorded warnings, oldest first.
+func (m *Manager) Warnings() []Warning {
+	m.warnMu.Lock()
+	defer m.warnMu.Unlock()
+	return append([]Warning(nil), m.warnings...)
+}
+
+// ClearWarnings drops all recorded warnings.
+func (m *Manager) ClearWarnings() {
+	m.warnMu.Lock()
+	defer m.warnMu.Unlock()
+	m.warnings = nil
 }
 
 // SetPaused sets the paused state.
@@ -300,6 +877,7 @@ func (m *Manager) SetPaused(paused bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.paused = paused
+	m.events.Publish(events.Status, map[string]bool{"paused": m.paused || m.userPaused})
 	if paused {
 		log.Println("Download queue PAUSED")
 	} else {
@@ -0,0 +1,279 @@
+package queue
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// add queues downloads with the given IDs and priorities, in that order.
+func add(t *testing.T, m *Manager, ids []string, priorities []int) {
+	t.Helper()
+	for i, id := range ids {
+		if err := m.Add(&Download{ID: id, Name: id, Priority: priorities[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func queueIDs(t *testing.T, m *Manager) []string {
+	t.Helper()
+	q, err := m.GetQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, dl := range q {
+		ids = append(ids, dl.ID)
+	}
+	return ids
+}
+
+func TestQueueOrder(t *testing.T) {
+	m := newTestManager(t)
+	add(t, m, []string{"a", "b", "c", "d", "e"},
+		[]int{PriorityNormal, PriorityLow, PriorityHigh, PriorityNormal, PriorityForce})
+
+	// Priority first, then the order they were added in
+	want := []string{"e", "c", "a", "d", "b"}
+	if got := queueIDs(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+
+	next, err := m.GetNextQueued(false)
+	if err != nil || next == nil || next.ID != "e" {
+		t.Fatalf("GetNextQueued = %v, %v; want e", next, err)
+	}
+
+	index, err := m.SetPriority("b", PriorityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b was added before c, so it goes first among the high ones
+	if index != 1 {
+		t.Errorf("SetPriority index = %d, want 1", index)
+	}
+	want = []string{"e", "b", "c", "a", "d"}
+	if got := queueIDs(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue after SetPriority = %v, want %v", got, want)
+	}
+
+	if _, err := m.SetPriority("b", 3); err == nil {
+		t.Error("expected an error for an out-of-range priority")
+	}
+	if _, err := m.SetPriority("missing", PriorityHigh); err == nil {
+		t.Error("expected an error for an unknown download")
+	}
+}
+
+func TestGetNextQueuedForceOnly(t *testing.T) {
+	m := newTestManager(t)
+	add(t, m, []string{"high", "force", "normal"}, []int{PriorityHigh, PriorityForce, PriorityNormal})
+
+	next, err := m.GetNextQueued(true)
+	if err != nil || next == nil || next.ID != "force" {
+		t.Fatalf("GetNextQueued(true) = %v, %v; want force", next, err)
+	}
+	if ok, err := m.Claim("force"); err != nil || !ok {
+		t.Fatalf("Claim: %v, %v", ok, err)
+	}
+
+	// Only forced downloads start while the queue is paused
+	if next, err := m.GetNextQueued(true); err != nil || next != nil {
+		t.Errorf("GetNextQueued(true) = %v, %v; want none", next, err)
+	}
+	if next, err := m.GetNextQueued(false); err != nil || next == nil || next.ID != "high" {
+		t.Errorf("GetNextQueued(false) = %v, %v; want high", next, err)
+	}
+
+	// Paused downloads are skipped whatever their priority
+	if err := m.Pause("high"); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := m.GetNextQueued(false); err != nil || next == nil || next.ID != "normal" {
+		t.Errorf("GetNextQueued(false) with high paused = %v, %v; want normal", next, err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	m := newTestManager(t)
+	add(t, m, []string{"a", "b", "c", "d"}, []int{PriorityHigh, PriorityNormal, PriorityNormal, PriorityLow})
+
+	tests := []struct {
+		id       string
+		index    int
+		want     []string
+		priority int
+	}{
+		// Landing among normal downloads makes it normal
+		{"a", 1, []string{"b", "a", "c", "d"}, PriorityNormal},
+		// Out-of-range indexes are clamped to the ends
+		{"d", -5, []string{"d", "b", "a", "c"}, PriorityNormal},
+		{"b", 99, []string{"d", "a", "c", "b"}, PriorityNormal},
+		{"c", 3, []string{"d", "a", "b", "c"}, PriorityNormal},
+	}
+	for _, tt := range tests {
+		priority, err := m.Move(tt.id, tt.index)
+		if err != nil {
+			t.Fatalf("Move(%s, %d): %v", tt.id, tt.index, err)
+		}
+		if priority != tt.priority {
+			t.Errorf("Move(%s, %d) priority = %d, want %d", tt.id, tt.index, priority, tt.priority)
+		}
+		if got := queueIDs(t, m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Move(%s, %d): queue = %v, want %v", tt.id, tt.index, got, tt.want)
+		}
+	}
+
+	// The order sticks for downloads added afterwards
+	add(t, m, []string{"e"}, []int{PriorityNormal})
+	want := []string{"d", "a", "b", "c", "e"}
+	if got := queueIDs(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue after Add = %v, want %v", got, want)
+	}
+
+	if _, err := m.Move("missing", 0); err == nil {
+		t.Error("expected an error for an unknown download")
+	}
+}
+
+func TestMigratePriorityColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+
+	// The schema before priorities, positions and numbers were added
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE downloads (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			category TEXT DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'queued',
+			total_bytes INTEGER DEFAULT 0,
+			downloaded_bytes INTEGER DEFAULT 0,
+			total_segments INTEGER DEFAULT 0,
+			done_segments INTEGER DEFAULT 0,
+			path TEXT DEFAULT '',
+			nzb_data BLOB,
+			error_msg TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			completed_at DATETIME
+		);
+		INSERT INTO downloads (id, name, created_at) VALUES ('old1', 'old1', '2024-01-01 00:00:00');
+		INSERT INTO downloads (id, name, created_at) VALUES ('old2', 'old2', '2024-01-02 00:00:00');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ { // migrating twice must be harmless
+		m, err := NewManager(path)
+		if err != nil {
+			t.Fatalf("NewManager (run %d): %v", i, err)
+		}
+		q, err := m.GetQueue()
+		m.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(q) != 2 || q[0].ID != "old1" || q[1].ID != "old2" {
+			t.Fatalf("queue = %+v, want old1, old2", q)
+		}
+		for j, dl := range q {
+			if dl.Priority != PriorityNormal || dl.Num != int64(j+1) {
+				t.Errorf("%s: priority %d, num %d; want %d, %d", dl.ID, dl.Priority, dl.Num, PriorityNormal, j+1)
+			}
+		}
+	}
+
+	m, err := NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Add(&Download{ID: "new", Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := m.Get("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Num != 3 {
+		t.Errorf("new download num = %d, want 3", dl.Num)
+	}
+	want := []string{"old1", "old2", "new"}
+	if got := queueIDs(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	m := newTestManager(t)
+	add(t, m, []string{"dl", "pp", "queued", "done"}, []int{0, 0, 0, 0})
+	for id, status := range map[string]string{"dl": StatusDownloading, "pp": StatusProcessing, "done": StatusCompleted} {
+		if err := m.UpdateStatus(id, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.UpdateProgress("dl", 4096, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveFileProgress("dl", 0, []byte{0x07}); err != nil {
+		t.Fatal(err)
+	}
+
+	processing, err := m.RecoverInterrupted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processing) != 1 || processing[0].ID != "pp" || processing[0].Status != StatusProcessing {
+		t.Fatalf("RecoverInterrupted returned %+v, want pp still processing", processing)
+	}
+
+	dl, err := m.Get("dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Status != StatusQueued {
+		t.Errorf("interrupted download status = %q, want %q", dl.Status, StatusQueued)
+	}
+	// Progress is kept so the engine resumes rather than starts over
+	if dl.DownloadedBytes != 4096 || dl.DoneSegments != 3 {
+		t.Errorf("progress = %d bytes, %d segments; want 4096, 3", dl.DownloadedBytes, dl.DoneSegments)
+	}
+	files, err := m.GetFileProgress("dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, map[int][]byte{0: {0x07}}) {
+		t.Errorf("file progress = %v, want segment bitmap kept", files)
+	}
+
+	for id, want := range map[string]string{"queued": StatusQueued, "done": StatusCompleted} {
+		dl, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dl.Status != want {
+			t.Errorf("%s status = %q, want %q", id, dl.Status, want)
+		}
+	}
+
+	// Downloads stay in processing until post-processing moves them on
+	if processing, err := m.RecoverInterrupted(); err != nil || len(processing) != 1 {
+		t.Errorf("second RecoverInterrupted = %+v, %v; want pp only", processing, err)
+	}
+}
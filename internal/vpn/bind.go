@@ -10,13 +10,30 @@ import (
 	"time"
 )
 
-// Monitor watches a network interface and reports its status.
+const (
+	// How often the interface is polled without netlink notifications, and
+	// with them in case one is missed
+	pollInterval        = 2 * time.Second
+	netlinkPollInterval = 30 * time.Second
+
+	// How long a change must last before it is acted on
+	defaultLinkDebounce = 750 * time.Millisecond
+)
+
+// Monitor watches a network interface and reports its status. Changes are
+// picked up from rtnetlink notifications as they happen, falling back to
+// polling, and only acted on once they have lasted for the debounce period,
+// so a brief flap doesn't tear down every pool.
 type Monitor struct {
 	interfaceName string
+	check         func() bool    // reports whether the tunnel is up; nil = the interface's flags
+	health        *healthChecker // active probes; nil = the interface's state alone
+	debounce      time.Duration
 	mu            sync.RWMutex
 	isUp          bool
 	onDown        func()
 	onUp          func()
+	wake          chan struct{} // something may have changed
 	stopCh        chan struct{}
 	stopOnce      sync.Once
 }
@@ -25,10 +42,20 @@ type Monitor struct {
 func NewMonitor(interfaceName string) *Monitor {
 	return &Monitor{
 		interfaceName: interfaceName,
+		debounce:      defaultLinkDebounce,
+		wake:          make(chan struct{}, 1),
 		stopCh:        make(chan struct{}),
 	}
 }
 
+// newTunnelMonitor creates a monitor for a tunnel with no host interface,
+// reporting it up while check does.
+func newTunnelMonitor(name string, check func() bool) *Monitor {
+	m := NewMonitor(name)
+	m.check = check
+	return m
+}
+
 // OnDown sets a callback for when the interface goes down.
 func (m *Monitor) OnDown(fn func()) {
 	m.onDown = fn
@@ -62,18 +89,45 @@ func (m *Monitor) SetInterface(name string) {
 	m.checkInterface()
 }
 
-// Start begins monitoring the interface. It checks every 2 seconds.
+// Start begins monitoring the interface, and starts the health probes if
+// there are any. A host interface is watched through rtnetlink, and polled
+// every 2 seconds if that isn't available; a tunnel without one is polled.
 func (m *Monitor) Start() {
 	// Do initial check
 	m.checkInterface()
+	if m.health != nil {
+		m.health.start(m.linkUp, m.poke)
+	}
+
+	poll := pollInterval
+	var netlinkErr <-chan error
+	if m.check == nil {
+		errc, err := watchLinks(m.stopCh, func(msg syscall.NetlinkMessage) {
+			if linkMessageAbout(msg, m.InterfaceName()) {
+				m.poke()
+			}
+		})
+		if err != nil {
+			log.Printf("Watching %s by polling: %v", m.InterfaceName(), err)
+		} else {
+			netlinkErr = errc
+			poll = netlinkPollInterval
+		}
+	}
 
 	go func() {
-		ticker := time.NewTicker(2 * time.Second)
+		ticker := time.NewTicker(poll)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				m.checkInterface()
+				m.settle()
+			case <-m.wake:
+				m.settle()
+			case err := <-netlinkErr:
+				log.Printf("Watching %s by polling: %v", m.InterfaceName(), err)
+				netlinkErr = nil
+				ticker.Reset(pollInterval)
 			case <-m.stopCh:
 				return
 			}
@@ -81,20 +135,80 @@ func (m *Monitor) Start() {
 	}()
 }
 
+// poke has the monitor look at the interface now.
+func (m *Monitor) poke() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// settle acts on a change once it has lasted for the debounce period. If
+// the interface is back as it was by then, nothing happens.
+func (m *Monitor) settle() {
+	if m.observe() == m.IsUp() {
+		return
+	}
+	timer := time.NewTimer(m.debounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-m.wake:
+			// More of the same flap
+		case <-timer.C:
+			m.checkInterface()
+			return
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
 // Stop stops the interface monitor. Safe to call multiple times.
 func (m *Monitor) Stop() {
 	m.stopOnce.Do(func() {
 		close(m.stopCh)
+		if m.health != nil {
+			m.health.stop()
+		}
 	})
 }
 
+// Health returns the latest health probe results, or nil without probes.
+func (m *Monitor) Health() []ProbeResult {
+	if m.health == nil {
+		return nil
+	}
+	return m.health.Results()
+}
+
+// linkUp reports whether the interface is up with an address, or the tunnel
+// for a monitor with no host interface is up, regardless of the health
+// probes.
+func (m *Monitor) linkUp() bool {
+	if m.check != nil {
+		return m.check()
+	}
+	iface, err := net.InterfaceByName(m.InterfaceName())
+	if err != nil || iface.Flags&net.FlagUp == 0 {
+		return false
+	}
+	addrs, err := iface.Addrs()
+	return err == nil && len(addrs) > 0
+}
+
+// observe reports whether the tunnel is usable: up, and passing the health
+// probes.
+func (m *Monitor) observe() bool {
+	return m.linkUp() && (m.health == nil || m.health.healthy())
+}
+
 func (m *Monitor) checkInterface() {
 	m.mu.RLock()
 	name := m.interfaceName
 	m.mu.RUnlock()
 
-	iface, err := net.InterfaceByName(name)
-	up := err == nil && iface.Flags&net.FlagUp != 0
+	up := m.observe()
 
 	m.mu.Lock()
 	wasUp := m.isUp
@@ -0,0 +1,328 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// Health probe types, as set in vpn.health.probes.
+const (
+	ProbeTCP       = "tcp"
+	ProbeHandshake = "handshake"
+	ProbeHTTP      = "http"
+)
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbeTimeout  = 10 * time.Second
+	defaultProbeFailures = 3
+	defaultHandshakeAge  = 180 * time.Second // WireGuard's Reject-After-Time
+)
+
+// ProbeResult is the outcome of a health probe's latest run.
+type ProbeResult struct {
+	Type      string     `json:"type"`
+	Target    string     `json:"target,omitempty"`
+	OK        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+	LatencyMS int64      `json:"latency_ms"`
+	Failures  int        `json:"failures"`             // in a row
+	CheckedAt *time.Time `json:"checked_at,omitempty"` // nil until the first run
+}
+
+// probe is a single health check; run returns nil when it passes.
+type probe struct {
+	typ    string
+	target string
+	run    func(ctx context.Context) error
+}
+
+// healthChecker runs probes through the tunnel on a timer. The tunnel counts
+// as unhealthy once any probe has failed failAfter times in a row, and as
+// healthy again when that probe next passes. A Monitor with a health checker
+// reports the tunnel down while it is unhealthy.
+type healthChecker struct {
+	probes    []probe
+	interval  time.Duration
+	timeout   time.Duration
+	failAfter int
+
+	mu      sync.RWMutex
+	results []ProbeResult
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newHealthChecker(probes []probe, interval, timeout time.Duration, failAfter int) *healthChecker {
+	h := &healthChecker{
+		probes:    probes,
+		interval:  interval,
+		timeout:   timeout,
+		failAfter: failAfter,
+		results:   make([]ProbeResult, len(probes)),
+		stopCh:    make(chan struct{}),
+	}
+	for i, p := range probes {
+		h.results[i] = ProbeResult{Type: p.typ, Target: p.target, OK: true}
+	}
+	return h
+}
+
+// start runs a round of probes now and every interval after, while linkUp
+// reports the interface up, calling done after each. While it is down there
+// is nothing to probe, and failures are forgotten so the tunnel isn't held
+// down once it returns.
+func (h *healthChecker) start(linkUp func() bool, done func()) {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			if linkUp() {
+				h.runOnce()
+				done()
+			} else {
+				h.reset()
+			}
+			select {
+			case <-ticker.C:
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop ends the probe loop. Safe to call multiple times.
+func (h *healthChecker) stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopCh)
+	})
+}
+
+// runOnce runs every probe in turn and records the results.
+func (h *healthChecker) runOnce() {
+	wasHealthy := h.healthy()
+	for i, p := range h.probes {
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		start := time.Now()
+		err := p.run(ctx)
+		cancel()
+
+		h.mu.Lock()
+		r := &h.results[i]
+		r.CheckedAt = &start
+		r.LatencyMS = time.Since(start).Milliseconds()
+		r.OK = err == nil
+		r.Error = ""
+		if err != nil {
+			r.Error = err.Error()
+			r.Failures++
+		} else {
+			r.Failures = 0
+		}
+		h.mu.Unlock()
+	}
+
+	if wasHealthy && !h.healthy() {
+		for _, r := range h.Results() {
+			if r.Failures >= h.failAfter {
+				log.Printf("VPN health check %s %s failed %d times in a row: %s", r.Type, r.Target, r.Failures, r.Error)
+			}
+		}
+	} else if !wasHealthy && h.healthy() {
+		log.Println("VPN health checks are passing again")
+	}
+}
+
+// reset forgets past failures.
+func (h *healthChecker) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.results {
+		h.results[i].Failures = 0
+	}
+}
+
+// healthy reports whether no probe has failed too often in a row.
+func (h *healthChecker) healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, r := range h.results {
+		if r.Failures >= h.failAfter {
+			return false
+		}
+	}
+	return true
+}
+
+// Results returns the latest result of each probe.
+func (h *healthChecker) Results() []ProbeResult {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]ProbeResult(nil), h.results...)
+}
+
+// handshaker is implemented by connectors that can report the WireGuard
+// peer's latest handshake without a host interface.
+type handshaker interface {
+	lastHandshake(ctx context.Context) (time.Time, error)
+}
+
+// newHealthChecker builds the probes in vpn.health for the tunnel watched by
+// mon, dialing through conn when it has no host interface. It returns nil
+// when no probe is configured.
+func (m *Manager) newHealthChecker(mon *Monitor, conn Connector) *healthChecker {
+	vpnCfg := m.cfg.GetVPN()
+	hc := vpnCfg.Health
+	if len(hc.Probes) == 0 {
+		return nil
+	}
+
+	dial := m.tunnelDialer(conn, mon.InterfaceName)
+	var probes []probe
+	for _, pc := range hc.Probes {
+		p, err := m.newProbe(pc, mon, conn, dial)
+		if err != nil {
+			log.Printf("WARNING: VPN health probe %s skipped: %v", pc.Type, err)
+			continue
+		}
+		probes = append(probes, p)
+	}
+	if len(probes) == 0 {
+		return nil
+	}
+
+	interval := time.Duration(hc.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	timeout := time.Duration(hc.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	failAfter := hc.Failures
+	if failAfter <= 0 {
+		failAfter = defaultProbeFailures
+	}
+	return newHealthChecker(probes, interval, timeout, failAfter)
+}
+
+// tunnelDialer returns a dial function that goes where the downloads do:
+// through conn if it has no host interface, else bound to the interface
+// iface returns, and with names looked up through the tunnel too when
+// vpn.tunnel_dns is on.
+func (m *Manager) tunnelDialer(conn Connector, iface func() string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return BindToInterface(iface()).DialContext(ctx, network, address)
+	}
+	if td, ok := conn.(TunnelDialer); ok {
+		dial = td.DialContext
+	}
+	if vpnCfg := m.cfg.GetVPN(); vpnCfg.TunnelDNS {
+		dial = newResolverWith(dial, m.tunnelDNSServers(vpnCfg)).DialContext
+	}
+	return dial
+}
+
+// newProbe creates the probe described by pc.
+func (m *Manager) newProbe(pc config.ProbeConfig, mon *Monitor, conn Connector, dial func(ctx context.Context, network, address string) (net.Conn, error)) (probe, error) {
+	switch pc.Type {
+	case ProbeTCP:
+		target := pc.Target
+		if target == "" {
+			for _, s := range m.cfg.GetServers() {
+				if s.Enabled {
+					target = net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+					break
+				}
+			}
+		}
+		if target == "" {
+			return probe{}, errors.New("no target and no enabled news server")
+		}
+		return probe{typ: pc.Type, target: target, run: func(ctx context.Context) error {
+			c, err := dial(ctx, "tcp", target)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		}}, nil
+
+	case ProbeHandshake:
+		var last func(ctx context.Context) (time.Time, error)
+		switch c := conn.(type) {
+		case handshaker:
+			last = c.lastHandshake
+		case nil, *WireGuardConnector:
+			// A kernel interface, ours or one managed elsewhere
+			last = func(ctx context.Context) (time.Time, error) {
+				return latestHandshake(ctx, mon.InterfaceName())
+			}
+		default:
+			return probe{}, errors.New("the tunnel isn't WireGuard")
+		}
+		maxAge := time.Duration(pc.MaxAge) * time.Second
+		if maxAge <= 0 {
+			maxAge = defaultHandshakeAge
+		}
+		return probe{typ: pc.Type, target: "max " + maxAge.String(), run: func(ctx context.Context) error {
+			return checkHandshake(ctx, last, maxAge)
+		}}, nil
+
+	case ProbeHTTP:
+		if pc.Target == "" {
+			return probe{}, errors.New("no URL in target")
+		}
+		client := NewHTTPClientWith(dial)
+		return probe{typ: pc.Type, target: pc.Target, run: func(ctx context.Context) error {
+			return checkHTTP(ctx, client, pc.Target)
+		}}, nil
+
+	default:
+		return probe{}, fmt.Errorf("unknown probe type %q", pc.Type)
+	}
+}
+
+// checkHandshake fails if the latest handshake is older than maxAge.
+func checkHandshake(ctx context.Context, last func(ctx context.Context) (time.Time, error), maxAge time.Duration) error {
+	ts, err := last(ctx)
+	if err != nil {
+		return err
+	}
+	if ts.IsZero() {
+		return errors.New("no handshake yet")
+	}
+	if age := time.Since(ts); age > maxAge {
+		return fmt.Errorf("last handshake %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// checkHTTP fails unless a GET of url succeeds with a status below 400.
+func checkHTTP(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
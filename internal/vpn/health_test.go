@@ -0,0 +1,147 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+func TestHealthChecker(t *testing.T) {
+	var fail bool
+	h := newHealthChecker([]probe{{typ: ProbeTCP, target: "news:563", run: func(ctx context.Context) error {
+		if fail {
+			return errors.New("connection timed out")
+		}
+		return nil
+	}}}, time.Minute, time.Second, 2)
+
+	h.runOnce()
+	if !h.healthy() || !h.Results()[0].OK || h.Results()[0].CheckedAt == nil {
+		t.Fatalf("after a pass: %+v", h.Results())
+	}
+
+	fail = true
+	h.runOnce()
+	if !h.healthy() {
+		t.Error("unhealthy after a single failure")
+	}
+	h.runOnce()
+	r := h.Results()[0]
+	if h.healthy() || r.OK || r.Failures != 2 || r.Error != "connection timed out" {
+		t.Errorf("after two failures: healthy=%v, %+v", h.healthy(), r)
+	}
+
+	// The interface going away clears the slate
+	h.reset()
+	if !h.healthy() {
+		t.Error("still unhealthy after reset")
+	}
+
+	fail = false
+	h.runOnce()
+	if !h.healthy() || h.Results()[0].Failures != 0 {
+		t.Errorf("after passing again: %+v", h.Results())
+	}
+}
+
+func TestMonitorHealth(t *testing.T) {
+	var fail bool
+	var downs, ups int
+	m := newTunnelMonitor(userspaceInterface, func() bool { return true })
+	m.health = newHealthChecker([]probe{{typ: ProbeHTTP, run: func(ctx context.Context) error {
+		if fail {
+			return errors.New("HTTP 502 Bad Gateway")
+		}
+		return nil
+	}}}, time.Minute, time.Second, 1)
+	m.OnUp(func() { ups++ })
+	m.OnDown(func() { downs++ })
+
+	m.checkInterface()
+	if !m.IsUp() || ups != 1 {
+		t.Fatalf("IsUp=%v, ups=%d", m.IsUp(), ups)
+	}
+	fail = true
+	m.health.runOnce()
+	m.checkInterface()
+	if m.IsUp() || downs != 1 {
+		t.Errorf("with a failing probe: IsUp=%v, downs=%d", m.IsUp(), downs)
+	}
+	fail = false
+	m.health.runOnce()
+	m.checkInterface()
+	if !m.IsUp() || ups != 2 {
+		t.Errorf("once it passes: IsUp=%v, ups=%d", m.IsUp(), ups)
+	}
+	if len(m.Health()) != 1 {
+		t.Errorf("Health() = %+v", m.Health())
+	}
+}
+
+func TestProbes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "no", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{Servers: []config.ServerConfig{
+		{Host: "198.51.100.1", Port: 119},
+		{Host: "127.0.0.1", Port: srv.Listener.Addr().(*net.TCPAddr).Port, Enabled: true},
+	}}
+	m := NewManager(cfg)
+	var dialer net.Dialer
+	ctx := context.Background()
+
+	tcp, err := m.newProbe(config.ProbeConfig{Type: ProbeTCP}, nil, nil, dialer.DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tcp.target, "127.0.0.1:") {
+		t.Errorf("tcp target = %q, want the enabled server", tcp.target)
+	}
+	if err := tcp.run(ctx); err != nil {
+		t.Errorf("tcp: %v", err)
+	}
+
+	for path, wantErr := range map[string]bool{"/": false, "/down": true} {
+		p, err := m.newProbe(config.ProbeConfig{Type: ProbeHTTP, Target: srv.URL + path}, nil, nil, dialer.DialContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.run(ctx); (err != nil) != wantErr {
+			t.Errorf("http %s: err = %v", path, err)
+		}
+	}
+
+	if _, err := m.newProbe(config.ProbeConfig{Type: ProbeHandshake}, nil, NewOpenVPNConnector(&config.OpenVPNConfig{}), dialer.DialContext); err == nil {
+		t.Error("expected an error for a handshake probe on OpenVPN")
+	}
+	if _, err := m.newProbe(config.ProbeConfig{Type: "ping"}, nil, nil, dialer.DialContext); err == nil {
+		t.Error("expected an error for an unknown probe type")
+	}
+}
+
+func TestCheckHandshake(t *testing.T) {
+	at := func(ts time.Time) func(ctx context.Context) (time.Time, error) {
+		return func(ctx context.Context) (time.Time, error) { return ts, nil }
+	}
+	ctx := context.Background()
+	if err := checkHandshake(ctx, at(time.Now().Add(-time.Minute)), 3*time.Minute); err != nil {
+		t.Errorf("recent handshake: %v", err)
+	}
+	if err := checkHandshake(ctx, at(time.Now().Add(-5*time.Minute)), 3*time.Minute); err == nil {
+		t.Error("expected an error for a stale handshake")
+	}
+	if err := checkHandshake(ctx, at(time.Time{}), 3*time.Minute); err == nil {
+		t.Error("expected an error before the first handshake")
+	}
+}
@@ -0,0 +1,54 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// maxRedirects is how many redirects an HTTP client follows.
+const maxRedirects = 5
+
+// NewHTTPClient returns an HTTP client whose connections are bound to the
+// given interface, so requests such as NZB downloads from an indexer leave
+// through the tunnel like the NNTP traffic does. An empty interfaceName gives
+// an unbound client with the same limits.
+//
+// Environment proxy settings are ignored: a proxy reached over the normal
+// route would defeat the binding.
+func NewHTTPClient(interfaceName string) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if interfaceName != "" {
+		dialer = BindToInterface(interfaceName)
+	}
+	return NewHTTPClientWith(dialer.DialContext)
+}
+
+// NewHTTPClientWith returns an HTTP client like NewHTTPClient whose
+// connections are opened with dial, such as Manager.Dialer for a tunnel that
+// has no host interface.
+func NewHTTPClientWith(dial func(ctx context.Context, network, address string) (net.Conn, error)) *http.Client {
+	return &http.Client{
+		Timeout: 2 * time.Minute,
+		Transport: &http.Transport{
+			DialContext:           dial,
+			TLSHandshakeTimeout:   15 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       30 * time.Second,
+			MaxIdleConns:          4,
+			ForceAttemptHTTP2:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to a non-HTTP URL")
+			}
+			return nil
+		},
+	}
+}
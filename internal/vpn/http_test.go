@@ -0,0 +1,33 @@
+package vpn
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHTTPClientRedirectLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/%d", n-1), http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	c := NewHTTPClient("")
+	resp, err := c.Get(srv.URL + fmt.Sprintf("/%d", maxRedirects))
+	if err != nil {
+		t.Fatalf("%d redirects: %v", maxRedirects, err)
+	}
+	resp.Body.Close()
+
+	if _, err := c.Get(srv.URL + fmt.Sprintf("/%d", maxRedirects+1)); err == nil {
+		t.Errorf("%d redirects: expected an error", maxRedirects+1)
+	}
+}
@@ -0,0 +1,412 @@
+package vpn
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"nzb-connect/internal/config"
+)
+
+// ImportedProfile is a VPN definition read from a provider's config file.
+type ImportedProfile struct {
+	Protocol  string
+	WireGuard *config.WireGuardConfig
+	OpenVPN   *config.OpenVPNConfig
+	Ignored   []string // settings left out, each with the reason
+}
+
+// Apply adds p to vpnCfg as the profile called name, replacing one of the
+// same name. The first profile is kept in vpnCfg's own protocol settings; once
+// there are more, they all move to the profiles list. Settings that aren't
+// part of a profile, such as the kill switch, are kept.
+func (p *ImportedProfile) Apply(vpnCfg *config.VPNConfig, name string) {
+	vpnCfg.Enabled = true
+	profile := config.VPNProfile{Name: name, Protocol: p.Protocol, WireGuard: p.WireGuard, OpenVPN: p.OpenVPN}
+
+	if len(vpnCfg.Profiles) == 0 && (vpnCfg.Protocol == "" || vpnCfg.Name == name) {
+		vpnCfg.Name = name
+		vpnCfg.Protocol, vpnCfg.WireGuard, vpnCfg.OpenVPN = p.Protocol, p.WireGuard, p.OpenVPN
+		return
+	}
+
+	profiles := append([]config.VPNProfile(nil), vpnCfg.ProfileList()...)
+	if i := slices.IndexFunc(profiles, func(q config.VPNProfile) bool { return q.Name == name }); i >= 0 {
+		profiles[i] = profile
+	} else {
+		profiles = append(profiles, profile)
+	}
+	vpnCfg.Profiles = profiles
+	vpnCfg.Name, vpnCfg.Protocol, vpnCfg.WireGuard, vpnCfg.OpenVPN = "", "", nil, nil
+}
+
+// ImportProfile parses a wg-quick .conf or an OpenVPN .ovpn file, telling
+// them apart by the [Interface] section. readFile loads files an .ovpn refers
+// to instead of inlining them, such as "ca ca.crt"; if nil, such references
+// are an error.
+func ImportProfile(data []byte, readFile func(name string) ([]byte, error)) (*ImportedProfile, error) {
+	if isWireGuardConf(data) {
+		wg, ignored, err := ParseWireGuardConf(data)
+		if err != nil {
+			return nil, err
+		}
+		return &ImportedProfile{Protocol: "wireguard", WireGuard: wg, Ignored: ignored}, nil
+	}
+	ov, ignored, err := ParseOpenVPNProfile(data, readFile)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportedProfile{Protocol: "openvpn", OpenVPN: ov, Ignored: ignored}, nil
+}
+
+func isWireGuardConf(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if strings.EqualFold(strings.TrimSpace(scanner.Text()), "[Interface]") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWireGuardConf parses a wg-quick config file with one [Peer]. It
+// returns the settings it left out: wg-quick's hooks and routing options,
+// which don't apply to how the connector sets up the tunnel.
+func ParseWireGuardConf(data []byte) (*config.WireGuardConfig, []string, error) {
+	wg := &config.WireGuardConfig{}
+	var ignored, addrs, dns []string
+	var section string
+	peers := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			if section == "peer" {
+				peers++
+				if peers == 2 {
+					ignored = append(ignored, "[Peer] sections after the first: only one peer is supported")
+				}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch section {
+		case "interface":
+			switch key {
+			case "privatekey":
+				wg.PrivateKey = value
+			case "address":
+				addrs = append(addrs, splitList(value)...)
+			case "dns":
+				// wg-quick also takes search domains here
+				for _, s := range splitList(value) {
+					if net.ParseIP(s) != nil {
+						dns = append(dns, s)
+					}
+				}
+			case "listenport":
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("line %d: invalid ListenPort %q", n, value)
+				}
+				wg.ListenPort = port
+			case "preup", "postup", "predown", "postdown":
+				ignored = append(ignored, fmt.Sprintf("%s: hook commands are not run", key))
+			default:
+				ignored = append(ignored, fmt.Sprintf("%s: not supported", key))
+			}
+		case "peer":
+			if peers > 1 {
+				continue
+			}
+			switch key {
+			case "publickey":
+				wg.PeerPublicKey = value
+			case "presharedkey":
+				wg.PresharedKey = value
+			case "endpoint":
+				wg.PeerEndpoint = value
+			case "allowedips":
+				wg.AllowedIPs = strings.Join(splitList(value), ", ")
+			case "persistentkeepalive":
+				if value == "off" {
+					continue
+				}
+				keepalive, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("line %d: invalid PersistentKeepalive %q", n, value)
+				}
+				wg.PersistentKeepalive = keepalive
+			default:
+				ignored = append(ignored, fmt.Sprintf("%s: not supported", key))
+			}
+		default:
+			return nil, nil, fmt.Errorf("line %d: %s outside [Interface] or [Peer]", n, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	wg.Address = strings.Join(addrs, ", ")
+	wg.DNS = strings.Join(dns, ", ")
+	switch {
+	case wg.PrivateKey == "":
+		return nil, nil, fmt.Errorf("no PrivateKey in [Interface]")
+	case peers == 0:
+		return nil, nil, fmt.Errorf("no [Peer] section")
+	case wg.PeerPublicKey == "":
+		return nil, nil, fmt.Errorf("no PublicKey in [Peer]")
+	case wg.PeerEndpoint == "":
+		return nil, nil, fmt.Errorf("no Endpoint in [Peer]")
+	}
+	return wg, ignored, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// openVPNHandled are the directives the connector sets itself, or that would
+// stop it from following openvpn's output.
+var openVPNHandled = map[string]string{
+	"client":        "always set",
+	"nobind":        "always set",
+	"verb":          "set by nzb-connect",
+	"key-direction": "tls-auth always uses direction 1",
+	"daemon":        "openvpn must stay in the foreground",
+	"log":           "output is read by nzb-connect",
+	"log-append":    "output is read by nzb-connect",
+	"syslog":        "output is read by nzb-connect",
+	"writepid":      "the process is managed by nzb-connect",
+	"config":        "nested config files are not imported",
+	"cd":            "changes where files are looked up",
+}
+
+// openVPNScripts are directives that run programs. openvpn runs as root
+// here, so they are never taken from an imported file.
+var openVPNScripts = map[string]bool{
+	"up": true, "down": true, "route-up": true, "route-pre-down": true,
+	"ipchange": true, "tls-verify": true, "auth-user-pass-verify": true,
+	"client-connect": true, "client-disconnect": true, "learn-address": true,
+	"plugin": true, "script-security": true,
+}
+
+// openVPNInlinable are directives without a field of their own that take a
+// file. Files they refer to are inlined into Extra, which openvpn reads from
+// a temporary directory.
+var openVPNInlinable = map[string]bool{
+	"tls-crypt": true, "tls-crypt-v2": true, "crl-verify": true, "extra-certs": true,
+}
+
+// ParseOpenVPNProfile parses an .ovpn file. The first remote, proto, dev,
+// credentials and keys (inline or, through readFile, as files), cipher, auth
+// and compress fill in the config's fields. Other directives are kept in
+// Extra and passed to openvpn as they are, except the ones the connector
+// handles itself and script hooks, which are returned as ignored.
+func ParseOpenVPNProfile(data []byte, readFile func(name string) ([]byte, error)) (*config.OpenVPNConfig, []string, error) {
+	ov := &config.OpenVPNConfig{}
+	var ignored []string
+	var extra strings.Builder
+	var userpass bool
+	port := 0
+
+	file := func(n int, directive string, args []string) (string, error) {
+		if len(args) == 0 {
+			return "", fmt.Errorf("line %d: %s needs a file", n, directive)
+		}
+		if readFile == nil {
+			return "", fmt.Errorf("line %d: %s refers to %s; inline it as <%s>", n, directive, args[0], directive)
+		}
+		content, err := readFile(args[0])
+		if err != nil {
+			return "", fmt.Errorf("line %d: %s: %w", n, directive, err)
+		}
+		return string(content), nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// Inline block: <ca> ... </ca>
+		if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") && !strings.HasPrefix(line, "</") {
+			tag := line[1 : len(line)-1]
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if strings.TrimSpace(lines[j]) == "</"+tag+">" {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				return nil, nil, fmt.Errorf("line %d: <%s> is never closed", n, tag)
+			}
+			body := strings.Join(lines[i+1:end], "\n") + "\n"
+			switch tag {
+			case "ca":
+				ov.CACert = body
+			case "cert":
+				ov.ClientCert = body
+			case "key":
+				ov.ClientKey = body
+			case "tls-auth":
+				ov.TLSAuth = body
+			case "auth-user-pass":
+				ov.Username, ov.Password = userPass(body)
+				userpass = true
+			default:
+				extra.WriteString(strings.Join(lines[i:end+1], "\n") + "\n")
+			}
+			i = end
+			continue
+		}
+
+		fields := strings.Fields(line)
+		directive, args := strings.TrimPrefix(fields[0], "--"), fields[1:]
+		switch {
+		case directive == "remote" && ov.RemoteHost == "":
+			if len(args) == 0 {
+				return nil, nil, fmt.Errorf("line %d: remote needs a host", n)
+			}
+			ov.RemoteHost = args[0]
+			if len(args) > 1 {
+				p, err := strconv.Atoi(args[1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("line %d: invalid port %q", n, args[1])
+				}
+				ov.RemotePort = p
+			}
+			if len(args) > 2 {
+				ov.Protocol = openVPNProto(args[2])
+			}
+		case directive == "port" && len(args) == 1:
+			p, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid port %q", n, args[0])
+			}
+			port = p
+		case directive == "proto" && len(args) == 1:
+			if ov.Protocol == "" {
+				ov.Protocol = openVPNProto(args[0])
+			}
+		case directive == "dev" && len(args) == 1:
+			ov.DeviceType = strings.TrimRight(args[0], "0123456789")
+		case directive == "dev-type" && len(args) == 1:
+			ov.DeviceType = args[0]
+		case directive == "auth-user-pass":
+			userpass = true
+			if len(args) > 0 && readFile == nil {
+				ignored = append(ignored, fmt.Sprintf("auth-user-pass %s: enter the username and password instead", args[0]))
+			} else if len(args) > 0 {
+				content, err := file(n, directive, args)
+				if err != nil {
+					return nil, nil, err
+				}
+				ov.Username, ov.Password = userPass(content)
+			}
+		case directive == "ca" || directive == "cert" || directive == "key" || directive == "tls-auth":
+			if len(args) > 0 && args[0] == "[[inline]]" {
+				continue // the <block> follows
+			}
+			content, err := file(n, directive, args)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch directive {
+			case "ca":
+				ov.CACert = content
+			case "cert":
+				ov.ClientCert = content
+			case "key":
+				ov.ClientKey = content
+			case "tls-auth":
+				ov.TLSAuth = content
+			}
+		case directive == "cipher" && len(args) == 1:
+			ov.Cipher = args[0]
+		case directive == "auth" && len(args) == 1:
+			ov.Auth = args[0]
+		case directive == "compress":
+			ov.Compress = strings.Join(args, " ")
+			if ov.Compress == "" {
+				extra.WriteString(line + "\n")
+			}
+		case openVPNInlinable[directive] && len(args) > 0 && args[0] != "[[inline]]":
+			content, err := file(n, directive, args)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+			fmt.Fprintf(&extra, "<%s>\n%s</%s>\n", directive, content, directive)
+		case openVPNScripts[directive]:
+
+			ignored = append(ignored, fmt.Sprintf("%s: scripts from imported files are not run", directive))
+		case openVPNHandled[directive] != "":
+			ignored = append(ignored, fmt.Sprintf("%s: %s", directive, openVPNHandled[directive]))
+		default:
+			extra.WriteString(line + "\n")
+		}
+	}
+
+	if ov.RemoteHost == "" {
+		return nil, nil, fmt.Errorf("no remote in the profile")
+	}
+	if ov.RemotePort == 0 {
+		ov.RemotePort = port
+	}
+	if userpass {
+		ov.AuthType = "userpass"
+	} else if ov.ClientCert != "" {
+		ov.AuthType = "certificate"
+	}
+	ov.Extra = extra.String()
+	return ov, ignored, nil
+}
+
+// openVPNProto maps openvpn's proto names onto "udp" and "tcp".
+func openVPNProto(p string) string {
+	if strings.HasPrefix(p, "tcp") {
+		return "tcp"
+	}
+	return "udp"
+}
+
+// userPass splits an auth-user-pass file into its username and password.
+func userPass(s string) (string, string) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	user := strings.TrimSpace(lines[0])
+	if len(lines) < 2 {
+		return user, ""
+	}
+	return user, strings.TrimSpace(lines[1])
+}
@@ -0,0 +1,193 @@
+package vpn
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"nzb-connect/internal/config"
+)
+
+const testWGQuick = `[Interface]
+# Provider-generated
+PrivateKey = cHJpdmF0ZWtleXByaXZhdGVrZXlwcml2YXRla2V5MDA=
+Address = 10.2.0.2/32, fd00::2/128
+DNS = 10.2.0.1, vpn.internal
+MTU = 1420
+PostUp = iptables -A OUTPUT -j DROP
+
+[Peer]
+PublicKey = cHVibGlja2V5cHVibGlja2V5cHVibGlja2V5cHViMDA=
+AllowedIPs = 0.0.0.0/0,::/0
+Endpoint = 198.51.100.7:51820
+PersistentKeepalive = 25
+`
+
+func TestParseWireGuardConf(t *testing.T) {
+	p, err := ImportProfile([]byte(testWGQuick), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Protocol != "wireguard" || p.WireGuard == nil {
+		t.Fatalf("got protocol %q", p.Protocol)
+	}
+	wg := p.WireGuard
+	for _, c := range []struct{ name, got, want string }{
+		{"address", wg.Address, "10.2.0.2/32, fd00::2/128"},
+		{"dns", wg.DNS, "10.2.0.1"},
+		{"endpoint", wg.PeerEndpoint, "198.51.100.7:51820"},
+		{"allowed ips", wg.AllowedIPs, "0.0.0.0/0, ::/0"},
+		{"public key", wg.PeerPublicKey, "cHVibGlja2V5cHVibGlja2V5cHVibGlja2V5cHViMDA="},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	if wg.PersistentKeepalive != 25 {
+		t.Errorf("keepalive = %d", wg.PersistentKeepalive)
+	}
+	if len(p.Ignored) != 2 {
+		t.Errorf("ignored = %v, want MTU and PostUp", p.Ignored)
+	}
+
+	if _, _, err := ParseWireGuardConf([]byte("[Interface]\nPrivateKey = x\n")); err == nil {
+		t.Error("expected an error without a peer")
+	}
+}
+
+const testOVPN = `client
+dev tun
+proto udp
+remote vpn1.example.com 1194
+remote vpn2.example.com 1194
+remote-random
+resolv-retry infinite
+nobind
+persist-key
+auth-user-pass
+cipher AES-256-GCM
+auth SHA512
+verb 4
+script-security 2
+up /etc/openvpn/update-resolv-conf
+remote-cert-tls server
+<ca>
+-----BEGIN CERTIFICATE-----
+CA
+-----END CERTIFICATE-----
+</ca>
+key-direction 1
+<tls-auth>
+-----BEGIN OpenVPN Static key V1-----
+TA
+-----END OpenVPN Static key V1-----
+</tls-auth>
+<tls-crypt>
+-----BEGIN OpenVPN Static key V1-----
+TC
+-----END OpenVPN Static key V1-----
+</tls-crypt>
+`
+
+func TestParseOpenVPNProfile(t *testing.T) {
+	p, err := ImportProfile([]byte(testOVPN), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov := p.OpenVPN
+	if p.Protocol != "openvpn" || ov == nil {
+		t.Fatalf("got protocol %q", p.Protocol)
+	}
+	if ov.RemoteHost != "vpn1.example.com" || ov.RemotePort != 1194 || ov.Protocol != "udp" || ov.DeviceType != "tun" {
+		t.Errorf("remote = %s:%d/%s on %s", ov.RemoteHost, ov.RemotePort, ov.Protocol, ov.DeviceType)
+	}
+	if ov.AuthType != "userpass" || ov.Cipher != "AES-256-GCM" || ov.Auth != "SHA512" {
+		t.Errorf("auth type %q, cipher %q, auth %q", ov.AuthType, ov.Cipher, ov.Auth)
+	}
+	if !strings.Contains(ov.CACert, "\nCA\n") || !strings.Contains(ov.TLSAuth, "\nTA\n") {
+		t.Errorf("inline blocks not read: ca %q, tls-auth %q", ov.CACert, ov.TLSAuth)
+	}
+
+	// Unknown directives and blocks pass through; ours and scripts don't
+	for _, want := range []string{"remote vpn2.example.com 1194\n", "remote-random\n", "persist-key\n", "remote-cert-tls server\n", "<tls-crypt>\n", "TC\n", "</tls-crypt>\n"} {
+		if !strings.Contains(ov.Extra, want) {
+			t.Errorf("extra is missing %q:\n%s", want, ov.Extra)
+		}
+	}
+	for _, unwanted := range []string{"up ", "script-security", "verb", "client", "nobind", "<ca>", "cipher"} {
+		if strings.Contains(ov.Extra, unwanted) {
+			t.Errorf("extra has %q:\n%s", unwanted, ov.Extra)
+		}
+	}
+	if len(p.Ignored) != 6 {
+		t.Errorf("ignored = %v", p.Ignored)
+	}
+}
+
+func TestParseOpenVPNProfileFiles(t *testing.T) {
+	profile := []byte("remote 203.0.113.9 443 tcp-client\nca ca.crt\ncert client.crt\nkey client.key\ntls-crypt tc.key\n")
+	if _, err := ImportProfile(profile, nil); err == nil {
+		t.Error("expected an error for file references without readFile")
+	}
+
+	files := map[string]string{"ca.crt": "CA\n", "client.crt": "CERT\n", "client.key": "KEY\n", "tc.key": "TC"}
+	p, err := ImportProfile(profile, func(name string) ([]byte, error) {
+		if f, ok := files[name]; ok {
+			return []byte(f), nil
+		}
+		return nil, errors.New("not found")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov := p.OpenVPN
+	if ov.Protocol != "tcp" || ov.RemotePort != 443 {
+		t.Errorf("remote = %d/%s", ov.RemotePort, ov.Protocol)
+	}
+	if ov.CACert != "CA\n" || ov.ClientCert != "CERT\n" || ov.ClientKey != "KEY\n" || ov.AuthType != "certificate" {
+		t.Errorf("files not read: %+v", ov)
+	}
+	if ov.Extra != "<tls-crypt>\nTC\n</tls-crypt>\n" {
+		t.Errorf("extra = %q", ov.Extra)
+	}
+}
+
+func TestImportedProfileApply(t *testing.T) {
+	wg := &ImportedProfile{Protocol: "wireguard", WireGuard: &config.WireGuardConfig{PeerEndpoint: "198.51.100.7:51820"}}
+	ov := &ImportedProfile{Protocol: "openvpn", OpenVPN: &config.OpenVPNConfig{RemoteHost: "203.0.113.9"}}
+
+	// The first import fills in the top-level fields, as before profiles
+	var cfg config.VPNConfig
+	wg.Apply(&cfg, "home")
+	if cfg.Protocol != "wireguard" || cfg.Name != "home" || len(cfg.Profiles) != 0 {
+		t.Fatalf("after first import: %+v", cfg)
+	}
+	// Re-importing under the same name replaces it in place
+	wg.Apply(&cfg, "home")
+	if len(cfg.Profiles) != 0 {
+		t.Fatalf("re-import added a profile: %+v", cfg.Profiles)
+	}
+
+	// Another name moves both into the profile list
+	ov.Apply(&cfg, "away")
+	if cfg.Protocol != "" || cfg.WireGuard != nil {
+		t.Errorf("top-level fields not cleared: %+v", cfg)
+	}
+	if names := profileNames(cfg.Profiles); !slices.Equal(names, []string{"home", "away"}) {
+		t.Fatalf("profiles = %q", names)
+	}
+
+	ov.Apply(&cfg, "home")
+	if len(cfg.Profiles) != 2 || cfg.Profiles[0].Protocol != "openvpn" {
+		t.Errorf("upsert by name: %+v", cfg.Profiles)
+	}
+}
+
+func profileNames(profiles []config.VPNProfile) []string {
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
@@ -0,0 +1,392 @@
+package vpn
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"nzb-connect/internal/config"
+)
+
+// Kill switch modes (vpn.kill_switch).
+const (
+	KillSwitchOff      = ""
+	KillSwitchNftables = "nftables" // install the rules with nft
+	KillSwitchDryRun   = "dry-run"  // only log the rules that would be installed
+)
+
+// killSwitchTable is the nftables table holding the kill switch. Keeping it
+// in a table of its own means removing it can't touch anyone else's rules.
+const killSwitchTable = "nzbconnect_killswitch"
+
+// killSwitch describes what the kill switch lets through. Everything else
+// this process sends is rejected, so nothing leaves outside the tunnel even
+// in the moments between the VPN dropping and the pools being closed, or
+// from sockets that were never bound to the interface.
+type killSwitch struct {
+	match     string     // nft expression selecting this process's traffic
+	ifaces    []string   // tunnel interfaces; a trailing * matches any suffix
+	endpoints []endpoint // the VPN servers, so the tunnel can be (re)built
+	allow     []string   // networks always reachable, e.g. the LAN
+	dns       []string   // resolvers reachable on port 53
+}
+
+type endpoint struct {
+	ip    net.IP
+	proto string // "udp" or "tcp"
+	port  int
+}
+
+// ruleset returns the kill switch as an nft script. The script first deletes
+// any earlier version of the table, so applying it replaces the rules in one
+// transaction.
+func (k killSwitch) ruleset() string {
+	var b strings.Builder
+	rule := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, "\t\t%s %s\n", k.match, fmt.Sprintf(format, args...))
+	}
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n", killSwitchTable, killSwitchTable)
+	fmt.Fprintf(&b, "table inet %s {\n\tchain output {\n", killSwitchTable)
+	b.WriteString("\t\ttype filter hook output priority filter; policy accept;\n")
+	rule(`oifname "lo" accept`)
+	rule("ct direction reply accept") // answers to the web UI and API
+	for _, iface := range k.ifaces {
+		rule("oifname %q accept", iface)
+	}
+	for _, e := range k.endpoints {
+		rule("%s daddr %s %s dport %d accept", ipFamily(e.ip), e.ip, e.proto, e.port)
+	}
+	for _, n := range k.allow {
+		ip, _, _ := net.ParseCIDR(n)
+		rule("%s daddr %s accept", ipFamily(ip), n)
+	}
+	for _, d := range k.dns {
+		ip := net.ParseIP(d)
+		rule("%s daddr %s udp dport 53 accept", ipFamily(ip), d)
+		rule("%s daddr %s tcp dport 53 accept", ipFamily(ip), d)
+	}
+	rule("counter reject")
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// KillSwitchRuleset returns the nft script the kill switch would install for
+// cfg, for checking it without root.
+func KillSwitchRuleset(cfg config.VPNConfig) (string, error) {
+	k, err := newKillSwitch(cfg, expectedInterfaces(cfg))
+	if err != nil {
+		return "", err
+	}
+	return k.ruleset(), nil
+}
+
+// newKillSwitch builds the kill switch for cfg with the tunnel on one of
+// ifaces. Traffic to the servers of every profile is allowed, so failing over
+// works; a profile whose server can't be resolved is left out, unless it is
+// the only one.
+func newKillSwitch(cfg config.VPNConfig, ifaces []string) (killSwitch, error) {
+	if len(ifaces) == 0 {
+		return killSwitch{}, fmt.Errorf("kill switch: no VPN interface configured")
+	}
+	match, err := processMatch(cfg.KillSwitchShared)
+	if err != nil {
+		return killSwitch{}, err
+	}
+	k := killSwitch{match: match, ifaces: ifaces}
+
+	profiles := cfg.ProfileList()
+	for _, p := range profiles {
+		if err := k.addProfile(p); err != nil {
+			if len(profiles) == 1 {
+				return k, err
+			}
+			log.Printf("WARNING: kill switch: profile %q left out: %v", p.Name, err)
+		}
+	}
+	if len(profiles) > 0 && len(k.endpoints) == 0 {
+		return k, fmt.Errorf("kill switch: no VPN endpoint could be resolved")
+	}
+
+	for _, n := range cfg.KillSwitchAllow {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return k, fmt.Errorf("kill switch: kill_switch_allow: %w", err)
+		}
+		k.allow = append(k.allow, n)
+	}
+
+	// With tunnel DNS, lookups go through the tunnel like everything else;
+	// otherwise the system resolvers must stay reachable.
+	if !cfg.TunnelDNS {
+		k.dns = systemNameservers()
+	}
+	return k, nil
+}
+
+// addProfile allows traffic to the server of p.
+func (k *killSwitch) addProfile(p config.VPNProfile) error {
+	switch {
+	case p.Protocol == "wireguard" && p.WireGuard != nil:
+		host, port, err := net.SplitHostPort(p.WireGuard.PeerEndpoint)
+		if err != nil {
+			return fmt.Errorf("kill switch: peer endpoint %q: %w", p.WireGuard.PeerEndpoint, err)
+		}
+		n, _ := strconv.Atoi(port)
+		return k.addEndpoint(host, "udp", n)
+	case p.Protocol == "openvpn" && p.OpenVPN != nil:
+		proto, port := p.OpenVPN.Protocol, p.OpenVPN.RemotePort
+		if proto == "" {
+			proto = "udp"
+		}
+		if port == 0 {
+			port = 1194
+		}
+		return k.addEndpoint(p.OpenVPN.RemoteHost, strings.TrimSuffix(proto, "-client"), port)
+	}
+	return nil
+}
+
+// addEndpoint allows traffic to the VPN server. A host name is resolved now,
+// as the rules can only hold addresses.
+func (k *killSwitch) addEndpoint(host, proto string, port int) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("kill switch: resolving VPN endpoint %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		k.endpoints = append(k.endpoints, endpoint{ip: ip, proto: proto, port: port})
+	}
+	return nil
+}
+
+// expectedInterfaces returns the tunnel interfaces for cfg before one is up.
+// The managed connectors pick the number when they connect, so any is
+// allowed.
+func expectedInterfaces(cfg config.VPNConfig) []string {
+	profiles := cfg.ProfileList()
+	if len(profiles) == 0 {
+		if cfg.Interface == "" {
+			return nil
+		}
+		return []string{cfg.Interface}
+	}
+	var ifaces []string
+	for _, p := range profiles {
+		iface := "tun*"
+		switch {
+		case p.Protocol == "wireguard":
+			iface = "wg*"
+		case p.OpenVPN != nil && p.OpenVPN.DeviceType != "":
+			iface = p.OpenVPN.DeviceType + "*"
+		}
+		if !slices.Contains(ifaces, iface) {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces
+}
+
+// processMatch returns an nft expression matching this process's sockets:
+// its cgroup when that belongs to this process alone, or its UID in a
+// container whose cgroup namespace hides the path. Anything else, such as the
+// login session's cgroup when run with sudo or the UID outside a container,
+// takes in other processes too, so it is an error unless shared is set.
+func processMatch(shared bool) (string, error) {
+	_, err := os.Stat("/.dockerenv")
+	container := err == nil
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		container = true
+	}
+	return matchFor(ownCgroup(), container, os.Getuid(), shared)
+}
+
+// matchFor is processMatch for a process in cgroup path (without the leading
+// slash, "" for the root), inside a container or not, running as uid.
+func matchFor(path string, container bool, uid int, shared bool) (string, error) {
+	byCgroup := func() string {
+		level := strings.Count(path, "/") + 1
+		return fmt.Sprintf("socket cgroupv2 level %d %q", level, path)
+	}
+	byUID := fmt.Sprintf("meta skuid %d", uid)
+	switch {
+	case path != "" && dedicatedCgroup(path):
+		return byCgroup(), nil
+	case path == "" && container:
+		return byUID, nil
+	case !shared:
+		what := "UID " + strconv.Itoa(uid)
+		if path != "" {
+			what = "cgroup " + path
+		}
+		return "", fmt.Errorf("kill switch: %s is shared with other processes; run nzb-connect as a systemd service or in a container, or set kill_switch_shared to block their traffic too", what)
+	case path != "":
+		log.Printf("WARNING: kill switch also rejects the traffic of other processes in cgroup %s", path)
+		return byCgroup(), nil
+	default:
+		log.Printf("WARNING: kill switch also rejects the traffic of other processes running as UID %d", uid)
+		return byUID, nil
+	}
+}
+
+// containerScopes are the prefixes of the systemd scopes container runtimes
+// put each container in.
+var containerScopes = []string{"docker-", "libpod-", "crio-", "cri-containerd-", "containerd-"}
+
+// dedicatedCgroup reports whether cgroup path holds a single service or
+// container rather than a login session or a slice shared by many.
+func dedicatedCgroup(path string) bool {
+	elems := strings.Split(path, "/")
+	last := elems[len(elems)-1]
+	if strings.HasSuffix(last, ".service") {
+		// user@UID.service is the user's own service manager, holding
+		// everything they start
+		return !strings.HasPrefix(last, "user@")
+	}
+	if strings.HasSuffix(last, ".scope") {
+		for _, prefix := range containerScopes {
+			if strings.HasPrefix(last, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	// cgroupfs driver: docker/<id>, kubepods/.../<id>
+	return len(elems) >= 2 && (elems[0] == "docker" || strings.HasPrefix(elems[0], "kubepods"))
+}
+
+// ownCgroup returns the process's cgroup v2 path without the leading slash,
+// or "" if it is in the root cgroup or cgroup v2 isn't in use.
+func ownCgroup() string {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::/"); ok {
+			return strings.TrimSpace(path)
+		}
+	}
+	return ""
+}
+
+// systemNameservers returns the nameservers in /etc/resolv.conf.
+func systemNameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// install applies (or, in dry-run mode, logs) the kill switch, replacing any
+// earlier rules.
+func (k killSwitch) install(mode string) error {
+	rules := k.ruleset()
+	if mode == KillSwitchDryRun {
+		log.Printf("Kill switch (dry run), would apply:\n%s", rules)
+		return nil
+	}
+	cmd := exec.Command(resolveCmd("nft"), "-f", "-")
+	cmd.Stdin = strings.NewReader(rules)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nft: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("Kill switch active: traffic only through %s and to the VPN endpoints", strings.Join(k.ifaces, ", "))
+	return nil
+}
+
+// uninstallKillSwitch deletes the kill switch table.
+func uninstallKillSwitch(mode string) error {
+	if mode == KillSwitchDryRun {
+		log.Printf("Kill switch (dry run), would run: nft delete table inet %s", killSwitchTable)
+		return nil
+	}
+	out, err := exec.Command(resolveCmd("nft"), "delete", "table", "inet", killSwitchTable).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	log.Println("Kill switch removed")
+	return nil
+}
+
+// startKillSwitch applies the kill switch from the config, before the tunnel
+// is up, or removes it if it has been turned off. The endpoint is resolved
+// here: once the rules are in, the system resolver may be out of reach.
+func (m *Manager) startKillSwitch(vpnCfg config.VPNConfig) {
+	m.ksMu.Lock()
+	defer m.ksMu.Unlock()
+	mode := vpnCfg.KillSwitch
+	if mode != KillSwitchOff && mode != KillSwitchNftables && mode != KillSwitchDryRun {
+		log.Printf("WARNING: unknown vpn.kill_switch %q, kill switch not applied", mode)
+		mode = KillSwitchOff
+	}
+	if mode == KillSwitchOff {
+		m.liftKillSwitchLocked()
+		return
+	}
+	k, err := newKillSwitch(vpnCfg, expectedInterfaces(vpnCfg))
+	if err == nil {
+		err = k.install(mode)
+	}
+	if err != nil {
+		log.Printf("WARNING: kill switch not applied: %v", err)
+		m.liftKillSwitchLocked()
+		return
+	}
+	m.ksMode, m.ks = mode, k
+}
+
+// narrowKillSwitch limits the kill switch to the interface the tunnel came up
+// on.
+func (m *Manager) narrowKillSwitch(iface string) {
+	m.ksMu.Lock()
+	defer m.ksMu.Unlock()
+	if m.ksMode == KillSwitchOff || slices.Equal(m.ks.ifaces, []string{iface}) {
+		return
+	}
+	k := m.ks
+	k.ifaces = []string{iface}
+	if err := k.install(m.ksMode); err != nil {
+		log.Printf("Kill switch: %v", err)
+		return
+	}
+	m.ks = k
+}
+
+// liftKillSwitch removes the kill switch, if one is in place.
+func (m *Manager) liftKillSwitch() {
+	m.ksMu.Lock()
+	defer m.ksMu.Unlock()
+	m.liftKillSwitchLocked()
+}
+
+func (m *Manager) liftKillSwitchLocked() {
+	if m.ksMode == KillSwitchOff {
+		return
+	}
+	if err := uninstallKillSwitch(m.ksMode); err != nil {
+		log.Printf("Kill switch: %v", err)
+	}
+	m.ksMode, m.ks = KillSwitchOff, killSwitch{}
+}
@@ -0,0 +1,143 @@
+package vpn
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"nzb-connect/internal/config"
+)
+
+func TestKillSwitchRuleset(t *testing.T) {
+	k := killSwitch{
+		match:  "meta skuid 1000",
+		ifaces: []string{"wg*"},
+		endpoints: []endpoint{
+			{ip: net.ParseIP("198.51.100.7"), proto: "udp", port: 51820},
+			{ip: net.ParseIP("2001:db8::7"), proto: "udp", port: 51820},
+		},
+		allow: []string{"192.168.1.0/24"},
+		dns:   []string{"192.168.1.1"},
+	}
+	want := `table inet nzbconnect_killswitch
+delete table inet nzbconnect_killswitch
+table inet nzbconnect_killswitch {
+	chain output {
+		type filter hook output priority filter; policy accept;
+		meta skuid 1000 oifname "lo" accept
+		meta skuid 1000 ct direction reply accept
+		meta skuid 1000 oifname "wg*" accept
+		meta skuid 1000 ip daddr 198.51.100.7 udp dport 51820 accept
+		meta skuid 1000 ip6 daddr 2001:db8::7 udp dport 51820 accept
+		meta skuid 1000 ip daddr 192.168.1.0/24 accept
+		meta skuid 1000 ip daddr 192.168.1.1 udp dport 53 accept
+		meta skuid 1000 ip daddr 192.168.1.1 tcp dport 53 accept
+		meta skuid 1000 counter reject
+	}
+}
+`
+	if got := k.ruleset(); got != want {
+		t.Errorf("ruleset:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNewKillSwitch(t *testing.T) {
+	cfg := config.VPNConfig{
+		Protocol:         "openvpn",
+		TunnelDNS:        true,
+		OpenVPN:          &config.OpenVPNConfig{RemoteHost: "203.0.113.9", Protocol: "tcp"},
+		KillSwitchAllow:  []string{"10.0.0.0/8"},
+		KillSwitchShared: true, // whatever cgroup the tests run in
+	}
+	k, err := newKillSwitch(cfg, expectedInterfaces(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(k.ifaces, []string{"tun*"}) {
+		t.Errorf("ifaces = %q, want [tun*]", k.ifaces)
+	}
+	if len(k.endpoints) != 1 || k.endpoints[0].proto != "tcp" || k.endpoints[0].port != 1194 {
+		t.Errorf("endpoints = %+v, want 203.0.113.9 tcp/1194", k.endpoints)
+	}
+	if len(k.dns) != 0 {
+		t.Errorf("system DNS allowed with tunnel_dns on: %v", k.dns)
+	}
+	if !strings.HasSuffix(k.ruleset(), "counter reject\n\t}\n}\n") {
+		t.Error("ruleset doesn't end by rejecting everything else")
+	}
+
+	cfg.KillSwitchAllow = []string{"10.0.0.1"}
+	if _, err := newKillSwitch(cfg, []string{"tun0"}); err == nil {
+		t.Error("expected an error for an allow entry that isn't a CIDR")
+	}
+	if _, err := newKillSwitch(config.VPNConfig{}, nil); err == nil {
+		t.Error("expected an error without an interface")
+	}
+}
+
+func TestNewKillSwitchProfiles(t *testing.T) {
+	cfg := config.VPNConfig{
+		TunnelDNS:        true,
+		KillSwitchShared: true,
+		Profiles: []config.VPNProfile{
+			{Name: "home", Protocol: "wireguard", WireGuard: &config.WireGuardConfig{PeerEndpoint: "198.51.100.7:51820"}},
+			{Name: "broken", Protocol: "openvpn", OpenVPN: &config.OpenVPNConfig{}},
+			{Name: "away", Protocol: "openvpn", OpenVPN: &config.OpenVPNConfig{RemoteHost: "203.0.113.9", DeviceType: "tap"}},
+		},
+	}
+	ifaces := expectedInterfaces(cfg)
+	if !slices.Equal(ifaces, []string{"wg*", "tun*", "tap*"}) {
+		t.Errorf("ifaces = %q", ifaces)
+	}
+	k, err := newKillSwitch(cfg, ifaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The profile without a remote is left out rather than failing the rest
+	if len(k.endpoints) != 2 || !k.endpoints[0].ip.Equal(net.ParseIP("198.51.100.7")) || !k.endpoints[1].ip.Equal(net.ParseIP("203.0.113.9")) {
+		t.Errorf("endpoints = %+v", k.endpoints)
+	}
+
+	cfg.Profiles = cfg.Profiles[1:2]
+	if _, err := newKillSwitch(cfg, ifaces); err == nil {
+		t.Error("expected an error when the only profile can't be resolved")
+	}
+}
+
+func TestKillSwitchMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		container bool
+		uid       int
+		shared    bool
+		want      string // "" for an error
+	}{
+		{"systemd service", "system.slice/nzb-connect.service", false, 0, false, `socket cgroupv2 level 2 "system.slice/nzb-connect.service"`},
+		{"docker scope", "system.slice/docker-0123abcd.scope", false, 0, false, `socket cgroupv2 level 2 "system.slice/docker-0123abcd.scope"`},
+		{"podman scope", "machine.slice/libpod-0123abcd.scope", false, 0, false, `socket cgroupv2 level 2 "machine.slice/libpod-0123abcd.scope"`},
+		{"cgroupfs docker", "docker/0123abcd", false, 0, false, `socket cgroupv2 level 2 "docker/0123abcd"`},
+		{"container with cgroup namespace", "", true, 0, false, "meta skuid 0"},
+		{"sudo from a login shell", "user.slice/user-1000.slice/session-3.scope", false, 0, false, ""},
+		{"user service manager", "user.slice/user-1000.slice/user@1000.service", false, 1000, false, ""},
+		{"desktop app scope", "user.slice/user-1000.slice/user@1000.service/app.slice/app-foo.scope", false, 1000, false, ""},
+		{"root cgroup", "", false, 0, false, ""},
+		{"session, shared", "user.slice/user-1000.slice/session-3.scope", false, 0, true, `socket cgroupv2 level 3 "user.slice/user-1000.slice/session-3.scope"`},
+		{"root cgroup, shared", "", false, 1000, true, "meta skuid 1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchFor(tt.path, tt.container, tt.uid, tt.shared)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("matchFor = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("matchFor = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
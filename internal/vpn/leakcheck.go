@@ -0,0 +1,295 @@
+package vpn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	leakCheckTimeout = 20 * time.Second
+	leakCheckRetry   = 30 * time.Second
+	leakCheckHistory = 20
+)
+
+// procNetRoute is the kernel's IPv4 routing table.
+var procNetRoute = "/proc/net/route"
+
+// Leak check results.
+const (
+	LeakPassed  = "passed"  // the tunnel exits from a different address
+	LeakFailed  = "failed"  // the same address, or the tunnel's couldn't be fetched
+	LeakUnknown = "unknown" // the tunnel answered but there was no direct address to compare
+)
+
+// LeakCheck is the outcome of comparing the public IP address seen through
+// the tunnel with the one seen over the default route. It fails when they
+// are the same, or when the tunnel's can't be fetched. When the direct fetch
+// fails it is unknown: OK stays false, but it doesn't hold downloads unless
+// vpn.leak_check_strict is set, as nothing can leave over that route either.
+type LeakCheck struct {
+	Time        time.Time `json:"time"`
+	Interface   string    `json:"interface"`
+	TunnelIP    string    `json:"tunnel_ip,omitempty"`
+	DirectIP    string    `json:"direct_ip,omitempty"`
+	DirectError string    `json:"direct_error,omitempty"` // why there's no direct IP to compare, e.g. the kill switch
+	Result      string    `json:"result"`
+	OK          bool      `json:"ok"` // Result is LeakPassed
+	Error       string    `json:"error,omitempty"`
+}
+
+// verifyExit fetches the public IP from url through tunnel and, if direct
+// isn't nil, over the default route, and compares them.
+func verifyExit(ctx context.Context, tunnel, direct *http.Client, url string) LeakCheck {
+	res := LeakCheck{Time: time.Now()}
+
+	var wg sync.WaitGroup
+	var directIP net.IP
+	var directErr error
+	if direct != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			directIP, directErr = publicIP(ctx, direct, url)
+		}()
+	}
+	tunnelIP, err := publicIP(ctx, tunnel, url)
+	wg.Wait()
+
+	if tunnelIP != nil {
+		res.TunnelIP = tunnelIP.String()
+	}
+	if directIP != nil {
+		res.DirectIP = directIP.String()
+	}
+	if directErr != nil {
+		res.DirectError = directErr.Error()
+	}
+
+	switch {
+	case err != nil:
+		res.Result = LeakFailed
+		res.Error = fmt.Sprintf("fetching the public IP through the tunnel: %v", err)
+	case directIP == nil:
+		res.Result = LeakUnknown
+	case directIP.Equal(tunnelIP):
+		res.Result = LeakFailed
+		res.Error = fmt.Sprintf("the tunnel exits from %s, the same address as the default route", tunnelIP)
+	default:
+		res.Result = LeakPassed
+		res.OK = true
+	}
+	return res
+}
+
+// publicIP fetches url, an echo endpoint such as https://api.ipify.org, and
+// returns the address in its reply: plain text, or JSON with an "ip" field.
+func publicIP(ctx context.Context, client *http.Client, url string) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(text, "{") {
+		var v struct {
+			IP string `json:"ip"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("reading the reply: %w", err)
+		}
+		text = v.IP
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil, fmt.Errorf("reply isn't an IP address: %.40q", text)
+	}
+	return ip, nil
+}
+
+// defaultRouteInterface returns the interface of the main routing table's
+// IPv4 default route, other than the tunnel's own. Full-tunnel setups such
+// as ours leave it in place and route around it, so binding to it still
+// leaves over the ISP.
+func defaultRouteInterface(tunnel string) (string, error) {
+	f, err := os.Open(procNetRoute)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return parseDefaultRoute(f, tunnel)
+}
+
+// parseDefaultRoute reads /proc/net/route and returns the interface of the
+// default route with the lowest metric, skipping exclude.
+func parseDefaultRoute(r io.Reader, exclude string) (string, error) {
+	const rtfUp = 0x1
+
+	best, bestMetric := "", -1
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		f := strings.Fields(sc.Text())
+		if len(f) < 8 || f[1] != "00000000" || f[7] != "00000000" || f[0] == exclude {
+			continue
+		}
+		flags, err := strconv.ParseUint(f[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+		metric, err := strconv.Atoi(f[6])
+		if err != nil {
+			continue
+		}
+		if bestMetric < 0 || metric < bestMetric {
+			best, bestMetric = f[0], metric
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	if best == "" {
+		return "", errors.New("no default route outside the tunnel")
+	}
+	return best, nil
+}
+
+// leakCheckPasses runs the public IP check for the tunnel on ifName, if
+// vpn.leak_check_url is set, and records the result. A failed check keeps
+// downloads paused, and so does an unknown one with vpn.leak_check_strict.
+func (m *Manager) leakCheckPasses(ifName string) bool {
+	vpnCfg := m.cfg.GetVPN()
+	url := vpnCfg.LeakCheckURL
+	if url == "" {
+		return true
+	}
+
+	m.mu.RLock()
+	conn := m.connector
+	m.mu.RUnlock()
+	tunnel := NewHTTPClientWith(m.tunnelDialer(conn, func() string { return ifName }))
+
+	var direct *http.Client
+	dev, routeErr := defaultRouteInterface(ifName)
+	if routeErr == nil {
+		direct = NewHTTPClient(dev)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, leakCheckTimeout)
+	defer cancel()
+	res := verifyExit(ctx, tunnel, direct, url)
+	res.Interface = ifName
+	if routeErr != nil {
+		res.DirectError = routeErr.Error()
+	}
+
+	switch res.Result {
+	case LeakFailed:
+		log.Printf("VPN leak check failed on %s: %s — downloads stay paused", ifName, res.Error)
+	case LeakUnknown:
+		held := ""
+		if vpnCfg.LeakCheckStrict {
+			held = " — downloads stay paused (leak_check_strict)"
+		}
+		log.Printf("VPN leak check unknown: tunnel exits from %s (no direct address to compare: %s)%s", res.TunnelIP, res.DirectError, held)
+	default:
+		log.Printf("VPN leak check passed: tunnel exits from %s, default route from %s", res.TunnelIP, res.DirectIP)
+	}
+
+	m.leakMu.Lock()
+	m.leakChecks = append(m.leakChecks, res)
+	if len(m.leakChecks) > leakCheckHistory {
+		m.leakChecks = m.leakChecks[len(m.leakChecks)-leakCheckHistory:]
+	}
+	m.leakMu.Unlock()
+	if res.Result == LeakUnknown {
+		return !vpnCfg.LeakCheckStrict
+	}
+	return res.Result != LeakFailed
+}
+
+// LeakChecks returns the latest public IP checks, oldest first.
+func (m *Manager) LeakChecks() []LeakCheck {
+	m.leakMu.Lock()
+	defer m.leakMu.Unlock()
+	return append([]LeakCheck(nil), m.leakChecks...)
+}
+
+// up calls the onUp callback for the tunnel on ifName, watched by mon. With
+// vpn.leak_check_url set, it first checks the public IP in the background;
+// until that passes downloads stay paused, and the check is retried while
+// the tunnel stays up.
+func (m *Manager) up(mon *Monitor, ifName string) {
+	if m.cfg.GetVPN().LeakCheckURL == "" {
+		m.callOnUp(ifName)
+		return
+	}
+
+	m.leakMu.Lock()
+	m.leakGen++
+	gen := m.leakGen
+	m.leakMu.Unlock()
+	current := func() bool {
+		m.leakMu.Lock()
+		defer m.leakMu.Unlock()
+		return gen == m.leakGen && mon.IsUp()
+	}
+
+	ctx := m.ctx
+	go func() {
+		for {
+			passed := m.leakCheckPasses(ifName)
+			if !current() {
+				// Went down, or came up again with a check of its own
+				return
+			}
+			if passed {
+				m.callOnUp(ifName)
+				return
+			}
+			m.publish()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-mon.stopCh:
+				return
+			case <-time.After(leakCheckRetry):
+			}
+		}
+	}()
+}
+
+// callOnUp runs the onUp callback and publishes the new state.
+func (m *Manager) callOnUp(ifName string) {
+	m.mu.RLock()
+	fn := m.onUp
+	m.mu.RUnlock()
+	if fn != nil {
+		fn(ifName)
+	}
+	m.publish()
+}
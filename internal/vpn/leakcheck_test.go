@@ -0,0 +1,189 @@
+package vpn
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// echoServer stands in for an IP echo service, replying with the address
+// each route's client claims in X-Route-IP.
+func echoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.Header.Get("X-Route-IP")
+		switch {
+		case ip == "":
+			http.Error(w, "no route", http.StatusBadGateway)
+		case r.URL.Path == "/json":
+			w.Write([]byte(`{"ip": "` + ip + `"}`))
+		default:
+			w.Write([]byte(ip + "\n"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type routeTransport string
+
+func (rt routeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if rt != "" {
+		r.Header.Set("X-Route-IP", string(rt))
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func routeClient(ip string) *http.Client {
+	return &http.Client{Transport: routeTransport(ip)}
+}
+
+func TestVerifyExit(t *testing.T) {
+	srv := echoServer(t)
+	ctx := context.Background()
+
+	res := verifyExit(ctx, routeClient("198.51.100.7"), routeClient("203.0.113.50"), srv.URL)
+	if !res.OK || res.Result != LeakPassed || res.TunnelIP != "198.51.100.7" || res.DirectIP != "203.0.113.50" {
+		t.Errorf("different addresses: %+v", res)
+	}
+
+	res = verifyExit(ctx, routeClient("203.0.113.50"), routeClient("203.0.113.50"), srv.URL+"/json")
+	if res.OK || res.Result != LeakFailed || !strings.Contains(res.Error, "203.0.113.50") {
+		t.Errorf("same address: %+v", res)
+	}
+
+	res = verifyExit(ctx, routeClient(""), routeClient("203.0.113.50"), srv.URL)
+	if res.OK || res.Result != LeakFailed || res.TunnelIP != "" {
+		t.Errorf("tunnel fetch failing: %+v", res)
+	}
+
+	// With the direct route blocked, e.g. by the kill switch, there's nothing
+	// to compare with, so it's unknown rather than passed
+	res = verifyExit(ctx, routeClient("198.51.100.7"), routeClient(""), srv.URL)
+	if res.OK || res.Result != LeakUnknown || res.DirectError == "" || res.Error != "" {
+		t.Errorf("direct fetch failing: %+v", res)
+	}
+	if res := verifyExit(ctx, routeClient("198.51.100.7"), nil, srv.URL); res.OK || res.Result != LeakUnknown {
+		t.Errorf("no direct route: %+v", res)
+	}
+
+	if _, err := publicIP(ctx, routeClient("<html>"), srv.URL); err == nil {
+		t.Error("expected an error for a reply that isn't an address")
+	}
+}
+
+func TestParseDefaultRoute(t *testing.T) {
+	table := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+tun0	00000000	00000000	0001	0	0	0	00000080	0	0	0
+wg0	00000000	00000000	0001	0	0	0	00000000	0	0	0
+`
+	dev, err := parseDefaultRoute(strings.NewReader(table), "wg0")
+	if err != nil || dev != "eth0" {
+		t.Errorf("got %q, %v; want eth0", dev, err)
+	}
+	if _, err := parseDefaultRoute(strings.NewReader(table), "eth0"); err != nil {
+		t.Errorf("with eth0 excluded: %v", err)
+	}
+	if _, err := parseDefaultRoute(strings.NewReader("Iface\tDestination\n"), ""); err == nil {
+		t.Error("expected an error without a default route")
+	}
+}
+
+// fakeTunnel is a connector with no host interface that dials directly.
+type fakeTunnel struct {
+	fakeConnector
+}
+
+func (f *fakeTunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func TestManagerLeakCheckGatesOnUp(t *testing.T) {
+	var ip atomic.Value
+	ip.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := ip.Load().(string); s != "" {
+			w.Write([]byte(s))
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// No default route, so only the tunnel's address is fetched
+	procNetRoute = filepath.Join(t.TempDir(), "route")
+	os.WriteFile(procNetRoute, nil, 0o644)
+	defer func() { procNetRoute = "/proc/net/route" }()
+
+	cfg := &config.Config{}
+	cfg.SetVPN(config.VPNConfig{LeakCheckURL: srv.URL})
+	m := NewManager(cfg)
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	defer m.cancel()
+	m.connector = &fakeTunnel{}
+	ups := make(chan string, 2)
+	m.OnUp(func(iface string) { ups <- iface })
+
+	mon := newTunnelMonitor(userspaceInterface, func() bool { return true })
+	defer mon.Stop()
+	mon.OnUp(func() { m.up(mon, userspaceInterface) })
+	mon.checkInterface()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.LeakChecks()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if checks := m.LeakChecks(); len(checks) != 1 || checks[0].Result != LeakFailed {
+		t.Fatalf("leak checks = %+v", checks)
+	}
+	select {
+	case <-ups:
+		t.Fatal("onUp called although the leak check failed")
+	default:
+	}
+
+	// The next time the tunnel answers; with no direct address to compare
+	// the result is unknown, which doesn't hold downloads
+	ip.Store("198.51.100.7")
+	m.up(mon, userspaceInterface)
+	select {
+	case iface := <-ups:
+		if iface != userspaceInterface {
+			t.Errorf("onUp(%q)", iface)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onUp not called after the leak check passed")
+	}
+	if checks := m.LeakChecks(); len(checks) != 2 || checks[1].Result != LeakUnknown || checks[1].OK || checks[1].TunnelIP != "198.51.100.7" {
+		t.Errorf("leak checks = %+v", checks)
+	}
+
+	// leak_check_strict holds downloads on an unknown result as well
+	cfg.SetVPN(config.VPNConfig{LeakCheckURL: srv.URL, LeakCheckStrict: true})
+	m.up(mon, userspaceInterface)
+	deadline = time.Now().Add(5 * time.Second)
+	for len(m.LeakChecks()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if checks := m.LeakChecks(); len(checks) != 3 || checks[2].Result != LeakUnknown {
+		t.Fatalf("leak checks = %+v", checks)
+	}
+	select {
+	case <-ups:
+		t.Error("onUp called on an unknown result with leak_check_strict")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
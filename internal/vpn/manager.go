@@ -2,11 +2,15 @@ package vpn
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"nzb-connect/internal/config"
+	"nzb-connect/internal/events"
 )
 
 // Manager orchestrates VPN connectivity. In managed mode it uses a Connector
@@ -22,15 +26,38 @@ type Manager struct {
 
 	onDown func()
 	onUp   func(interfaceName string)
+	events *events.Bus
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// Reconnection state
-	reconnecting  bool
-	reconnectMu   sync.Mutex
-	reconfigureMu sync.Mutex
+	// Profiles in priority order, the one in use and how many times in a
+	// row it has failed to connect. Empty in passive mode.
+	profiles []config.VPNProfile
+	active   int
+	failures int
+
+	// Reconnection state, with the attempts so far, oldest first, and when
+	// the next one is due while waiting
+	reconnecting    bool
+	reconnectCancel context.CancelFunc
+	reconnectDone   chan struct{}
+	reconnects      []ReconnectAttempt
+	nextReconnect   time.Time
+	reconnectMu     sync.Mutex
+	reconfigureMu   sync.Mutex
+
+	// Public IP checks, oldest first, and a counter that tells a retry loop
+	// the tunnel has come up again since it started
+	leakMu     sync.Mutex
+	leakChecks []LeakCheck
+	leakGen    int
+
+	// Kill switch in place, if ksMode isn't KillSwitchOff
+	ksMu   sync.Mutex
+	ksMode string
+	ks     killSwitch
 }
 
 // NewManager creates a Manager from the current config. If config specifies
@@ -56,32 +83,92 @@ func (m *Manager) OnUp(fn func(interfaceName string)) {
 	m.onUp = fn
 }
 
+// SetEvents sets the bus connection state changes are published to.
+func (m *Manager) SetEvents(bus *events.Bus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = bus
+}
+
+// publish sends the current connection state to the event bus, in the same
+// shape as /api/vpn/status.
+func (m *Manager) publish() {
+	m.mu.RLock()
+	bus := m.events
+	m.mu.RUnlock()
+	cs := m.ConnectorStatus()
+	bus.Publish(events.VPN, map[string]interface{}{
+		"state":          cs.State,
+		"interface_name": cs.InterfaceName,
+		"error":          cs.Error,
+		"managed":        m.IsManaged(),
+		"profile":        m.ActiveProfile(),
+	})
+}
+
 // Start initializes the manager based on current config.
 func (m *Manager) Start(ctx context.Context) {
 	m.ctx, m.cancel = context.WithCancel(ctx)
 
 	vpnCfg := m.cfg.GetVPN()
 
-	switch vpnCfg.Protocol {
-	case "wireguard":
-		if vpnCfg.WireGuard == nil {
-			log.Println("VPN protocol set to wireguard but no wireguard config found, falling back to passive mode")
-			m.startPassive(vpnCfg.Interface)
-			return
+	// Before connecting, so nothing leaks while the tunnel comes up
+	m.startKillSwitch(vpnCfg)
+
+	var profiles []config.VPNProfile
+	for _, p := range vpnCfg.ProfileList() {
+		if _, err := newConnector(p); err != nil {
+			log.Printf("VPN profile %q skipped: %v", p.Name, err)
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	if len(profiles) == 0 {
+		if vpnCfg.Protocol != "" || len(vpnCfg.Profiles) > 0 {
+			log.Println("No usable VPN profile, falling back to passive mode")
 		}
-		m.startManaged(NewWireGuardConnector(vpnCfg.WireGuard))
+		// Legacy/passive mode — just monitor an externally-managed interface
+		m.startPassive(vpnCfg.Interface)
+		return
+	}
 
-	case "openvpn":
-		if vpnCfg.OpenVPN == nil {
-			log.Println("VPN protocol set to openvpn but no openvpn config found, falling back to passive mode")
-			m.startPassive(vpnCfg.Interface)
-			return
+	active := 0
+	for i, p := range profiles {
+		if p.Name != "" && p.Name == vpnCfg.ActiveProfile {
+			active = i
 		}
-		m.startManaged(NewOpenVPNConnector(vpnCfg.OpenVPN))
+	}
+	conn, _ := newConnector(profiles[active])
+	m.mu.Lock()
+	m.profiles, m.active, m.failures = profiles, active, 0
+	m.mu.Unlock()
+	m.startManaged(conn)
+}
 
+// newConnector creates the connector for a profile.
+func newConnector(p config.VPNProfile) (Connector, error) {
+	switch p.Protocol {
+	case "wireguard":
+		if p.WireGuard == nil {
+			return nil, fmt.Errorf("protocol set to wireguard but no wireguard config found")
+		}
+		if p.WireGuard.Userspace && p.WireGuard.Namespace != "" {
+			return nil, fmt.Errorf("wireguard userspace and namespace can't be used together")
+		}
+		if p.WireGuard.Userspace {
+			return NewUserspaceWireGuardConnector(p.WireGuard), nil
+		}
+		if p.WireGuard.Namespace != "" {
+			return NewNamespaceWireGuardConnector(p.WireGuard), nil
+		}
+		return NewWireGuardConnector(p.WireGuard), nil
+	case "openvpn":
+		if p.OpenVPN == nil {
+			return nil, fmt.Errorf("protocol set to openvpn but no openvpn config found")
+		}
+		return NewOpenVPNConnector(p.OpenVPN), nil
 	default:
-		// Legacy/passive mode — just monitor an externally-managed interface
-		m.startPassive(vpnCfg.Interface)
+		return nil, fmt.Errorf("unknown protocol %q", p.Protocol)
 	}
 }
 
@@ -89,7 +176,13 @@ func (m *Manager) startPassive(interfaceName string) {
 	m.mu.Lock()
 	m.managed = false
 	m.connector = nil
-	m.monitor = NewMonitor(interfaceName)
+	m.profiles = nil
+	m.mu.Unlock()
+
+	mon := NewMonitor(interfaceName)
+	mon.health = m.newHealthChecker(mon, nil)
+	m.mu.Lock()
+	m.monitor = mon
 	m.mu.Unlock()
 
 	m.monitor.OnDown(func() {
@@ -99,14 +192,10 @@ func (m *Manager) startPassive(interfaceName string) {
 		if fn != nil {
 			fn()
 		}
+		m.publish()
 	})
 	m.monitor.OnUp(func() {
-		m.mu.RLock()
-		fn := m.onUp
-		m.mu.RUnlock()
-		if fn != nil {
-			fn(interfaceName)
-		}
+		m.up(mon, mon.InterfaceName())
 	})
 	m.monitor.Start()
 
@@ -133,6 +222,8 @@ func (m *Manager) startManaged(conn Connector) {
 	// Attempt initial connection
 	if err := conn.Connect(m.ctx); err != nil {
 		log.Printf("VPN initial connection failed: %v — will retry", err)
+		m.failover()
+		m.publish()
 		m.startReconnectLoop()
 		return
 	}
@@ -144,8 +235,27 @@ func (m *Manager) startManaged(conn Connector) {
 }
 
 func (m *Manager) startMonitorForManaged(ifName string) {
+	m.mu.RLock()
+	conn := m.connector
+	m.mu.RUnlock()
+
+	var mon *Monitor
+	if _, ok := conn.(TunnelDialer); ok {
+		// No host interface to watch; follow the connector instead
+		check := func() bool {
+			return conn.Status().State == StateConnected
+		}
+		if lc, ok := conn.(linkChecker); ok {
+			check = lc.linkUp
+		}
+		mon = newTunnelMonitor(ifName, check)
+	} else {
+		mon = NewMonitor(ifName)
+	}
+	mon.health = m.newHealthChecker(mon, conn)
+
 	m.mu.Lock()
-	m.monitor = NewMonitor(ifName)
+	m.monitor = mon
 	m.mu.Unlock()
 
 	m.monitor.OnDown(func() {
@@ -156,15 +266,13 @@ func (m *Manager) startMonitorForManaged(ifName string) {
 		if fn != nil {
 			fn()
 		}
+		m.publish()
 		m.startReconnectLoop()
 	})
 	m.monitor.OnUp(func() {
-		m.mu.RLock()
-		fn := m.onUp
-		m.mu.RUnlock()
-		if fn != nil {
-			fn(ifName)
-		}
+		// Now the interface number is known, allow only that one
+		m.narrowKillSwitch(ifName)
+		m.up(mon, ifName)
 	})
 	m.monitor.Start()
 	// Monitor's initial checkInterface() will fire onUp if the interface is already up.
@@ -176,7 +284,11 @@ func (m *Manager) startReconnectLoop() {
 		m.reconnectMu.Unlock()
 		return
 	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	done := make(chan struct{})
 	m.reconnecting = true
+	m.reconnectCancel = cancel
+	m.reconnectDone = done
 	m.reconnectMu.Unlock()
 
 	m.wg.Add(1)
@@ -185,62 +297,139 @@ func (m *Manager) startReconnectLoop() {
 		defer func() {
 			m.reconnectMu.Lock()
 			m.reconnecting = false
+			m.reconnectCancel = nil
+			m.reconnectDone = nil
 			m.reconnectMu.Unlock()
+			cancel()
+			close(done)
 		}()
 
-		const maxAttempts = 10
-		backoff := 5 * time.Second
-		const maxBackoff = 60 * time.Second
+		pol := m.reconnectPolicy()
+		backoff := pol.backoff
+		wait := pol.jittered(backoff)
 
-		for attempt := 1; attempt <= maxAttempts; attempt++ {
-			select {
-			case <-m.ctx.Done():
-				return
-			case <-time.After(backoff):
-			}
+		for round := 1; ; round++ {
+			for attempt := 1; pol.attempts == 0 || attempt <= pol.attempts; attempt++ {
+				if !m.waitReconnect(ctx, wait) {
+					return
+				}
 
-			log.Printf("VPN reconnect attempt %d/%d", attempt, maxAttempts)
+				if pol.attempts == 0 {
+					log.Printf("VPN reconnect attempt %d", attempt)
+				} else {
+					log.Printf("VPN reconnect attempt %d/%d", attempt, pol.attempts)
+				}
 
-			m.mu.RLock()
-			conn := m.connector
-			m.mu.RUnlock()
-			if conn == nil {
-				return
-			}
+				m.mu.RLock()
+				conn := m.connector
+				m.mu.RUnlock()
+				if conn == nil {
+					return
+				}
+				profile := m.ActiveProfile()
 
-			// Disconnect any stale state first
-			conn.Disconnect()
+				// Disconnect any stale state first
+				conn.Disconnect()
 
-			if err := conn.Connect(m.ctx); err != nil {
-				log.Printf("VPN reconnect failed: %v", err)
-				backoff = backoff * 2
-				if backoff > maxBackoff {
-					backoff = maxBackoff
+				err := conn.Connect(ctx)
+				if ctx.Err() != nil {
+					// Stopped, or connected or disconnected by hand
+					return
+				}
+				rec := ReconnectAttempt{
+					Time:    time.Now(),
+					Round:   round,
+					Attempt: attempt,
+					Of:      pol.attempts,
+					Profile: profile,
+					OK:      err == nil,
+				}
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				m.recordReconnect(rec)
+
+				if err != nil {
+					log.Printf("VPN reconnect failed: %v", err)
+					if m.failover() {
+						// A different server; start over with a short wait
+						backoff = pol.backoff
+					} else {
+						backoff = pol.next(backoff)
+					}
+					wait = pol.jittered(backoff)
+					continue
+				}
+				m.mu.Lock()
+				m.failures = 0
+				m.mu.Unlock()
+
+				ifName := conn.InterfaceName()
+				log.Printf("VPN reconnected, interface: %s", ifName)
+
+				// Stop old monitor if any
+				m.mu.RLock()
+				oldMon := m.monitor
+				m.mu.RUnlock()
+				if oldMon != nil {
+					oldMon.Stop()
 				}
-				continue
-			}
 
-			ifName := conn.InterfaceName()
-			log.Printf("VPN reconnected, interface: %s", ifName)
+				m.startMonitorForManaged(ifName)
+				return
+			}
 
-			// Stop old monitor if any
-			m.mu.RLock()
-			oldMon := m.monitor
-			m.mu.RUnlock()
-			if oldMon != nil {
-				oldMon.Stop()
+			if pol.cooldown == 0 {
+				log.Printf("VPN reconnect failed after %d attempts — giving up", pol.attempts)
+				m.publish()
+				return
 			}
+			wait = pol.jittered(pol.cooldown)
+			log.Printf("VPN reconnect failed after %d attempts — trying again in %s", pol.attempts, wait.Round(time.Second))
 
-			m.startMonitorForManaged(ifName)
-			return
+			// The next round follows the config as it is by then
+			pol = m.reconnectPolicy()
+			backoff = pol.backoff
 		}
-
-		log.Printf("VPN reconnect failed after %d attempts — giving up", maxAttempts)
 	}()
 }
 
-// Stop tears down the manager, disconnecting if in managed mode.
+// failover counts a failed connection attempt and, once the active profile
+// has failed vpn.failover_after times in a row, moves to the next profile.
+// It reports whether it did.
+func (m *Manager) failover() bool {
+	limit := m.cfg.GetVPN().FailoverAttempts()
+
+	m.mu.Lock()
+	m.failures++
+	if len(m.profiles) < 2 || m.failures < limit {
+		m.mu.Unlock()
+		return false
+	}
+	old := m.connector
+	from := m.profiles[m.active].Name
+	m.active = (m.active + 1) % len(m.profiles)
+	m.failures = 0
+	next := m.profiles[m.active]
+	conn, _ := newConnector(next)
+	m.connector = conn
+	m.mu.Unlock()
+
+	if old != nil {
+		old.Disconnect()
+	}
+	log.Printf("VPN profile %q failed %d times — switching to %q", from, limit, next.Name)
+	return true
+}
+
+// Stop tears down the manager, disconnecting if in managed mode, and lifts
+// the kill switch.
 func (m *Manager) Stop() {
+	m.stop()
+	m.liftKillSwitch()
+}
+
+func (m *Manager) stop() {
 	if m.cancel != nil {
 		m.cancel()
 	}
@@ -305,7 +494,13 @@ func (m *Manager) Connect() error {
 		return nil
 	}
 
+	m.stopReconnecting()
+	m.mu.RLock()
+	conn = m.connector // failover may have moved on
+	m.mu.RUnlock()
+
 	if err := conn.Connect(m.ctx); err != nil {
+		m.publish()
 		return err
 	}
 
@@ -335,11 +530,18 @@ func (m *Manager) Disconnect() error {
 		return nil
 	}
 
+	m.stopReconnecting()
+	m.mu.RLock()
+	conn = m.connector
+	m.mu.RUnlock()
+
 	if mon != nil {
 		mon.Stop()
 	}
 
-	return conn.Disconnect()
+	err := conn.Disconnect()
+	m.publish()
+	return err
 }
 
 // ConnectorStatus returns the status of the managed connector, or a
@@ -370,6 +572,112 @@ func (m *Manager) ConnectorStatus() ConnectorStatus {
 	return ConnectorStatus{State: StateDisconnected}
 }
 
+// Dialer returns the function connections through the tunnel must be opened
+// with when the tunnel is not a host interface (userspace WireGuard), or nil
+// when binding to InterfaceName is enough. It always dials through the
+// current connector, so it stays valid across reconnects.
+func (m *Manager) Dialer() func(ctx context.Context, network, address string) (net.Conn, error) {
+	m.mu.RLock()
+	_, ok := m.connector.(TunnelDialer)
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		m.mu.RLock()
+		td, ok := m.connector.(TunnelDialer)
+		m.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("VPN tunnel changed; not dialing %s outside it", address)
+		}
+		return td.DialContext(ctx, network, address)
+	}
+}
+
+// linkChecker is a TunnelDialer that can tell whether its tunnel's interface
+// is up, out of the host's sight.
+type linkChecker interface {
+	linkUp() bool
+}
+
+// dnsConnector is a Connector that knows the tunnel's DNS servers.
+type dnsConnector interface {
+	dnsServers() []string
+}
+
+// TunnelResolver returns a resolver that looks up news servers through the
+// tunnel on iface, or nil if vpn.tunnel_dns is off. Its DNS servers come from
+// vpn.dns, or else from the connector (the WireGuard DNS setting, or the
+// servers OpenVPN pushed). With none known, lookups fail rather than leak.
+func (m *Manager) TunnelResolver(iface string) *Resolver {
+	vpnCfg := m.cfg.GetVPN()
+	if !vpnCfg.TunnelDNS {
+		return nil
+	}
+	servers := m.tunnelDNSServers(vpnCfg)
+	var r *Resolver
+	if dial := m.Dialer(); dial != nil {
+		r = newResolverWith(dial, servers)
+	} else {
+		r = NewResolver(iface, servers)
+	}
+	if len(r.Servers()) == 0 {
+		log.Println("WARNING: tunnel_dns is on but no tunnel DNS server is known (set vpn.dns) — news server names won't resolve")
+	} else {
+		log.Printf("Resolving news servers through %s via %s", iface, strings.Join(r.Servers(), ", "))
+	}
+	return r
+}
+
+// Health returns the latest result of each health probe, or nil without
+// any.
+func (m *Manager) Health() []ProbeResult {
+	m.mu.RLock()
+	mon := m.monitor
+	m.mu.RUnlock()
+	if mon == nil {
+		return nil
+	}
+	return mon.Health()
+}
+
+// tunnelDNSServers returns the DNS servers to use through the tunnel:
+// vpn.dns, or else the ones the connector knows of.
+func (m *Manager) tunnelDNSServers(vpnCfg config.VPNConfig) []string {
+	if strings.TrimSpace(vpnCfg.DNS) != "" {
+		return strings.Split(vpnCfg.DNS, ",")
+	}
+	m.mu.RLock()
+	conn := m.connector
+	m.mu.RUnlock()
+	if dc, ok := conn.(dnsConnector); ok {
+		return dc.dnsServers()
+	}
+	return nil
+}
+
+// ActiveProfile returns the name of the profile in use, or "" in passive
+// mode.
+func (m *Manager) ActiveProfile() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.profiles) == 0 {
+		return ""
+	}
+	return m.profiles[m.active].Name
+}
+
+// Profiles returns the names of the usable profiles, in priority order.
+func (m *Manager) Profiles() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, len(m.profiles))
+	for i, p := range m.profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
 // IsManaged returns true if the manager is in managed mode (owns VPN connection).
 func (m *Manager) IsManaged() bool {
 	m.mu.RLock()
@@ -383,7 +691,8 @@ func (m *Manager) Reconfigure() {
 	m.reconfigureMu.Lock()
 	defer m.reconfigureMu.Unlock()
 
-	m.Stop()
+	// The kill switch stays up in between; Start replaces or removes it
+	m.stop()
 	m.wg = sync.WaitGroup{}
 	m.Start(context.Background())
 }
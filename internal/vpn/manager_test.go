@@ -0,0 +1,54 @@
+package vpn
+
+import (
+	"context"
+	"testing"
+
+	"nzb-connect/internal/config"
+)
+
+type fakeConnector struct{ disconnected bool }
+
+func (f *fakeConnector) Connect(ctx context.Context) error { return nil }
+func (f *fakeConnector) Disconnect() error                 { f.disconnected = true; return nil }
+func (f *fakeConnector) Status() ConnectorStatus           { return ConnectorStatus{State: StateDisconnected} }
+func (f *fakeConnector) InterfaceName() string             { return "" }
+
+func TestManagerFailover(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.SetVPN(config.VPNConfig{FailoverAfter: 2})
+	first := &fakeConnector{}
+	m := NewManager(cfg)
+	m.connector = first
+	m.profiles = []config.VPNProfile{
+		{Name: "home", Protocol: "openvpn", OpenVPN: &config.OpenVPNConfig{RemoteHost: "203.0.113.9"}},
+		{Name: "away", Protocol: "wireguard", WireGuard: &config.WireGuardConfig{PeerEndpoint: "198.51.100.7:51820"}},
+	}
+
+	if m.failover() {
+		t.Fatal("switched after a single failure")
+	}
+	if !m.failover() {
+		t.Fatal("didn't switch after failover_after failures")
+	}
+	if m.ActiveProfile() != "away" || !first.disconnected {
+		t.Errorf("active = %q, old connector disconnected = %v", m.ActiveProfile(), first.disconnected)
+	}
+	if _, ok := m.connector.(*WireGuardConnector); !ok {
+		t.Errorf("connector = %T, want the away profile's", m.connector)
+	}
+
+	// Rotation wraps around to the first profile
+	m.failover()
+	m.failover()
+	if m.ActiveProfile() != "home" {
+		t.Errorf("active = %q after wrapping", m.ActiveProfile())
+	}
+
+	m.profiles = m.profiles[:1]
+	for i := 0; i < 5; i++ {
+		if m.failover() {
+			t.Fatal("switched with a single profile")
+		}
+	}
+}
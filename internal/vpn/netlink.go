@@ -0,0 +1,107 @@
+package vpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// rtnetlink multicast groups, from linux/rtnetlink.h; syscall lacks them.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// watchLinks subscribes to rtnetlink link and address notifications and
+// calls notify with each message about a link or address change, until stop
+// is closed. The returned channel receives an error, if reading fails, once
+// the subscription has ended for good.
+func watchLinks(stop <-chan struct{}, notify func(msg syscall.NetlinkMessage)) (<-chan error, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+
+	// Non-blocking, so reads go through the runtime poller and Close
+	// interrupts them
+	f := os.NewFile(uintptr(fd), "rtnetlink")
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1<<16)
+		for {
+			n, err := f.Read(buf)
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			if errors.Is(err, syscall.ENOBUFS) {
+				// The kernel dropped notifications; have the state looked at
+				// afresh
+				notify(syscall.NetlinkMessage{})
+				continue
+			}
+			if err != nil {
+				f.Close()
+				errc <- fmt.Errorf("netlink read: %w", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, msg := range msgs {
+				switch msg.Header.Type {
+				case syscall.RTM_NEWLINK, syscall.RTM_DELLINK, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+					notify(msg)
+				}
+			}
+		}
+	}()
+	return errc, nil
+}
+
+// linkMessageAbout reports whether a link or address notification concerns
+// the interface called name. A message it can't tell about, such as the
+// empty one sent after notifications were dropped, counts as concerning it.
+func linkMessageAbout(msg syscall.NetlinkMessage, name string) bool {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+		if err != nil {
+			return true
+		}
+		for _, a := range attrs {
+			if a.Attr.Type == syscall.IFLA_IFNAME {
+				return string(bytes.TrimRight(a.Value, "\x00")) == name
+			}
+		}
+		return true
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(msg.Data) < syscall.SizeofIfAddrmsg {
+			return true
+		}
+		// struct ifaddrmsg: family, prefixlen, flags, scope, then the index
+		index := binary.NativeEndian.Uint32(msg.Data[4:8])
+		iface, err := net.InterfaceByName(name)
+		// Gone already: let the check find out
+		return err != nil || uint32(iface.Index) == index
+
+	default:
+		return true
+	}
+}
@@ -0,0 +1,95 @@
+package vpn
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// linkMessage builds an RTM_NEWLINK/RTM_DELLINK notification for name.
+func linkMessage(typ uint16, name string) syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfInfomsg)
+	attr := make([]byte, syscall.SizeofRtAttr+len(name)+1)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(len(attr)))
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.IFLA_IFNAME)
+	copy(attr[syscall.SizeofRtAttr:], name)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: append(data, attr...)}
+}
+
+// addrMessage builds an RTM_NEWADDR/RTM_DELADDR notification for the
+// interface with the given index.
+func addrMessage(typ uint16, index int) syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfAddrmsg)
+	binary.NativeEndian.PutUint32(data[4:8], uint32(index))
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ}, Data: data}
+}
+
+func TestLinkMessageAbout(t *testing.T) {
+	if !linkMessageAbout(linkMessage(syscall.RTM_DELLINK, "wg0"), "wg0") {
+		t.Error("DELLINK for wg0 not matched")
+	}
+	if linkMessageAbout(linkMessage(syscall.RTM_NEWLINK, "veth1234"), "wg0") {
+		t.Error("NEWLINK for another interface matched")
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	if !linkMessageAbout(addrMessage(syscall.RTM_DELADDR, lo.Index), "lo") {
+		t.Error("DELADDR for lo not matched")
+	}
+	if linkMessageAbout(addrMessage(syscall.RTM_DELADDR, lo.Index+1000), "lo") {
+		t.Error("DELADDR for another interface matched")
+	}
+	if !linkMessageAbout(addrMessage(syscall.RTM_DELADDR, lo.Index), "wg_no_such_iface") {
+		t.Error("an interface that's gone should always be checked")
+	}
+
+	// Sent after the kernel dropped notifications
+	if !linkMessageAbout(syscall.NetlinkMessage{}, "wg0") {
+		t.Error("an empty message should prompt a check")
+	}
+}
+
+func TestMonitorDebounce(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	downs := make(chan struct{}, 4)
+	m := newTunnelMonitor(userspaceInterface, up.Load)
+	m.debounce = 100 * time.Millisecond
+	m.OnDown(func() { downs <- struct{}{} })
+	m.Start()
+	defer m.Stop()
+
+	// A flap shorter than the debounce period goes unnoticed
+	up.Store(false)
+	m.poke()
+	time.Sleep(20 * time.Millisecond)
+	up.Store(true)
+	m.poke()
+	select {
+	case <-downs:
+		t.Fatal("brief flap reported as down")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// A real drop is acted on without waiting for the next poll
+	up.Store(false)
+	start := time.Now()
+	m.poke()
+	select {
+	case <-downs:
+		if waited := time.Since(start); waited < m.debounce {
+			t.Errorf("down after %v, before the debounce period", waited)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drop not reported")
+	}
+}
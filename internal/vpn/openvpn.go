@@ -21,6 +21,7 @@ type OpenVPNConnector struct {
 	mu     sync.RWMutex
 	status ConnectorStatus
 	ifName string
+	dns    []string // DNS servers pushed by the server
 
 	cmd       *exec.Cmd
 	cancel    context.CancelFunc
@@ -99,6 +100,15 @@ func (o *OpenVPNConnector) Connect(ctx context.Context) error {
 				}
 			}
 
+			// e.g. "PUSH: Received control message: 'PUSH_REPLY,...,dhcp-option DNS 10.8.0.1,...'"
+			if strings.Contains(line, "PUSH_REPLY") {
+				if dns := parsePushedDNS(line); len(dns) > 0 {
+					o.mu.Lock()
+					o.dns = dns
+					o.mu.Unlock()
+				}
+			}
+
 			// Detect auth failure
 			if strings.Contains(line, "AUTH_FAILED") {
 				select {
@@ -171,6 +181,7 @@ func (o *OpenVPNConnector) Disconnect() error {
 
 	o.mu.Lock()
 	o.ifName = ""
+	o.dns = nil
 	o.status = ConnectorStatus{State: StateDisconnected}
 	o.mu.Unlock()
 
@@ -191,14 +202,48 @@ func (o *OpenVPNConnector) InterfaceName() string {
 	return o.ifName
 }
 
+// dnsServers returns the DNS servers the server pushed.
+func (o *OpenVPNConnector) dnsServers() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.dns
+}
+
+// parsePushedDNS returns the "dhcp-option DNS" servers in a PUSH_REPLY line.
+func parsePushedDNS(line string) []string {
+	_, reply, ok := strings.Cut(line, "PUSH_REPLY")
+	if !ok {
+		return nil
+	}
+	var servers []string
+	for _, opt := range strings.Split(strings.Trim(reply, `'"`), ",") {
+		if f := strings.Fields(opt); len(f) == 3 && f[0] == "dhcp-option" && f[1] == "DNS" {
+			servers = append(servers, f[2])
+		}
+	}
+	return servers
+}
+
 func (o *OpenVPNConnector) buildArgs() ([]string, []string, error) {
 	var args []string
 	var tempFiles []string
 
 	args = append(args, "--client", "--nobind")
 
+	// Directives without a field of their own, such as from an imported
+	// .ovpn. They come first, so the settings below take precedence.
+	if o.cfg.Extra != "" {
+		extraFile, err := o.writeTempFile("ovpn-extra-*", o.cfg.Extra)
+		if err != nil {
+			return nil, nil, fmt.Errorf("write extra config: %w", err)
+		}
+		tempFiles = append(tempFiles, extraFile)
+		args = append(args, "--config", extraFile)
+	}
+
 	// Remote
 	if o.cfg.RemoteHost == "" {
+		o.cleanupFiles(tempFiles)
 		return nil, nil, fmt.Errorf("remote_host is required")
 	}
 	port := o.cfg.RemotePort
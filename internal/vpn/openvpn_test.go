@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -109,3 +110,38 @@ sleep 10
 	}
 	t.Logf("correctly received error: %v", err)
 }
+
+func TestParsePushedDNS(t *testing.T) {
+	line := "PUSH: Received control message: 'PUSH_REPLY,redirect-gateway def1,dhcp-option DNS 10.8.0.1,dhcp-option DOMAIN vpn,dhcp-option DNS 10.8.0.2,route-gateway 10.8.0.1'"
+	got := parsePushedDNS(line)
+	if len(got) != 2 || got[0] != "10.8.0.1" || got[1] != "10.8.0.2" {
+		t.Errorf("got %v, want [10.8.0.1 10.8.0.2]", got)
+	}
+	if got := parsePushedDNS("Initialization Sequence Completed"); got != nil {
+		t.Errorf("got %v for a line without PUSH_REPLY", got)
+	}
+}
+
+func TestBuildArgsPassesExtra(t *testing.T) {
+	o := NewOpenVPNConnector(&config.OpenVPNConfig{
+		RemoteHost: "203.0.113.9",
+		Extra:      "remote-cert-tls server\n",
+	})
+	args, files, err := o.buildArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.cleanupFiles(files)
+
+	i := slices.Index(args, "--config")
+	if i < 0 || i+1 >= len(args) {
+		t.Fatalf("no --config in %v", args)
+	}
+	data, err := os.ReadFile(args[i+1])
+	if err != nil || string(data) != "remote-cert-tls server\n" {
+		t.Errorf("extra config file: %q, %v", data, err)
+	}
+	if i > slices.Index(args, "--remote") {
+		t.Error("extra directives must come before the ones set from fields")
+	}
+}
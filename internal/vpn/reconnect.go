@@ -0,0 +1,155 @@
+package vpn
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+const (
+	defaultReconnectAttempts   = 10
+	defaultReconnectBackoff    = 5 * time.Second
+	defaultReconnectMaxBackoff = 60 * time.Second
+	reconnectHistory           = 50
+)
+
+// ReconnectAttempt is one try at bringing back a tunnel that dropped.
+type ReconnectAttempt struct {
+	Time    time.Time `json:"time"`
+	Round   int       `json:"round"` // a new round starts after each cooldown
+	Attempt int       `json:"attempt"`
+	Of      int       `json:"of,omitempty"` // attempts per round; 0 when unlimited
+	Profile string    `json:"profile,omitempty"`
+	OK      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// reconnectPolicy is vpn.reconnect with the defaults filled in.
+type reconnectPolicy struct {
+	attempts   int // per round; 0 for unlimited
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	cooldown   time.Duration // 0: give up after one round
+}
+
+// newReconnectPolicy fills in the defaults in rc. A round has room for every
+// one of profiles to fail failoverAfter times, so each gets its turn; a lower
+// max_attempts is raised to that, with a log message.
+func newReconnectPolicy(rc config.ReconnectConfig, failoverAfter, profiles int) reconnectPolicy {
+	p := reconnectPolicy{
+		attempts:   rc.MaxAttempts,
+		backoff:    time.Duration(rc.InitialBackoff) * time.Second,
+		maxBackoff: time.Duration(rc.MaxBackoff) * time.Second,
+		jitter:     min(max(rc.Jitter, 0), 1),
+		cooldown:   max(time.Duration(rc.Cooldown)*time.Second, 0),
+	}
+	switch {
+	case p.attempts < 0:
+		p.attempts = 0
+	case p.attempts == 0:
+		p.attempts = defaultReconnectAttempts
+		fallthrough
+	default:
+		if floor := failoverAfter * profiles; p.attempts < floor {
+			if rc.MaxAttempts > 0 {
+				log.Printf("VPN reconnect: raising max_attempts from %d to %d so each of %d profiles can fail %d times", rc.MaxAttempts, floor, profiles, failoverAfter)
+			}
+			p.attempts = floor
+		}
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultReconnectBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultReconnectMaxBackoff
+	}
+	p.maxBackoff = max(p.maxBackoff, p.backoff)
+	return p
+}
+
+// next returns the wait after one of d: twice as long, up to the cap.
+func (p reconnectPolicy) next(d time.Duration) time.Duration {
+	return min(d*2, p.maxBackoff)
+}
+
+// jittered returns d with up to the jitter fraction of it added or taken
+// off at random, so instances that lost the tunnel together don't all
+// retry in step.
+func (p reconnectPolicy) jittered(d time.Duration) time.Duration {
+	if p.jitter == 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*p.jitter*float64(d))
+}
+
+// reconnectPolicy returns the policy in the current config.
+func (m *Manager) reconnectPolicy() reconnectPolicy {
+	vpnCfg := m.cfg.GetVPN()
+	m.mu.RLock()
+	profiles := len(m.profiles)
+	m.mu.RUnlock()
+	return newReconnectPolicy(vpnCfg.Reconnect, vpnCfg.FailoverAttempts(), profiles)
+}
+
+// waitReconnect waits d before the next attempt, publishing when it is due,
+// and reports false if ctx was canceled in the meantime.
+func (m *Manager) waitReconnect(ctx context.Context, d time.Duration) bool {
+	m.reconnectMu.Lock()
+	m.nextReconnect = time.Now().Add(d)
+	m.reconnectMu.Unlock()
+	m.publish()
+
+	defer func() {
+		m.reconnectMu.Lock()
+		m.nextReconnect = time.Time{}
+		m.reconnectMu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stopReconnecting ends the reconnect loop, if one is running, and waits for
+// it to exit, so a tunnel connected or disconnected by hand stays that way.
+func (m *Manager) stopReconnecting() {
+	m.reconnectMu.Lock()
+	cancel, done := m.reconnectCancel, m.reconnectDone
+	m.reconnectMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// NextReconnect returns when the next reconnect attempt is due, or the zero
+// time if none is waiting.
+func (m *Manager) NextReconnect() time.Time {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
+	return m.nextReconnect
+}
+
+// recordReconnect adds a to the reconnect history.
+func (m *Manager) recordReconnect(a ReconnectAttempt) {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
+	m.reconnects = append(m.reconnects, a)
+	if len(m.reconnects) > reconnectHistory {
+		m.reconnects = m.reconnects[len(m.reconnects)-reconnectHistory:]
+	}
+}
+
+// Reconnects returns the latest reconnect attempts, oldest first.
+func (m *Manager) Reconnects() []ReconnectAttempt {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
+	return append([]ReconnectAttempt(nil), m.reconnects...)
+}
@@ -0,0 +1,108 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+func TestNewReconnectPolicy(t *testing.T) {
+	p := newReconnectPolicy(config.ReconnectConfig{}, 3, 1)
+	if p.attempts != 10 || p.backoff != 5*time.Second || p.maxBackoff != 60*time.Second || p.jitter != 0 || p.cooldown != 0 {
+		t.Errorf("defaults = %+v", p)
+	}
+
+	// Every profile gets its turn, whether the attempts are set or not
+	if p := newReconnectPolicy(config.ReconnectConfig{}, 3, 5); p.attempts != 15 {
+		t.Errorf("attempts = %d with 5 profiles, want 15", p.attempts)
+	}
+	if p := newReconnectPolicy(config.ReconnectConfig{MaxAttempts: 4}, 3, 2); p.attempts != 6 {
+		t.Errorf("attempts = %d with 2 profiles, want 6", p.attempts)
+	}
+	if p := newReconnectPolicy(config.ReconnectConfig{MaxAttempts: -1}, 3, 2); p.attempts != 0 {
+		t.Errorf("attempts = %d, want 0 for unlimited", p.attempts)
+	}
+
+	p = newReconnectPolicy(config.ReconnectConfig{InitialBackoff: 90, MaxBackoff: 30, Jitter: 3, Cooldown: -5}, 3, 1)
+	if p.maxBackoff != 90*time.Second || p.jitter != 1 || p.cooldown != 0 {
+		t.Errorf("out of range values kept: %+v", p)
+	}
+
+	p = newReconnectPolicy(config.ReconnectConfig{InitialBackoff: 10, MaxBackoff: 30}, 3, 1)
+	var waits []time.Duration
+	for d := p.backoff; len(waits) < 4; d = p.next(d) {
+		waits = append(waits, d)
+	}
+	if want := []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}; !slices.Equal(waits, want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+}
+
+func TestReconnectJitter(t *testing.T) {
+	p := reconnectPolicy{jitter: 0.25}
+	for i := 0; i < 1000; i++ {
+		if d := p.jittered(40 * time.Second); d < 30*time.Second || d > 50*time.Second {
+			t.Fatalf("jittered(40s) = %v, outside ±25%%", d)
+		}
+	}
+}
+
+type failingConnector struct{ fakeConnector }
+
+func (f *failingConnector) Connect(ctx context.Context) error {
+	return errors.New("peer unreachable")
+}
+
+// TestReconnectUnlimited checks that attempts are recorded and that an
+// unlimited loop ends when the tunnel is disconnected by hand.
+func TestReconnectUnlimited(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.SetVPN(config.VPNConfig{Reconnect: config.ReconnectConfig{MaxAttempts: -1, InitialBackoff: 1}})
+	m := NewManager(cfg)
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	defer m.cancel()
+	m.managed = true
+	m.connector = &failingConnector{}
+	m.profiles = []config.VPNProfile{{Name: "home", Protocol: "wireguard"}}
+
+	m.startReconnectLoop()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.NextReconnect().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.NextReconnect().IsZero() {
+		t.Fatal("no attempt scheduled")
+	}
+
+	for len(m.Reconnects()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	recs := m.Reconnects()
+	if len(recs) == 0 {
+		t.Fatal("no attempt recorded")
+	}
+	if r := recs[0]; r.OK || r.Error != "peer unreachable" || r.Attempt != 1 || r.Round != 1 || r.Of != 0 || r.Profile != "home" {
+		t.Errorf("attempt = %+v", r)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect didn't stop the reconnect loop")
+	}
+	m.reconnectMu.Lock()
+	reconnecting := m.reconnecting
+	m.reconnectMu.Unlock()
+	if reconnecting {
+		t.Error("still reconnecting after Disconnect")
+	}
+}
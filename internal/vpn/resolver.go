@@ -0,0 +1,153 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resolverCacheTTL is how long resolved addresses are reused.
+const resolverCacheTTL = 10 * time.Minute
+
+// errNoTunnelDNS is returned when tunnel DNS is enabled but no server is
+// known. Lookups fail rather than fall back to the system resolver.
+var errNoTunnelDNS = errors.New("no DNS server for the tunnel")
+
+// Resolver resolves host names by querying DNS servers over the VPN
+// interface, so that lookups for news servers don't leave outside the tunnel
+// the way the system resolver's would. Addresses are cached per host.
+type Resolver struct {
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	servers  []string // host:port
+	next     atomic.Uint32
+	resolver *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]cachedAddrs
+}
+
+type cachedAddrs struct {
+	addrs   []string
+	expires time.Time
+}
+
+// NewResolver creates a resolver that sends queries to servers (IP addresses,
+// optionally with a port) through iface.
+func NewResolver(iface string, servers []string) *Resolver {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if iface != "" {
+		dialer = BindToInterface(iface)
+	}
+	return newResolverWith(dialer.DialContext, servers)
+}
+
+// newResolverWith creates a resolver that opens its connections, to the DNS
+// servers and in DialContext, with dial.
+func newResolverWith(dial func(ctx context.Context, network, address string) (net.Conn, error), servers []string) *Resolver {
+	r := &Resolver{dial: dial, cache: make(map[string]cachedAddrs)}
+	for _, s := range servers {
+		if addr := dnsServerAddr(s); addr != "" {
+			r.servers = append(r.servers, addr)
+		} else if s != "" {
+			log.Printf("Ignoring invalid tunnel DNS server %q", s)
+		}
+	}
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		// The Go resolver asks for the nameservers in resolv.conf; ours are
+		// used instead, rotating so retries reach the next one.
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := r.servers[int(r.next.Add(1)-1)%len(r.servers)]
+			return r.dial(ctx, network, server)
+		},
+	}
+	return r
+}
+
+// dnsServerAddr returns s as host:port, defaulting to port 53, or "" if s is
+// not an IP address.
+func dnsServerAddr(s string) string {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(strings.Trim(s, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53")
+	}
+	if host, port, err := net.SplitHostPort(s); err == nil && net.ParseIP(host) != nil {
+		return net.JoinHostPort(host, port)
+	}
+	return ""
+}
+
+// Servers returns the DNS servers the resolver queries.
+func (r *Resolver) Servers() []string {
+	return append([]string(nil), r.servers...)
+}
+
+// LookupHost returns the addresses of host, from the cache if it has them.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	r.mu.Lock()
+	c, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.addrs, nil
+	}
+
+	if len(r.servers) == 0 {
+		return nil, fmt.Errorf("resolving %s: %w", host, errNoTunnelDNS)
+	}
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s through the tunnel: %w", host, err)
+	}
+	r.mu.Lock()
+	r.cache[host] = cachedAddrs{addrs: addrs, expires: time.Now().Add(resolverCacheTTL)}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+// forget drops host from the cache, so the next dial looks it up again.
+func (r *Resolver) forget(host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, host)
+}
+
+// DialContext resolves address with LookupHost and connects to the first
+// address that answers, through the tunnel.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolving %s: no addresses", host)
+	}
+	var firstErr error
+	for _, a := range addrs {
+		conn, err := r.dial(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	// The server may have moved; look it up afresh next time
+	r.forget(host)
+	return nil, firstErr
+}
@@ -0,0 +1,111 @@
+package vpn
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDNS answers every A query with 127.0.0.1 and every other query with no
+// records, counting the queries it gets.
+func fakeDNS(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			// Header (12 bytes), then the question: name, type, class
+			q := buf[12:n]
+			end := 0
+			for end < len(q) && q[end] != 0 {
+				end += int(q[end]) + 1
+			}
+			question := q[:end+5]
+			qtype := binary.BigEndian.Uint16(question[end+1:])
+
+			resp := append([]byte(nil), buf[:12]...)
+			resp[2] = 0x81 // response, recursion desired
+			resp[3] = 0x80 // recursion available
+			binary.BigEndian.PutUint16(resp[4:], 1)
+			binary.BigEndian.PutUint16(resp[8:], 0)
+			binary.BigEndian.PutUint16(resp[10:], 0)
+			answers := uint16(0)
+			resp = append(resp, question...)
+			if qtype == 1 { // A
+				answers = 1
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			binary.BigEndian.PutUint16(resp[6:], answers)
+			pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), &queries
+}
+
+func TestResolverCaches(t *testing.T) {
+	server, queries := fakeDNS(t)
+	r := NewResolver("", []string{server})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := r.LookupHost(ctx, "news.example.com")
+	if err != nil {
+		t.Fatalf("LookupHost: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("got %v, want [127.0.0.1]", addrs)
+	}
+	n := queries.Load()
+	if n == 0 {
+		t.Fatal("the fake server was never asked")
+	}
+
+	if _, err := r.LookupHost(ctx, "news.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if queries.Load() != n {
+		t.Error("second lookup should come from the cache")
+	}
+}
+
+func TestResolverNoServers(t *testing.T) {
+	r := NewResolver("", []string{"not-an-ip", ""})
+	_, err := r.LookupHost(context.Background(), "news.example.com")
+	if !errors.Is(err, errNoTunnelDNS) {
+		t.Errorf("expected errNoTunnelDNS, got %v", err)
+	}
+	// Literal addresses need no lookup
+	if addrs, err := r.LookupHost(context.Background(), "10.0.0.1"); err != nil || addrs[0] != "10.0.0.1" {
+		t.Errorf("got %v, %v", addrs, err)
+	}
+}
+
+func TestDNSServerAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		"10.2.0.1":       "10.2.0.1:53",
+		" 10.2.0.1 ":     "10.2.0.1:53",
+		"10.2.0.1:5353":  "10.2.0.1:5353",
+		"fd00::1":        "[fd00::1]:53",
+		"[fd00::1]:5353": "[fd00::1]:5353",
+		"dns.example":    "",
+	} {
+		if got := dnsServerAddr(in); got != want {
+			t.Errorf("dnsServerAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
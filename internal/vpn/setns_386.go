@@ -0,0 +1,4 @@
+package vpn
+
+// sysSetns is setns(2)'s number; syscall lacks SYS_SETNS on 386.
+const sysSetns = 346
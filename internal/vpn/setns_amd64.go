@@ -0,0 +1,4 @@
+package vpn
+
+// sysSetns is setns(2)'s number; syscall lacks SYS_SETNS on amd64.
+const sysSetns = 308
@@ -0,0 +1,8 @@
+//go:build !amd64 && !386
+
+package vpn
+
+import "syscall"
+
+// sysSetns is setns(2)'s number.
+const sysSetns = syscall.SYS_SETNS
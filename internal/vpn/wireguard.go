@@ -32,6 +32,17 @@ func NewWireGuardConnector(cfg *config.WireGuardConfig) *WireGuardConnector {
 	}
 }
 
+// dnsServers returns the DNS servers from the WireGuard config.
+func (w *WireGuardConnector) dnsServers() []string {
+	var servers []string
+	for _, s := range strings.Split(w.cfg.DNS, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
 // Connect creates a WireGuard interface and configures it.
 func (w *WireGuardConnector) Connect(ctx context.Context) error {
 	w.mu.Lock()
@@ -67,9 +78,9 @@ func (w *WireGuardConnector) Connect(ctx context.Context) error {
 		return err
 	}
 
-	// Add address
-	if w.cfg.Address != "" {
-		if err := w.run(ctx, "ip", "addr", "add", w.cfg.Address, "dev", ifName); err != nil {
+	// Add addresses (wg-quick configs often have an IPv4 and an IPv6 one)
+	for _, addr := range splitList(w.cfg.Address) {
+		if err := w.run(ctx, "ip", "addr", "add", addr, "dev", ifName); err != nil {
 			w.teardown(ifName)
 			w.setError(fmt.Errorf("add address: %w", err))
 			return err
@@ -369,6 +380,13 @@ func (w *WireGuardConnector) teardown(ifName string) error {
 // waitForHandshake polls wg show latest-handshakes until the peer completes a
 // cryptographic handshake, confirming the tunnel is live.  Times out after 30s.
 func (w *WireGuardConnector) waitForHandshake(ctx context.Context, ifName string) error {
+	return awaitHandshake(ctx, ifName, func(ctx context.Context) (time.Time, error) {
+		return latestHandshake(ctx, ifName)
+	})
+}
+
+// awaitHandshake is waitForHandshake with the handshake read by last.
+func awaitHandshake(ctx context.Context, ifName string, last func(ctx context.Context) (time.Time, error)) error {
 	log.Printf("Waiting for WireGuard handshake on %s (timeout 30s)...", ifName)
 
 	deadline := time.NewTimer(30 * time.Second)
@@ -384,7 +402,7 @@ func (w *WireGuardConnector) waitForHandshake(ctx context.Context, ifName string
 		case <-deadline.C:
 			return fmt.Errorf("WireGuard handshake timed out after 30s — peer unreachable or keys mismatch")
 		case <-ticker.C:
-			ts, err := w.latestHandshake(ctx, ifName)
+			ts, err := last(ctx)
 			if err != nil {
 				log.Printf("WireGuard handshake check error: %v", err)
 				continue
@@ -400,9 +418,15 @@ func (w *WireGuardConnector) waitForHandshake(ctx context.Context, ifName string
 }
 
 // latestHandshake runs "wg show <ifName> latest-handshakes" and returns the
-// most recent handshake timestamp across all configured peers.
-func (w *WireGuardConnector) latestHandshake(ctx context.Context, ifName string) (time.Time, error) {
-	cmd := exec.CommandContext(ctx, resolveCmd("wg"), "show", ifName, "latest-handshakes")
+// most recent handshake timestamp across all configured peers, or the zero
+// time before the first one.
+func latestHandshake(ctx context.Context, ifName string) (time.Time, error) {
+	return latestHandshakeFrom(exec.CommandContext(ctx, resolveCmd("wg"), "show", ifName, "latest-handshakes"))
+}
+
+// latestHandshakeFrom runs cmd, a "wg show <ifName> latest-handshakes", and
+// parses its output like latestHandshake.
+func latestHandshakeFrom(cmd *exec.Cmd) (time.Time, error) {
 	out, err := cmd.Output()
 	if err != nil {
 		return time.Time{}, fmt.Errorf("wg show latest-handshakes: %w", err)
@@ -0,0 +1,346 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// netnsDir is where ip-netns(8) keeps its named network namespaces.
+var netnsDir = "/var/run/netns"
+
+// validNamespace matches the namespace names we accept: they become a file
+// name in netnsDir.
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// netnsWireGuard runs a kernel WireGuard interface in a network namespace of
+// its own. The interface is created in the host namespace, so its encrypted
+// UDP leaves over the host's routes, and then moved into the namespace,
+// where it is the only way out. Host routes and DNS are left alone;
+// connections through the tunnel are opened inside the namespace with
+// DialContext.
+type netnsWireGuard struct {
+	cfg *config.WireGuardConfig
+	wg  *WireGuardConnector // for building and applying the WireGuard config
+
+	mu       sync.RWMutex
+	status   ConnectorStatus
+	ifName   string
+	ns       *os.File  // the namespace, open while connected
+	resolver *Resolver // looks names up inside the namespace; nil without DNS servers
+}
+
+// NewNamespaceWireGuardConnector creates a connector that runs WireGuard in
+// the network namespace named by cfg.Namespace, creating it on connect and
+// deleting it on disconnect. It needs root, like managed WireGuard.
+func NewNamespaceWireGuardConnector(cfg *config.WireGuardConfig) Connector {
+	return &netnsWireGuard{
+		cfg:    cfg,
+		wg:     NewWireGuardConnector(cfg),
+		status: ConnectorStatus{State: StateDisconnected},
+	}
+}
+
+// Connect creates the namespace and the interface, moves the interface into
+// the namespace with a default route through it, and waits for the first
+// handshake.
+func (n *netnsWireGuard) Connect(ctx context.Context) error {
+	n.mu.Lock()
+	n.status = ConnectorStatus{State: StateConnecting}
+	n.mu.Unlock()
+
+	ns := n.cfg.Namespace
+	if !validNamespace.MatchString(ns) {
+		err := fmt.Errorf("invalid network namespace name %q", ns)
+		n.setError(err)
+		return err
+	}
+
+	// A namespace left over from a previous run takes its interface with it
+	if _, err := os.Stat(filepath.Join(netnsDir, ns)); err == nil {
+		log.Printf("Cleaning up stale network namespace %s", ns)
+		n.wg.run(ctx, "ip", "netns", "delete", ns)
+	}
+
+	ifName, err := n.wg.findAvailableName()
+	if err != nil {
+		n.setError(err)
+		return err
+	}
+	n.mu.Lock()
+	n.ifName = ifName
+	n.mu.Unlock()
+
+	fail := func(step string, err error) error {
+		err = fmt.Errorf("%s: %w", step, err)
+		n.teardown(ns, ifName)
+		n.mu.Lock()
+		n.ifName = ""
+		n.mu.Unlock()
+		n.setError(err)
+		return err
+	}
+
+	if err := n.wg.run(ctx, "ip", "netns", "add", ns); err != nil {
+		err = fmt.Errorf("create namespace: %w", err)
+		n.mu.Lock()
+		n.ifName = ""
+		n.mu.Unlock()
+		n.setError(err)
+		return err
+	}
+	if err := n.wg.run(ctx, "ip", "link", "add", ifName, "type", "wireguard"); err != nil {
+		return fail("create interface", err)
+	}
+	if err := n.wg.setconf(ctx, ifName); err != nil {
+		return fail("setconf", err)
+	}
+	if err := n.wg.run(ctx, "ip", "link", "set", ifName, "netns", ns); err != nil {
+		return fail("move interface into namespace", err)
+	}
+
+	ipNS := func(args ...string) error {
+		return n.wg.run(ctx, "ip", append([]string{"-n", ns}, args...)...)
+	}
+	ipv6 := false
+	for _, addr := range splitList(n.cfg.Address) {
+		if err := ipNS("addr", "add", addr, "dev", ifName); err != nil {
+			return fail("add address", err)
+		}
+		ipv6 = ipv6 || strings.Contains(addr, ":")
+	}
+	if err := ipNS("link", "set", "lo", "up"); err != nil {
+		return fail("loopback up", err)
+	}
+	if err := ipNS("link", "set", ifName, "up"); err != nil {
+		return fail("link up", err)
+	}
+	if err := ipNS("route", "add", "default", "dev", ifName); err != nil {
+		return fail("default route", err)
+	}
+	if ipv6 {
+		if err := ipNS("-6", "route", "add", "default", "dev", ifName); err != nil {
+			return fail("IPv6 default route", err)
+		}
+	}
+
+	if err := awaitHandshake(ctx, ifName, n.lastHandshake); err != nil {
+		return fail("handshake", err)
+	}
+
+	f, err := os.Open(filepath.Join(netnsDir, ns))
+	if err != nil {
+		return fail("open namespace", err)
+	}
+	n.mu.Lock()
+	n.ns = f
+	if servers := n.dnsServers(); len(servers) > 0 {
+		n.resolver = newResolverWith(n.dialIP, servers)
+	}
+	n.status = ConnectorStatus{
+		State:         StateConnected,
+		InterfaceName: ifName,
+		ConnectedAt:   time.Now(),
+	}
+	n.mu.Unlock()
+
+	log.Printf("WireGuard interface %s is up in network namespace %s", ifName, ns)
+	return nil
+}
+
+// Disconnect deletes the namespace, and the interface in it.
+func (n *netnsWireGuard) Disconnect() error {
+	n.mu.Lock()
+	ifName, f := n.ifName, n.ns
+	n.ifName, n.ns, n.resolver = "", nil, nil
+	n.status = ConnectorStatus{State: StateDisconnected}
+	n.mu.Unlock()
+
+	if f != nil {
+		f.Close()
+	}
+	if ifName == "" {
+		return nil
+	}
+	return n.teardown(n.cfg.Namespace, ifName)
+}
+
+// teardown removes the namespace, and the interface if it never got there.
+func (n *netnsWireGuard) teardown(ns, ifName string) error {
+	ctx := context.Background()
+	if _, err := net.InterfaceByName(ifName); err == nil {
+		n.wg.run(ctx, "ip", "link", "delete", ifName)
+	}
+	return n.wg.run(ctx, "ip", "netns", "delete", ns)
+}
+
+// Status returns the current connector status.
+func (n *netnsWireGuard) Status() ConnectorStatus {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.status
+}
+
+// InterfaceName returns the interface's name inside the namespace. No host
+// interface has it; connections go through DialContext.
+func (n *netnsWireGuard) InterfaceName() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.ifName
+}
+
+// dnsServers returns the DNS servers from the WireGuard config.
+func (n *netnsWireGuard) dnsServers() []string {
+	return n.wg.dnsServers()
+}
+
+// DialContext opens a connection from inside the namespace. Host names are
+// looked up there too, with the configured DNS servers; without any, the
+// system resolver is used and only the connection itself is tunneled.
+func (n *netnsWireGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.RLock()
+	resolver := n.resolver
+	n.mu.RUnlock()
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return n.dialIP(ctx, network, address)
+	}
+	if resolver != nil {
+		return resolver.DialContext(ctx, network, address)
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolving %s: no addresses", host)
+	}
+	var lastErr error
+	for _, a := range addrs {
+		conn, err := n.dialIP(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dialIP connects to address, an IP address and port, from inside the
+// namespace.
+func (n *netnsWireGuard) dialIP(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.RLock()
+	ns := n.ns
+	n.mu.RUnlock()
+	if ns == nil {
+		return nil, errors.New("WireGuard network namespace is down")
+	}
+
+	var conn net.Conn
+	var err error
+	if nsErr := inNamespace(ns, func() {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, address)
+	}); nsErr != nil {
+		return nil, nsErr
+	}
+	return conn, err
+}
+
+// linkUp reports whether the tunnel's interface is up inside the namespace.
+func (n *netnsWireGuard) linkUp() bool {
+	n.mu.RLock()
+	ns, ifName := n.ns, n.ifName
+	n.mu.RUnlock()
+	if ns == nil {
+		return false
+	}
+
+	up := false
+	inNamespace(ns, func() {
+		iface, err := net.InterfaceByName(ifName)
+		up = err == nil && iface.Flags&net.FlagUp != 0
+	})
+	return up
+}
+
+// lastHandshake returns the time of the peer's latest handshake.
+func (n *netnsWireGuard) lastHandshake(ctx context.Context) (time.Time, error) {
+	n.mu.RLock()
+	ifName := n.ifName
+	n.mu.RUnlock()
+	if ifName == "" {
+		return time.Time{}, errors.New("WireGuard network namespace is down")
+	}
+	return latestHandshakeFrom(exec.CommandContext(ctx, resolveCmd("ip"), "netns", "exec", n.cfg.Namespace,
+		resolveCmd("wg"), "show", ifName, "latest-handshakes"))
+}
+
+func (n *netnsWireGuard) setError(err error) {
+	n.mu.Lock()
+	n.status = ConnectorStatus{State: StateError, Error: err.Error()}
+	n.mu.Unlock()
+	log.Printf("WireGuard error: %v", err)
+}
+
+// inNamespace runs fn on a thread in the network namespace open as ns.
+// Sockets fn creates belong to that namespace for their lifetime. fn must
+// not start goroutines that create sockets, as those run on other threads:
+// addresses it dials must already be resolved.
+func inNamespace(ns *os.File, fn func()) error {
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is only unlocked once back in the host namespace;
+		// otherwise it exits with this goroutine rather than run others
+		runtime.LockOSThread()
+
+		host, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("open host network namespace: %w", err)
+			return
+		}
+		defer host.Close()
+
+		if err := setns(ns); err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("enter network namespace: %w", err)
+			return
+		}
+		fn()
+		if err := setns(host); err != nil {
+			log.Printf("Leaving network namespace: %v", err)
+			errc <- nil
+			return
+		}
+		runtime.UnlockOSThread()
+		errc <- nil
+	}()
+	return <-errc
+}
+
+// setns moves the calling thread into the network namespace open as f.
+func setns(f *os.File) error {
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+func TestValidNamespace(t *testing.T) {
+	for name, want := range map[string]bool{
+		"nzbconnect": true,
+		"vpn-1":      true,
+		"vpn_1.a":    true,
+		"":           false,
+		".":          false,
+		"..":         false,
+		"a/b":        false,
+		"a b":        false,
+	} {
+		if got := validNamespace.MatchString(name); got != want {
+			t.Errorf("validNamespace(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNewConnectorNamespace(t *testing.T) {
+	wg := &config.WireGuardConfig{Namespace: "nzbconnect"}
+	conn, err := newConnector(config.VPNProfile{Protocol: "wireguard", WireGuard: wg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(TunnelDialer); !ok {
+		t.Errorf("namespace connector %T doesn't dial through the tunnel", conn)
+	}
+	if _, ok := conn.(linkChecker); !ok {
+		t.Errorf("namespace connector %T can't check its link", conn)
+	}
+
+	wg.Userspace = true
+	if _, err := newConnector(config.VPNProfile{Protocol: "wireguard", WireGuard: wg}); err == nil {
+		t.Error("userspace and namespace together were accepted")
+	}
+}
+
+// TestInNamespace dials a listener from inside our own namespace, entered
+// with setns, which is as far as a test can go without creating one.
+func TestInNamespace(t *testing.T) {
+	self, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer self.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	n := &netnsWireGuard{ns: self}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := n.DialContext(ctx, "tcp", ln.Addr().String())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("setns not permitted:", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	down := &netnsWireGuard{}
+	if _, err := down.DialContext(ctx, "tcp", ln.Addr().String()); err == nil {
+		t.Error("dialed with no namespace")
+	}
+}
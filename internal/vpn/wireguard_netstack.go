@@ -0,0 +1,213 @@
+//go:build wgnetstack
+
+package vpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"nzb-connect/internal/config"
+)
+
+// userspaceWireGuard runs WireGuard in-process with wireguard-go on a gVisor
+// network stack. Nothing is created on the host: no interface, routes or DNS
+// changes, so it needs no privileges. Its only host traffic is the encrypted
+// UDP to the peer.
+type userspaceWireGuard struct {
+	cfg *config.WireGuardConfig
+
+	mu     sync.RWMutex
+	status ConnectorStatus
+	dev    *device.Device
+	tnet   *netstack.Net
+	hasDNS bool
+}
+
+// errNoUserspaceDNS is returned when dialing a host name through a userspace
+// tunnel that has no DNS servers.
+var errNoUserspaceDNS = errors.New("userspace WireGuard has no DNS server (set wireguard dns)")
+
+func newUserspaceWireGuard(cfg *config.WireGuardConfig) *userspaceWireGuard {
+	return &userspaceWireGuard{
+		cfg:    cfg,
+		status: ConnectorStatus{State: StateDisconnected},
+	}
+}
+
+// Connect starts the userspace device and waits for the first handshake.
+func (u *userspaceWireGuard) Connect(ctx context.Context) error {
+	u.mu.Lock()
+	u.status = ConnectorStatus{State: StateConnecting}
+	u.mu.Unlock()
+
+	addrs, err := tunnelAddrs(u.cfg.Address)
+	if err != nil {
+		u.setError(err)
+		return err
+	}
+	var dns []netip.Addr
+	for _, s := range strings.Split(u.cfg.DNS, ",") {
+		if a, err := netip.ParseAddr(strings.TrimSpace(s)); err == nil {
+			dns = append(dns, a)
+		}
+	}
+	uapi, err := uapiConfig(ctx, u.cfg, net.DefaultResolver.LookupHost)
+	if err != nil {
+		u.setError(err)
+		return err
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN(addrs, dns, userspaceMTU)
+	if err != nil {
+		err = fmt.Errorf("create userspace tunnel: %w", err)
+		u.setError(err)
+		return err
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelError, "wireguard: "))
+	if err := dev.IpcSet(uapi); err != nil {
+		dev.Close()
+		err = fmt.Errorf("configure userspace tunnel: %w", err)
+		u.setError(err)
+		return err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		err = fmt.Errorf("start userspace tunnel: %w", err)
+		u.setError(err)
+		return err
+	}
+
+	if err := u.waitForHandshake(ctx, dev); err != nil {
+		dev.Close()
+		u.setError(err)
+		return err
+	}
+
+	u.mu.Lock()
+	u.dev, u.tnet, u.hasDNS = dev, tnet, len(dns) > 0
+	u.status = ConnectorStatus{
+		State:         StateConnected,
+		InterfaceName: userspaceInterface,
+		ConnectedAt:   time.Now(),
+	}
+	u.mu.Unlock()
+
+	log.Println("Userspace WireGuard tunnel is up")
+	if len(dns) == 0 {
+		log.Println("WARNING: userspace WireGuard has no dns set — host names can't be resolved through the tunnel, so only IP addresses and tunnel_dns lookups with vpn.dns work")
+	}
+	return nil
+}
+
+// waitForHandshake polls the device until the peer completes a handshake,
+// like the kernel connector does. Times out after 30s.
+func (u *userspaceWireGuard) waitForHandshake(ctx context.Context, dev *device.Device) error {
+	deadline := time.NewTimer(30 * time.Second)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return fmt.Errorf("WireGuard handshake timed out after 30s — peer unreachable or keys mismatch")
+		case <-ticker.C:
+			out, err := dev.IpcGet()
+			if err != nil {
+				return fmt.Errorf("reading userspace tunnel state: %w", err)
+			}
+			if !uapiLatestHandshake(out).IsZero() {
+				return nil
+			}
+		}
+	}
+}
+
+// Disconnect stops the userspace device. Connections through it fail.
+func (u *userspaceWireGuard) Disconnect() error {
+	u.mu.Lock()
+	dev := u.dev
+	u.dev, u.tnet = nil, nil
+	u.status = ConnectorStatus{State: StateDisconnected}
+	u.mu.Unlock()
+
+	if dev != nil {
+		dev.Close()
+	}
+	return nil
+}
+
+// Status returns the current connector status.
+func (u *userspaceWireGuard) Status() ConnectorStatus {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.status
+}
+
+// InterfaceName returns userspaceInterface while connected.
+func (u *userspaceWireGuard) InterfaceName() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.dev == nil {
+		return ""
+	}
+	return userspaceInterface
+}
+
+// DialContext opens a connection through the tunnel. Host names are looked
+// up through the tunnel with the configured DNS servers. Without any, only IP
+// addresses can be dialed: a name fails rather than being looked up with the
+// system resolver outside the tunnel. tunnel_dns resolves news servers before
+// they get here, so it still works.
+func (u *userspaceWireGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	u.mu.RLock()
+	tnet, hasDNS := u.tnet, u.hasDNS
+	u.mu.RUnlock()
+	if tnet == nil {
+		return nil, fmt.Errorf("userspace WireGuard tunnel is down")
+	}
+	if !hasDNS {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("resolving %s: %w", host, errNoUserspaceDNS)
+		}
+	}
+	return tnet.DialContext(ctx, network, address)
+}
+
+// lastHandshake returns the time of the peer's latest handshake.
+func (u *userspaceWireGuard) lastHandshake(ctx context.Context) (time.Time, error) {
+	u.mu.RLock()
+	dev := u.dev
+	u.mu.RUnlock()
+	if dev == nil {
+		return time.Time{}, fmt.Errorf("userspace WireGuard tunnel is down")
+	}
+	out, err := dev.IpcGet()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading userspace tunnel state: %w", err)
+	}
+	return uapiLatestHandshake(out), nil
+}
+
+func (u *userspaceWireGuard) setError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = ConnectorStatus{State: StateError, Error: err.Error()}
+}
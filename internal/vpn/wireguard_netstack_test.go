@@ -0,0 +1,136 @@
+//go:build wgnetstack
+
+package vpn
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// freeUDPPort returns a loopback UDP port that was free a moment ago.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUserspaceWireGuardPeers(t *testing.T) {
+	var priv, pub [2]string
+	for i := range priv {
+		k, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv[i] = base64.StdEncoding.EncodeToString(k.Bytes())
+		pub[i] = base64.StdEncoding.EncodeToString(k.PublicKey().Bytes())
+	}
+	ports := [2]int{freeUDPPort(t), freeUDPPort(t)}
+
+	// Two tunnels on loopback, each the other's peer
+	var peers [2]*userspaceWireGuard
+	for i := range peers {
+		other := 1 - i
+		peers[i] = newUserspaceWireGuard(&config.WireGuardConfig{
+			PrivateKey:          priv[i],
+			Address:             "10.99.0." + strconv.Itoa(i+1) + "/24",
+			ListenPort:          ports[i],
+			PeerPublicKey:       pub[other],
+			PeerEndpoint:        "127.0.0.1:" + strconv.Itoa(ports[other]),
+			AllowedIPs:          "10.99.0." + strconv.Itoa(other+1) + "/32",
+			PersistentKeepalive: 1,
+		})
+		defer peers[i].Disconnect()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	errs := make(chan error, 2)
+	for _, p := range peers {
+		go func(p *userspaceWireGuard) { errs <- p.Connect(ctx) }(p)
+	}
+	for range peers {
+		if err := <-errs; err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+	}
+	for i, p := range peers {
+		if st := p.Status(); st.State != StateConnected || p.InterfaceName() != userspaceInterface {
+			t.Errorf("peer %d: status %+v, interface %q", i, st, p.InterfaceName())
+		}
+		if hs, err := p.lastHandshake(ctx); err != nil || hs.IsZero() {
+			t.Errorf("peer %d: lastHandshake = %v, %v", i, hs, err)
+		}
+	}
+
+	// A connection from one peer's stack reaches a listener on the other's
+	ln, err := peers[0].tnet.ListenTCPAddrPort(netip.MustParseAddrPort("10.99.0.1:8119"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := peers[1].DialContext(ctx, "tcp", "10.99.0.1:8119")
+	if err != nil {
+		t.Fatalf("DialContext through the tunnel: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+
+	// Without dns, a name must not be looked up outside the tunnel
+	if _, err := peers[1].DialContext(ctx, "tcp", "news.example.com:119"); !errors.Is(err, errNoUserspaceDNS) {
+		t.Errorf("DialContext by name without dns: %v, want errNoUserspaceDNS", err)
+	}
+
+	peers[1].Disconnect()
+	if _, err := peers[1].DialContext(ctx, "tcp", "10.99.0.1:8119"); err == nil {
+		t.Error("DialContext succeeded after Disconnect")
+	}
+	if peers[1].InterfaceName() != "" || peers[1].Status().State != StateDisconnected {
+		t.Errorf("after Disconnect: %+v", peers[1].Status())
+	}
+}
+
+func TestUserspaceWireGuardBadConfig(t *testing.T) {
+	u := newUserspaceWireGuard(&config.WireGuardConfig{
+		PrivateKey:    "not a key",
+		Address:       "10.99.0.1/24",
+		PeerPublicKey: "not a key",
+		PeerEndpoint:  "127.0.0.1:51820",
+	})
+	if err := u.Connect(context.Background()); err == nil {
+		t.Fatal("Connect succeeded with invalid keys")
+	}
+	if st := u.Status(); st.State != StateError || st.Error == "" {
+		t.Errorf("status = %+v, want an error", st)
+	}
+}
@@ -0,0 +1,141 @@
+package vpn
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// userspaceInterface is the name reported for a userspace WireGuard tunnel.
+// No such host interface exists; connections go through the connector's
+// DialContext instead.
+const userspaceInterface = "wg-userspace"
+
+// userspaceMTU is the tunnel MTU, the same default wg-quick uses.
+const userspaceMTU = 1420
+
+// TunnelDialer is implemented by connectors whose tunnel is not a host
+// network interface, such as userspace WireGuard. Binding a socket to the
+// interface name doesn't reach such a tunnel; connections must be opened
+// with DialContext.
+type TunnelDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewUserspaceWireGuardConnector creates a connector that runs WireGuard
+// in-process on a userspace network stack, needing neither root nor
+// NET_ADMIN. It is only functional in builds with the wgnetstack tag.
+func NewUserspaceWireGuardConnector(cfg *config.WireGuardConfig) Connector {
+	return newUserspaceWireGuard(cfg)
+}
+
+// tunnelAddrs parses the comma-separated WireGuard Address setting. Prefix
+// lengths are dropped: the userspace stack routes everything to the peer.
+func tunnelAddrs(s string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(a); err == nil {
+			addrs = append(addrs, p.Addr())
+			continue
+		}
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", a)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no tunnel address configured")
+	}
+	return addrs, nil
+}
+
+// uapiConfig returns cfg in the UAPI format wireguard-go is configured with:
+// hex keys, and the endpoint as an address, resolved with lookup.
+func uapiConfig(ctx context.Context, cfg *config.WireGuardConfig, lookup func(ctx context.Context, host string) ([]string, error)) (string, error) {
+	var b strings.Builder
+	key := func(name, value string) error {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil || len(raw) != 32 {
+			return fmt.Errorf("invalid %s", strings.ReplaceAll(name, "_", " "))
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, hex.EncodeToString(raw))
+		return nil
+	}
+
+	if err := key("private_key", cfg.PrivateKey); err != nil {
+		return "", err
+	}
+	if cfg.ListenPort > 0 {
+		fmt.Fprintf(&b, "listen_port=%d\n", cfg.ListenPort)
+	}
+	b.WriteString("replace_peers=true\n")
+	if err := key("public_key", cfg.PeerPublicKey); err != nil {
+		return "", err
+	}
+	if cfg.PresharedKey != "" {
+		if err := key("preshared_key", cfg.PresharedKey); err != nil {
+			return "", err
+		}
+	}
+
+	host, port, err := net.SplitHostPort(cfg.PeerEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("peer endpoint %q: %w", cfg.PeerEndpoint, err)
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("resolving peer endpoint: %w", err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("resolving peer endpoint: no addresses for %s", host)
+	}
+	fmt.Fprintf(&b, "endpoint=%s\n", net.JoinHostPort(addrs[0], port))
+
+	if cfg.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", cfg.PersistentKeepalive)
+	}
+	b.WriteString("replace_allowed_ips=true\n")
+	allowedIPs := cfg.AllowedIPs
+	if allowedIPs == "" {
+		allowedIPs = "0.0.0.0/0"
+	}
+	for _, cidr := range strings.Split(allowedIPs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", cidr)
+		}
+	}
+	return b.String(), nil
+}
+
+// uapiLatestHandshake returns the most recent peer handshake in wireguard-go's
+// UAPI "get" output, or the zero time if there has been none.
+func uapiLatestHandshake(out string) time.Time {
+	var latest time.Time
+	for _, line := range strings.Split(out, "\n") {
+		v, ok := strings.CutPrefix(line, "last_handshake_time_sec=")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || sec == 0 {
+			continue
+		}
+		if t := time.Unix(sec, 0); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
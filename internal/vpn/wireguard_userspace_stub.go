@@ -0,0 +1,58 @@
+//go:build !wgnetstack
+
+package vpn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+// errNoUserspaceWireGuard is returned when wireguard.userspace is set in a
+// build without the userspace network stack.
+var errNoUserspaceWireGuard = errors.New("userspace WireGuard is not built in (rebuild with -tags wgnetstack)")
+
+// userspaceWireGuard stands in for the userspace connector in builds without
+// it, failing every connection attempt with errNoUserspaceWireGuard.
+type userspaceWireGuard struct {
+	mu     sync.RWMutex
+	status ConnectorStatus
+}
+
+func newUserspaceWireGuard(cfg *config.WireGuardConfig) *userspaceWireGuard {
+	return &userspaceWireGuard{status: ConnectorStatus{State: StateDisconnected}}
+}
+
+func (u *userspaceWireGuard) Connect(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = ConnectorStatus{State: StateError, Error: errNoUserspaceWireGuard.Error()}
+	return errNoUserspaceWireGuard
+}
+
+func (u *userspaceWireGuard) Disconnect() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = ConnectorStatus{State: StateDisconnected}
+	return nil
+}
+
+func (u *userspaceWireGuard) Status() ConnectorStatus {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.status
+}
+
+func (u *userspaceWireGuard) InterfaceName() string { return "" }
+
+func (u *userspaceWireGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errNoUserspaceWireGuard
+}
+
+func (u *userspaceWireGuard) lastHandshake(ctx context.Context) (time.Time, error) {
+	return time.Time{}, errNoUserspaceWireGuard
+}
@@ -0,0 +1,99 @@
+package vpn
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nzb-connect/internal/config"
+)
+
+func TestUAPIConfig(t *testing.T) {
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+	cfg := &config.WireGuardConfig{
+		PrivateKey:          key(1),
+		PeerPublicKey:       key(2),
+		PeerEndpoint:        "vpn.example.com:51820",
+		AllowedIPs:          "0.0.0.0/0, ::/0",
+		PersistentKeepalive: 25,
+	}
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		if host != "vpn.example.com" {
+			t.Errorf("looked up %q", host)
+		}
+		return []string{"198.51.100.7"}, nil
+	}
+	got, err := uapiConfig(context.Background(), cfg, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "private_key=" + strings.Repeat("01", 32) + "\n" +
+		"replace_peers=true\n" +
+		"public_key=" + strings.Repeat("02", 32) + "\n" +
+		"endpoint=198.51.100.7:51820\n" +
+		"persistent_keepalive_interval=25\n" +
+		"replace_allowed_ips=true\n" +
+		"allowed_ip=0.0.0.0/0\n" +
+		"allowed_ip=::/0\n"
+	if got != want {
+		t.Errorf("uapiConfig:\n%s\nwant:\n%s", got, want)
+	}
+
+	cfg.PeerPublicKey = "not-a-key"
+	if _, err := uapiConfig(context.Background(), cfg, lookup); err == nil {
+		t.Error("expected an error for an invalid public key")
+	}
+}
+
+func TestTunnelAddrs(t *testing.T) {
+	addrs, err := tunnelAddrs("10.2.0.2/32, fd00::2/128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0].String() != "10.2.0.2" || addrs[1].String() != "fd00::2" {
+		t.Errorf("got %v", addrs)
+	}
+	if _, err := tunnelAddrs(""); err == nil {
+		t.Error("expected an error without an address")
+	}
+	if _, err := tunnelAddrs("10.2.0.300"); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestUAPILatestHandshake(t *testing.T) {
+	out := "private_key=00\npublic_key=01\nlast_handshake_time_sec=0\nlast_handshake_time_nsec=0\n" +
+		"public_key=02\nlast_handshake_time_sec=1700000000\nlast_handshake_time_nsec=5\nerrno=0\n"
+	if got := uapiLatestHandshake(out); !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("got %v", got)
+	}
+	if got := uapiLatestHandshake("last_handshake_time_sec=0\n"); !got.IsZero() {
+		t.Errorf("no handshake yet, got %v", got)
+	}
+}
+
+func TestTunnelMonitor(t *testing.T) {
+	var up atomic.Bool
+	var ups, downs int
+	m := newTunnelMonitor(userspaceInterface, up.Load)
+	m.OnUp(func() { ups++ })
+	m.OnDown(func() { downs++ })
+
+	m.checkInterface()
+	up.Store(true)
+	m.checkInterface()
+	if !m.IsUp() || ups != 1 {
+		t.Errorf("after coming up: IsUp=%v, ups=%d", m.IsUp(), ups)
+	}
+	up.Store(false)
+	m.checkInterface()
+	if m.IsUp() || downs != 1 {
+		t.Errorf("after going down: IsUp=%v, downs=%d", m.IsUp(), downs)
+	}
+}
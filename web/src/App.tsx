@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react'
-import { useQuery } from '@tanstack/react-query'
-import { fetchStatus, fetchVPNStatus } from '@/api'
+import { useQuery, useQueryClient } from '@tanstack/react-query'
+import { fetchStatus, fetchVPNStatus, fetchAuthStatus, logout, unauthorizedEvent } from '@/api'
 import { Tabs, TabsContent, TabsList, TabsTrigger } from '@/components/ui/tabs'
 import { Badge } from '@/components/ui/badge'
 import { Button } from '@/components/ui/button'
@@ -9,7 +9,10 @@ import { History } from '@/components/History'
 import { Servers } from '@/components/Servers'
 import { AddNzb } from '@/components/AddNzb'
 import { VpnPanel } from '@/components/VpnPanel'
-import { Download, Clock, Settings, Sun, Moon } from 'lucide-react'
+import { ApiKeys } from '@/components/ApiKeys'
+import { Login } from '@/components/Login'
+import { useLiveEvents, useEventsLive } from '@/events'
+import { Download, Clock, Settings, Sun, Moon, LogOut } from 'lucide-react'
 
 function formatSpeed(kbps: string): string {
   const n = Number(kbps)
@@ -38,16 +41,19 @@ function useTheme() {
   return { dark, toggle: () => setDark(d => !d) }
 }
 
-function Header({ dark, onToggleTheme }: { dark: boolean; onToggleTheme: () => void }) {
+function Header({ dark, onToggleTheme, onLogout }: { dark: boolean; onToggleTheme: () => void; onLogout?: () => void }) {
+  useLiveEvents()
+  const live = useEventsLive()
+  // The overall speed isn't pushed, so it is still polled, just less often
   const { data: status } = useQuery({
     queryKey: ['status'],
     queryFn: fetchStatus,
-    refetchInterval: 3000,
+    refetchInterval: live ? 10000 : 3000,
   })
   const { data: vpn } = useQuery({
     queryKey: ['vpn-status'],
     queryFn: fetchVPNStatus,
-    refetchInterval: 5000,
+    refetchInterval: live ? false : 5000,
   })
 
   const speed = formatSpeed(status?.status?.kbpersec ?? '0')
@@ -79,6 +85,11 @@ function Header({ dark, onToggleTheme }: { dark: boolean; onToggleTheme: () => v
           >
             {dark ? <Sun className="h-4 w-4" /> : <Moon className="h-4 w-4" />}
           </Button>
+          {onLogout && (
+            <Button variant="ghost" size="icon" className="h-8 w-8" onClick={onLogout} title="Log out">
+              <LogOut className="h-4 w-4" />
+            </Button>
+          )}
         </div>
       </div>
     </header>
@@ -87,10 +98,30 @@ function Header({ dark, onToggleTheme }: { dark: boolean; onToggleTheme: () => v
 
 export function App() {
   const { dark, toggle } = useTheme()
+  const qc = useQueryClient()
+  const { data: auth, isLoading } = useQuery({
+    queryKey: ['auth'],
+    queryFn: fetchAuthStatus,
+  })
+
+  // Recheck the session whenever a request is turned away
+  useEffect(() => {
+    const recheck = () => qc.invalidateQueries({ queryKey: ['auth'] })
+    window.addEventListener(unauthorizedEvent, recheck)
+    return () => window.removeEventListener(unauthorizedEvent, recheck)
+  }, [qc])
+
+  if (isLoading) return null
+  if (auth && !auth.authenticated) return <Login apiKey={auth.login === 'apikey'} />
+
+  async function handleLogout() {
+    await logout()
+    qc.invalidateQueries({ queryKey: ['auth'] })
+  }
 
   return (
     <div className="min-h-screen bg-background text-foreground">
-      <Header dark={dark} onToggleTheme={toggle} />
+      <Header dark={dark} onToggleTheme={toggle} onLogout={handleLogout} />
       <main className="container mx-auto px-4 py-5 max-w-5xl">
         <Tabs defaultValue="downloads">
           <TabsList className="mb-4">
@@ -118,6 +149,7 @@ export function App() {
             <div className="space-y-6">
               <Servers />
               <VpnPanel />
+              <ApiKeys />
             </div>
           </TabsContent>
         </Tabs>
@@ -9,8 +9,21 @@ export type DownloadSlot = {
   size: string
   sizeleft: string
   timeleft: string
+  kbpersec: string
   extract_pct: string
   extract_file: string
+  priority: 'Force' | 'High' | 'Normal' | 'Low'
+  index: number
+}
+
+export type QueueFile = {
+  index: number
+  filename: string
+  bytes: number
+  segments: number
+  done_segments: number
+  state: 'wanted' | 'held' | 'skipped'
+  par2_blocks: number
 }
 
 export type QueueResponse = {
@@ -63,11 +76,15 @@ export type Server = {
   username: string
   password: string
   connections: number
+  pipeline?: number
+  priority: number
   enabled: boolean
 }
 
 export type ServersResponse = {
   servers: Server[]
+  // Servers taken out of service (e.g. auth failure), keyed by name
+  errors?: Record<string, string>
 }
 
 export type VPNStatus = {
@@ -77,6 +94,51 @@ export type VPNStatus = {
   managed: boolean
   connected_at?: string
   uptime_seconds?: number
+  // Active profile and all usable ones, when more than one is configured
+  profile?: string
+  profiles?: string[]
+  // Latest result of each vpn.health probe, when any are configured
+  health?: VPNProbeResult[]
+  // Public IP checks run before downloads resume, when vpn.leak_check_url
+  // is set: the latest, and the recent ones oldest first
+  leak_check?: VPNLeakCheck
+  leak_checks?: VPNLeakCheck[]
+  // Attempts to bring a dropped tunnel back, oldest first, and when the
+  // next one is due while waiting
+  reconnects?: VPNReconnectAttempt[]
+  next_reconnect?: string
+}
+
+export type VPNReconnectAttempt = {
+  time: string
+  round: number
+  attempt: number
+  of?: number
+  profile?: string
+  ok: boolean
+  error?: string
+}
+
+export type VPNLeakCheck = {
+  time: string
+  interface: string
+  tunnel_ip?: string
+  direct_ip?: string
+  direct_error?: string
+  // unknown: the tunnel answered but there was no direct address to compare
+  result: 'passed' | 'failed' | 'unknown'
+  ok: boolean
+  error?: string
+}
+
+export type VPNProbeResult = {
+  type: string
+  target?: string
+  ok: boolean
+  error?: string
+  latency_ms: number
+  failures: number
+  checked_at?: string
 }
 
 export type VPNConfig = {
@@ -85,14 +147,61 @@ export type VPNConfig = {
   interface: string
   wireguard?: Record<string, unknown>
   openvpn?: Record<string, unknown>
+  profiles?: { name: string; protocol: string }[]
+  active_profile?: string
+}
+
+export type AuthStatus = {
+  // What the login form asks for: the web login, or the API key when none is set
+  login: 'password' | 'apikey'
+  authenticated: boolean
 }
 
+export type ApiKeys = {
+  apikey: string
+  nzbkey: string
+}
+
+// Fired when a request is rejected for want of a login, so the app can show
+// the login form.
+export const unauthorizedEvent = 'nzbconnect:unauthorized'
+
 async function apiFetch<T>(url: string, init?: RequestInit): Promise<T> {
+  // Requests are authenticated by the session cookie (see internal/api/auth.go)
   const res = await fetch(url, init)
+  if (res.status === 401) window.dispatchEvent(new Event(unauthorizedEvent))
   if (!res.ok) throw new Error(`HTTP ${res.status}: ${res.statusText}`)
   return res.json() as Promise<T>
 }
 
+export async function fetchAuthStatus(): Promise<AuthStatus> {
+  return apiFetch<AuthStatus>('/api/auth/status')
+}
+
+export async function login(username: string, password: string): Promise<{ status: boolean; error?: string }> {
+  return apiFetch('/api/auth/login', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username, password }),
+  })
+}
+
+export async function logout(): Promise<{ status: boolean }> {
+  return apiFetch('/api/auth/logout', { method: 'POST' })
+}
+
+export async function fetchApiKeys(): Promise<ApiKeys> {
+  return apiFetch<ApiKeys>('/api/auth/keys')
+}
+
+export async function rotateApiKey(key: keyof ApiKeys): Promise<{ status: boolean; error?: string }> {
+  return apiFetch('/api/auth/keys', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ key }),
+  })
+}
+
 export async function fetchQueue(): Promise<QueueResponse> {
   return apiFetch<QueueResponse>('/api?mode=queue')
 }
@@ -135,6 +244,26 @@ export async function cancelDownload(id: string): Promise<void> {
   await apiFetch(`/api/queue/${id}`, { method: 'DELETE' })
 }
 
+export async function fetchQueueFiles(id: string): Promise<{ files: QueueFile[] }> {
+  return apiFetch(`/api/queue/${id}/files`)
+}
+
+export async function setQueueFileState(id: string, index: number, state: 'wanted' | 'skipped'): Promise<{ status: boolean }> {
+  return apiFetch(`/api/queue/${id}/files/${index}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ state }),
+  })
+}
+
+export async function moveQueueItem(id: string, position: number): Promise<{ status: boolean }> {
+  return apiFetch(`/api/queue/${id}/position`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ position }),
+  })
+}
+
 export async function addServer(server: Partial<Server>): Promise<{ status: boolean; server?: Server }> {
   return apiFetch('/api/servers', {
     method: 'POST',
@@ -171,6 +300,14 @@ export async function vpnDisconnect(): Promise<{ status: boolean; error?: string
   return apiFetch('/api/vpn/disconnect', { method: 'POST' })
 }
 
+export async function switchVPNProfile(name: string): Promise<{ status: boolean; error?: string }> {
+  return apiFetch('/api/vpn/profile', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ name }),
+  })
+}
+
 export async function updateVPNConfig(config: Partial<VPNConfig>): Promise<{ status: boolean }> {
   return apiFetch('/api/vpn', {
     method: 'PUT',
@@ -0,0 +1,59 @@
+import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
+import { fetchApiKeys, rotateApiKey, type ApiKeys as Keys } from '@/api'
+import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
+import { Button } from '@/components/ui/button'
+import { Input } from '@/components/ui/input'
+import { Label } from '@/components/ui/label'
+import { Copy, RefreshCw } from 'lucide-react'
+
+const keyInfo: { key: keyof Keys; label: string; hint: string }[] = [
+  { key: 'apikey', label: 'API key', hint: 'Full access to the SABnzbd API — use this in Sonarr/Radarr.' },
+  { key: 'nzbkey', label: 'NZB key', hint: 'Can only add NZBs — for indexers and browser extensions.' },
+]
+
+export function ApiKeys() {
+  const qc = useQueryClient()
+  const { data } = useQuery({ queryKey: ['api-keys'], queryFn: fetchApiKeys })
+  const rotate = useMutation({
+    mutationFn: rotateApiKey,
+    onSuccess: () => qc.invalidateQueries({ queryKey: ['api-keys'] }),
+  })
+
+  return (
+    <Card>
+      <CardHeader className="pb-2">
+        <CardTitle>API Keys</CardTitle>
+      </CardHeader>
+      <CardContent className="space-y-4">
+        {keyInfo.map(({ key, label, hint }) => (
+          <div key={key} className="space-y-1.5">
+            <Label htmlFor={`key-${key}`}>{label}</Label>
+            <div className="flex gap-2">
+              <Input id={`key-${key}`} readOnly value={data?.[key] ?? ''} className="font-mono text-xs" />
+              <Button
+                variant="outline"
+                size="icon"
+                title="Copy"
+                onClick={() => data && navigator.clipboard.writeText(data[key])}
+              >
+                <Copy className="h-4 w-4" />
+              </Button>
+              <Button
+                variant="outline"
+                size="icon"
+                title="Generate a new key"
+                disabled={rotate.isPending}
+                onClick={() => {
+                  if (confirm(`Generate a new ${label}? Apps using the old one will stop working.`)) rotate.mutate(key)
+                }}
+              >
+                <RefreshCw className="h-4 w-4" />
+              </Button>
+            </div>
+            <p className="text-xs text-muted-foreground">{hint}</p>
+          </div>
+        ))}
+      </CardContent>
+    </Card>
+  )
+}
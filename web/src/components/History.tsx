@@ -1,6 +1,7 @@
 import { useState, useEffect } from 'react'
 import { useQuery } from '@tanstack/react-query'
 import { fetchHistory, type HistorySlot } from '@/api'
+import { useEventsLive } from '@/events'
 import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
 import { Badge } from '@/components/ui/badge'
 import { Button } from '@/components/ui/button'
@@ -39,10 +40,11 @@ function statusBadge(slot: HistorySlot) {
 export function History() {
   const [page, setPage] = useState(0)
 
+  const live = useEventsLive()
   const { data, isLoading } = useQuery({
     queryKey: ['history'],
     queryFn: fetchHistory,
-    refetchInterval: 10000,
+    refetchInterval: live ? false : 10000,
   })
 
   const allSlots = data?.history?.slots ?? []
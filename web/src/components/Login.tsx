@@ -0,0 +1,68 @@
+import { useState, type FormEvent } from 'react'
+import { useMutation, useQueryClient } from '@tanstack/react-query'
+import { login } from '@/api'
+import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
+import { Button } from '@/components/ui/button'
+import { Input } from '@/components/ui/input'
+import { Label } from '@/components/ui/label'
+import { Download, Loader2 } from 'lucide-react'
+
+// Login asks for the web login or, with apiKey, for the API key when no web
+// login is configured.
+export function Login({ apiKey }: { apiKey: boolean }) {
+  const qc = useQueryClient()
+  const [username, setUsername] = useState('')
+  const [password, setPassword] = useState('')
+  const [error, setError] = useState('')
+
+  const mutation = useMutation({
+    mutationFn: () => login(username, password),
+    onSuccess: data => {
+      if (data.status) {
+        qc.invalidateQueries()
+      } else {
+        setError(data.error ?? 'Login failed')
+        setPassword('')
+      }
+    },
+    onError: (err: Error) => setError(err.message),
+  })
+
+  function handleSubmit(e: FormEvent) {
+    e.preventDefault()
+    setError('')
+    mutation.mutate()
+  }
+
+  return (
+    <div className="min-h-screen bg-background text-foreground flex items-center justify-center px-4">
+      <Card className="w-full max-w-sm">
+        <CardHeader>
+          <CardTitle className="flex items-center gap-2">
+            <Download className="h-4 w-4 text-primary" /> NZB Connect
+          </CardTitle>
+        </CardHeader>
+        <CardContent>
+          <form onSubmit={handleSubmit} className="space-y-4">
+            {!apiKey && (
+              <div className="space-y-1.5">
+                <Label htmlFor="login-user">Username</Label>
+                <Input id="login-user" autoComplete="username" autoFocus value={username} onChange={e => setUsername(e.target.value)} />
+              </div>
+            )}
+            <div className="space-y-1.5">
+              <Label htmlFor="login-pass">{apiKey ? 'API key' : 'Password'}</Label>
+              <Input id="login-pass" type="password" autoComplete="current-password" autoFocus={apiKey} value={password} onChange={e => setPassword(e.target.value)} />
+              {apiKey && <p className="text-xs text-muted-foreground">web.api_key in config.yaml</p>}
+            </div>
+            {error && <p className="text-sm text-destructive">{error}</p>}
+            <Button type="submit" className="w-full" disabled={mutation.isPending || (apiKey ? !password : !username)}>
+              {mutation.isPending && <Loader2 className="h-4 w-4 animate-spin" />}
+              Log in
+            </Button>
+          </form>
+        </CardContent>
+      </Card>
+    </div>
+  )
+}
@@ -1,14 +1,33 @@
+import { useState } from 'react'
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
-import { fetchQueue, cancelDownload, type DownloadSlot } from '@/api'
+import { fetchQueue, cancelDownload, moveQueueItem, fetchQueueFiles, setQueueFileState, type DownloadSlot, type QueueFile } from '@/api'
+import { useEventsLive } from '@/events'
 import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
 import { Progress } from '@/components/ui/progress'
 import { Badge } from '@/components/ui/badge'
 import { Button } from '@/components/ui/button'
-import { X } from 'lucide-react'
+import { X, List, GripVertical } from 'lucide-react'
+
+function formatBytes(bytes: number): string {
+  if (bytes === 0) return '0 B'
+  const k = 1024
+  const sizes = ['B', 'KB', 'MB', 'GB', 'TB']
+  const i = Math.floor(Math.log(bytes) / Math.log(k))
+  return `${(bytes / Math.pow(k, i)).toFixed(1)} ${sizes[i]}`
+}
+
+function formatSpeed(kbps: string): string {
+  const n = Number(kbps)
+  if (n <= 0) return ''
+  if (n < 1024) return `${n.toFixed(0)} KB/s`
+  return `${(n / 1024).toFixed(1)} MB/s`
+}
+
+// Post-processing statuses, in the order a download goes through them
+const processingStatuses = ['Verifying', 'Repairing', 'Extracting']
 
 function statusBadge(slot: DownloadSlot) {
-  const isExtracting = slot.status === 'Extracting' || (slot.status === 'Extracting' && Number(slot.extract_pct) > 0)
-  if (isExtracting) return <Badge variant="purple">Extracting</Badge>
+  if (processingStatuses.includes(slot.status)) return <Badge variant="purple">{slot.status}</Badge>
   switch (slot.status) {
     case 'Downloading': return <Badge variant="default">Downloading</Badge>
     case 'Queued':      return <Badge variant="secondary">Queued</Badge>
@@ -16,20 +35,118 @@ function statusBadge(slot: DownloadSlot) {
   }
 }
 
-function QueueSlot({ slot, onCancel }: { slot: DownloadSlot; onCancel: (id: string) => void }) {
+function priorityBadge(slot: DownloadSlot) {
+  switch (slot.priority) {
+    case 'Force': return <Badge variant="destructive">Force</Badge>
+    case 'High':  return <Badge variant="warning">High</Badge>
+    case 'Low':   return <Badge variant="outline">Low</Badge>
+    default:      return null
+  }
+}
+
+function FileRow({ file, onSetState }: { file: QueueFile; onSetState: (state: 'wanted' | 'skipped') => void }) {
+  const pct = file.segments > 0 ? Math.round(file.done_segments / file.segments * 100) : 0
+  const done = file.done_segments === file.segments
+  return (
+    <div className="flex items-center justify-between gap-2 text-xs py-1">
+      <span className={`truncate ${file.state === 'skipped' ? 'line-through text-muted-foreground' : ''}`} title={file.filename}>
+        {file.filename}
+      </span>
+      <div className="flex items-center gap-2 shrink-0 text-muted-foreground">
+        {file.par2_blocks > 0 && <span>{file.par2_blocks} blk</span>}
+        <span>{formatBytes(file.bytes)}</span>
+        {file.state === 'wanted' && <span>{pct}%</span>}
+        {!done && file.state !== 'skipped' && (
+          <Button variant="ghost" size="sm" className="h-6 px-2 text-xs" onClick={() => onSetState('skipped')}>Skip</Button>
+        )}
+        {file.state !== 'wanted' && (
+          <Button variant="ghost" size="sm" className="h-6 px-2 text-xs" onClick={() => onSetState('wanted')}>Download</Button>
+        )}
+      </div>
+    </div>
+  )
+}
+
+function QueueFiles({ id }: { id: string }) {
+  const qc = useQueryClient()
+  const { data, isLoading } = useQuery({
+    queryKey: ['queue-files', id],
+    queryFn: () => fetchQueueFiles(id),
+    refetchInterval: 5000,
+  })
+  const setState = useMutation({
+    mutationFn: ({ index, state }: { index: number; state: 'wanted' | 'skipped' }) => setQueueFileState(id, index, state),
+    onSuccess: () => qc.invalidateQueries({ queryKey: ['queue-files', id] }),
+  })
+
+  if (isLoading) return <p className="text-xs text-muted-foreground py-2">Loading files…</p>
+  const files = data?.files ?? []
+  // Recovery volumes are held back until a repair needs them
+  const held = files.filter(f => f.state === 'held')
+  const rest = files.filter(f => f.state !== 'held')
+  const heldBlocks = held.reduce((n, f) => n + f.par2_blocks, 0)
+
+  return (
+    <div className="mt-2 rounded-md border px-3 py-1">
+      {rest.map(f => (
+        <FileRow key={f.index} file={f} onSetState={state => setState.mutate({ index: f.index, state })} />
+      ))}
+      {held.length > 0 && (
+        <>
+          <p className="text-xs font-medium text-muted-foreground pt-2 pb-1 border-t mt-1">
+            Recovery volumes held back · {heldBlocks} blocks · fetched only if repair needs them
+          </p>
+          {held.map(f => (
+            <FileRow key={f.index} file={f} onSetState={state => setState.mutate({ index: f.index, state })} />
+          ))}
+        </>
+      )}
+    </div>
+  )
+}
+
+type DragProps = {
+  dragging: boolean
+  onDragStart: () => void
+  onDragOver: () => void
+  onDrop: () => void
+  onDragEnd: () => void
+}
+
+function QueueSlot({ slot, onCancel, drag }: { slot: DownloadSlot; onCancel: (id: string) => void; drag: DragProps }) {
   const pct = Number(slot.percentage)
   const extractPct = Number(slot.extract_pct)
-  const isExtracting = slot.status === 'Extracting'
+  const isProcessing = processingStatuses.includes(slot.status)
+  const speed = formatSpeed(slot.kbpersec)
+  const [showFiles, setShowFiles] = useState(false)
 
   return (
-    <div className="py-4 border-b last:border-0">
+    <div
+      className={`py-4 border-b last:border-0 ${drag.dragging ? 'opacity-50' : ''}`}
+      draggable
+      onDragStart={e => { e.dataTransfer.effectAllowed = 'move'; drag.onDragStart() }}
+      onDragOver={e => { e.preventDefault(); drag.onDragOver() }}
+      onDrop={e => { e.preventDefault(); drag.onDrop() }}
+      onDragEnd={drag.onDragEnd}
+    >
       <div className="flex items-start justify-between gap-2 mb-2">
+        <GripVertical className="h-4 w-4 mt-0.5 shrink-0 cursor-grab text-muted-foreground" />
         <div className="flex-1 min-w-0">
           <p className="font-medium text-sm truncate" title={slot.filename}>{slot.filename}</p>
           {slot.cat && <p className="text-xs text-muted-foreground">{slot.cat}</p>}
         </div>
         <div className="flex items-center gap-2 shrink-0">
+          {priorityBadge(slot)}
           {statusBadge(slot)}
+          <Button
+            variant="ghost"
+            size="icon"
+            className="h-7 w-7 text-muted-foreground"
+            onClick={() => setShowFiles(v => !v)}
+            title={showFiles ? 'Hide files' : 'Show files'}
+          >
+            <List className="h-4 w-4" />
+          </Button>
           <Button
             variant="ghost"
             size="icon"
@@ -42,7 +159,7 @@ function QueueSlot({ slot, onCancel }: { slot: DownloadSlot; onCancel: (id: stri
         </div>
       </div>
 
-      {isExtracting ? (
+      {isProcessing ? (
         <div className="space-y-1">
           <Progress
             value={extractPct}
@@ -51,7 +168,7 @@ function QueueSlot({ slot, onCancel }: { slot: DownloadSlot; onCancel: (id: stri
           />
           <div className="flex justify-between text-xs text-muted-foreground">
             <span className="truncate max-w-[70%]" title={slot.extract_file}>
-              {slot.extract_file ? `Extracting: ${slot.extract_file}` : 'Extracting…'}
+              {slot.extract_file ? `${slot.status}: ${slot.extract_file}` : `${slot.status}…`}
             </span>
             <span>{extractPct}%</span>
           </div>
@@ -60,21 +177,26 @@ function QueueSlot({ slot, onCancel }: { slot: DownloadSlot; onCancel: (id: stri
         <div className="space-y-1">
           <Progress value={pct} className="h-2" />
           <div className="flex justify-between text-xs text-muted-foreground">
-            <span>{slot.sizeleft} remaining of {slot.size}</span>
+            <span>
+              {slot.sizeleft} remaining of {slot.size}
+              {speed && <span className="tabular-nums"> · {speed} · {slot.timeleft} left</span>}
+            </span>
             <span>{pct}%</span>
           </div>
         </div>
       )}
+      {showFiles && <QueueFiles id={slot.nzo_id} />}
     </div>
   )
 }
 
 export function Queue() {
   const qc = useQueryClient()
+  const live = useEventsLive()
   const { data, isLoading } = useQuery({
     queryKey: ['queue'],
     queryFn: fetchQueue,
-    refetchInterval: 2000,
+    refetchInterval: live ? false : 2000,
   })
 
   const cancel = useMutation({
@@ -82,6 +204,15 @@ export function Queue() {
     onSuccess: () => qc.invalidateQueries({ queryKey: ['queue'] }),
   })
 
+  const move = useMutation({
+    mutationFn: ({ id, position }: { id: string; position: number }) => moveQueueItem(id, position),
+    onSuccess: () => qc.invalidateQueries({ queryKey: ['queue'] }),
+  })
+
+  // Drag-and-drop reordering: dropping a slot moves it to the target's position
+  const [dragId, setDragId] = useState<string | null>(null)
+  const [overIndex, setOverIndex] = useState<number | null>(null)
+
   const slots = data?.queue?.slots ?? []
   const isPaused = data?.queue?.paused ?? false
 
@@ -107,6 +238,19 @@ export function Queue() {
               key={slot.nzo_id}
               slot={slot}
               onCancel={id => cancel.mutate(id)}
+              drag={{
+                dragging: dragId === slot.nzo_id,
+                onDragStart: () => setDragId(slot.nzo_id),
+                onDragOver: () => setOverIndex(slot.index),
+                onDrop: () => {
+                  if (dragId && dragId !== slot.nzo_id && overIndex !== null) {
+                    move.mutate({ id: dragId, position: overIndex })
+                  }
+                  setDragId(null)
+                  setOverIndex(null)
+                },
+                onDragEnd: () => { setDragId(null); setOverIndex(null) },
+              }}
             />
           ))
         )}
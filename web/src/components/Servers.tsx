@@ -16,7 +16,7 @@ type FormState = Omit<Server, 'id'> & { id?: string }
 
 const emptyForm = (): FormState => ({
   name: '', host: '', port: 563, ssl: true, username: '', password: '',
-  connections: 20, enabled: true,
+  connections: 20, pipeline: 2, priority: 0, enabled: true,
 })
 
 function ServerForm({
@@ -69,6 +69,14 @@ function ServerForm({
           <Label htmlFor="srv-conns">Connections</Label>
           <Input id="srv-conns" type="number" min={1} max={50} value={form.connections} onChange={e => set('connections', Number(e.target.value))} />
         </div>
+        <div className="space-y-1.5">
+          <Label htmlFor="srv-pipeline">Pipelined requests</Label>
+          <Input id="srv-pipeline" type="number" min={1} max={20} value={form.pipeline ?? 2} onChange={e => set('pipeline', Number(e.target.value))} />
+        </div>
+        <div className="space-y-1.5">
+          <Label htmlFor="srv-priority">Priority (0 = primary)</Label>
+          <Input id="srv-priority" type="number" min={0} value={form.priority} onChange={e => set('priority', Number(e.target.value))} />
+        </div>
         <div className="col-span-2 space-y-1.5">
           <Label htmlFor="srv-user">Username</Label>
           <Input id="srv-user" value={form.username} onChange={e => set('username', e.target.value)} />
@@ -134,6 +142,7 @@ export function Servers() {
   }
 
   const servers = data?.servers ?? []
+  const serverErrors = data?.errors ?? {}
 
   return (
     <Card>
@@ -168,7 +177,11 @@ export function Servers() {
                   <div className="flex items-center gap-2">
                     <p className="font-medium text-sm">{srv.name || srv.host}</p>
                     {srv.ssl && <Badge variant="outline" className="text-xs">SSL</Badge>}
+                    {srv.priority > 0 && <Badge variant="outline" className="text-xs">Backup · {srv.priority}</Badge>}
                     {!srv.enabled && <Badge variant="secondary" className="text-xs">Disabled</Badge>}
+                    {serverErrors[srv.name] && (
+                      <Badge variant="destructive" className="text-xs" title={serverErrors[srv.name]}>Error</Badge>
+                    )}
                   </div>
                   <p className="text-xs text-muted-foreground">
                     {srv.host}:{srv.port} · {srv.connections} conn{srv.connections !== 1 ? 's' : ''}
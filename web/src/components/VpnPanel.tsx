@@ -1,8 +1,10 @@
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
-import { fetchVPNStatus, fetchVPNConfig, vpnConnect, vpnDisconnect } from '@/api'
+import { fetchVPNStatus, fetchVPNConfig, vpnConnect, vpnDisconnect, switchVPNProfile } from '@/api'
+import { useEventsLive } from '@/events'
 import { Card, CardContent, CardHeader, CardTitle } from '@/components/ui/card'
 import { Button } from '@/components/ui/button'
 import { Badge } from '@/components/ui/badge'
+import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from '@/components/ui/select'
 import { ShieldCheck, ShieldOff, Loader2 } from 'lucide-react'
 
 function stateBadge(state: string) {
@@ -27,10 +29,12 @@ function formatUptime(seconds?: number): string {
 export function VpnPanel() {
   const qc = useQueryClient()
 
+  const live = useEventsLive()
+  // State changes are pushed; polling while live just keeps the uptime current
   const { data: status, isLoading } = useQuery({
     queryKey: ['vpn-status'],
     queryFn: fetchVPNStatus,
-    refetchInterval: 5000,
+    refetchInterval: live ? 30000 : 5000,
   })
 
   const { data: config } = useQuery({
@@ -46,10 +50,19 @@ export function VpnPanel() {
     mutationFn: vpnDisconnect,
     onSettled: () => qc.invalidateQueries({ queryKey: ['vpn-status'] }),
   })
+  const switchProfile = useMutation({
+    mutationFn: switchVPNProfile,
+    onSettled: () => {
+      qc.invalidateQueries({ queryKey: ['vpn-status'] })
+      qc.invalidateQueries({ queryKey: ['vpn-config'] })
+    },
+  })
 
   const isConnected = status?.state === 'connected'
   const isConnecting = status?.state === 'connecting'
   const isManaged = status?.managed ?? false
+  const profiles = status?.profiles ?? []
+  const activeProtocol = config?.profiles?.find((p) => p.name === status?.profile)?.protocol ?? config?.protocol
 
   return (
     <Card>
@@ -114,7 +127,13 @@ export function VpnPanel() {
                 <p className="text-xs text-muted-foreground font-medium uppercase tracking-wide">Configuration</p>
                 <div className="grid grid-cols-2 gap-1 text-sm">
                   <span className="text-muted-foreground">Mode</span>
-                  <span>{config.protocol ? config.protocol : 'Bind-only'}</span>
+                  <span>{activeProtocol ? activeProtocol : 'Bind-only'}</span>
+                  {status?.profile && (
+                    <>
+                      <span className="text-muted-foreground">Profile</span>
+                      <span>{status.profile}</span>
+                    </>
+                  )}
                   {config.interface && (
                     <>
                       <span className="text-muted-foreground">Interface</span>
@@ -125,6 +144,111 @@ export function VpnPanel() {
               </div>
             )}
 
+            {status?.leak_check && (
+              <div className="space-y-1">
+                <div className="flex items-center justify-between">
+                  <p className="text-xs text-muted-foreground font-medium uppercase tracking-wide">Leak check</p>
+                  {status.leak_check.result === 'passed' && <Badge variant="success">Passed</Badge>}
+                  {status.leak_check.result === 'unknown' && <Badge variant="secondary">Unknown</Badge>}
+                  {status.leak_check.result === 'failed' && <Badge variant="destructive">Failed</Badge>}
+                </div>
+                <div className="grid grid-cols-2 gap-1 text-sm">
+                  <span className="text-muted-foreground">Tunnel IP</span>
+                  <span>{status.leak_check.tunnel_ip || '—'}</span>
+                  <span className="text-muted-foreground">Default route IP</span>
+                  <span title={status.leak_check.direct_error}>{status.leak_check.direct_ip || 'unreachable'}</span>
+                </div>
+                {status.leak_check.error && (
+                  <p className="text-sm text-destructive">{status.leak_check.error}</p>
+                )}
+                {status.leak_checks && status.leak_checks.length > 1 && (
+                  <details className="text-sm">
+                    <summary className="cursor-pointer text-muted-foreground">History</summary>
+                    <ul className="mt-1 space-y-0.5">
+                      {[...status.leak_checks].reverse().map((check) => (
+                        <li key={check.time} className="flex justify-between gap-2" title={check.error}>
+                          <span className="text-muted-foreground">{new Date(check.time).toLocaleString()}</span>
+                          <span className={check.result === 'failed' ? 'text-destructive' : ''}>
+                            {check.result === 'failed' ? 'failed' : check.tunnel_ip}
+                            {check.result === 'unknown' && ' (unknown)'}
+                          </span>
+                        </li>
+                      ))}
+                    </ul>
+                  </details>
+                )}
+              </div>
+            )}
+
+            {status && (status.next_reconnect || (status.reconnects?.length ?? 0) > 0) && (
+              <div className="space-y-1">
+                <p className="text-xs text-muted-foreground font-medium uppercase tracking-wide">Reconnects</p>
+                {status.next_reconnect && (
+                  <p className="text-sm">Next attempt at {new Date(status.next_reconnect).toLocaleTimeString()}</p>
+                )}
+                {status.reconnects && status.reconnects.length > 0 && (
+                  <details className="text-sm">
+                    <summary className="cursor-pointer text-muted-foreground">History</summary>
+                    <ul className="mt-1 space-y-0.5">
+                      {[...status.reconnects].reverse().map((a) => (
+                        <li key={a.time} className="flex justify-between gap-2" title={a.error}>
+                          <span className="text-muted-foreground">
+                            {new Date(a.time).toLocaleString()} · {a.of ? `${a.attempt}/${a.of}` : `#${a.attempt}`}
+                            {a.profile && ` · ${a.profile}`}
+                          </span>
+                          <span className={a.ok ? '' : 'text-destructive'}>{a.ok ? 'connected' : 'failed'}</span>
+                        </li>
+                      ))}
+                    </ul>
+                  </details>
+                )}
+              </div>
+            )}
+
+            {status?.health && status.health.length > 0 && (
+              <div className="space-y-1">
+                <p className="text-xs text-muted-foreground font-medium uppercase tracking-wide">Health checks</p>
+                {status.health.map((probe, i) => (
+                  <div key={i} className="flex items-center justify-between gap-2 text-sm">
+                    <span className="truncate">
+                      {probe.type}
+                      {probe.target && <span className="text-muted-foreground"> {probe.target}</span>}
+                    </span>
+                    {!probe.checked_at ? (
+                      <Badge variant="outline">Pending</Badge>
+                    ) : probe.ok ? (
+                      <Badge variant="success">{probe.latency_ms} ms</Badge>
+                    ) : (
+                      <Badge variant="destructive" title={probe.error}>Failed ×{probe.failures}</Badge>
+                    )}
+                  </div>
+                ))}
+              </div>
+            )}
+
+            {profiles.length > 1 && (
+              <div className="space-y-1">
+                <p className="text-xs text-muted-foreground font-medium uppercase tracking-wide">Switch profile</p>
+                <Select
+                  value={status?.profile}
+                  onValueChange={(name) => switchProfile.mutate(name)}
+                  disabled={switchProfile.isPending}
+                >
+                  <SelectTrigger className="h-9">
+                    <SelectValue />
+                  </SelectTrigger>
+                  <SelectContent>
+                    {profiles.map((name) => (
+                      <SelectItem key={name} value={name}>{name}</SelectItem>
+                    ))}
+                  </SelectContent>
+                </Select>
+                {switchProfile.data?.error && (
+                  <p className="text-sm text-destructive">{switchProfile.data.error}</p>
+                )}
+              </div>
+            )}
+
             {!isManaged && (
               <p className="text-sm text-muted-foreground">
                 Bind-only mode — manage your VPN connection externally.
@@ -0,0 +1,134 @@
+import { useEffect, useSyncExternalStore } from 'react'
+import { useQueryClient, type QueryClient } from '@tanstack/react-query'
+import type { QueueResponse, DownloadSlot } from '@/api'
+
+// Live updates from /api/events (see internal/api/events.go). While the
+// stream is connected, progress is patched straight into the cached queue and
+// everything else refetches only when the server says it changed; components
+// fall back to polling while it isn't.
+
+type ProgressEvent = {
+  id: string
+  downloaded_bytes: number
+  total_bytes: number
+  done_segments: number
+  total_segments: number
+  speed: number
+}
+
+type PostProcessEvent = {
+  id: string
+  stage?: string
+  pct?: number
+  file?: string
+}
+
+let live = false
+const listeners = new Set<() => void>()
+
+function setLive(value: boolean) {
+  if (live === value) return
+  live = value
+  listeners.forEach(fn => fn())
+}
+
+// useEventsLive reports whether the event stream is connected.
+export function useEventsLive(): boolean {
+  return useSyncExternalStore(
+    fn => { listeners.add(fn); return () => { listeners.delete(fn) } },
+    () => live,
+  )
+}
+
+// Same format as nzb.FormatSize on the server
+function formatSize(bytes: number): string {
+  if (bytes >= 1024 ** 3) return `${(bytes / 1024 ** 3).toFixed(2)} GB`
+  if (bytes >= 1024 ** 2) return `${(bytes / 1024 ** 2).toFixed(2)} MB`
+  if (bytes >= 1024) return `${(bytes / 1024).toFixed(2)} KB`
+  return `${bytes} B`
+}
+
+function formatTimeLeft(remaining: number, speed: number): string {
+  if (speed <= 0) return 'unknown'
+  const secs = Math.floor(remaining / speed)
+  const pad = (n: number) => String(n).padStart(2, '0')
+  return `${Math.floor(secs / 3600)}:${pad(Math.floor(secs / 60) % 60)}:${pad(secs % 60)}`
+}
+
+function patchSlot(qc: QueryClient, id: string, patch: (slot: DownloadSlot) => Partial<DownloadSlot>) {
+  qc.setQueryData<QueueResponse>(['queue'], old => old && {
+    ...old,
+    queue: {
+      ...old.queue,
+      slots: old.queue.slots.map(s => (s.nzo_id === id ? { ...s, ...patch(s) } : s)),
+    },
+  })
+}
+
+function resync(qc: QueryClient) {
+  for (const key of ['queue', 'history', 'status', 'vpn-status']) {
+    qc.invalidateQueries({ queryKey: [key] })
+  }
+}
+
+// useLiveEvents connects to the event stream for as long as the calling
+// component is mounted.
+export function useLiveEvents() {
+  const qc = useQueryClient()
+
+  useEffect(() => {
+    const source = new EventSource('/api/events')
+
+    // Whatever happened while we weren't listening is reloaded
+    source.onopen = () => { setLive(true); resync(qc) }
+    // EventSource reconnects by itself
+    source.onerror = () => setLive(false)
+
+    source.addEventListener('queue', e => {
+      const { status } = JSON.parse((e as MessageEvent).data) as { id: string; status: string }
+      qc.invalidateQueries({ queryKey: ['queue'] })
+      if (['completed', 'failed', 'deleted', 'queued'].includes(status)) {
+        qc.invalidateQueries({ queryKey: ['history'] })
+      }
+    })
+
+    source.addEventListener('progress', e => {
+      const p = JSON.parse((e as MessageEvent).data) as ProgressEvent
+      const left = Math.max(p.total_bytes - p.downloaded_bytes, 0)
+      patchSlot(qc, p.id, () => ({
+        percentage: p.total_segments > 0 ? (p.done_segments / p.total_segments * 100).toFixed(0) : '0',
+        mbleft: (left / 1024 / 1024).toFixed(2),
+        sizeleft: formatSize(left),
+        kbpersec: (p.speed / 1024).toFixed(2),
+        timeleft: formatTimeLeft(left, p.speed),
+      }))
+    })
+
+    source.addEventListener('postprocess', e => {
+      const p = JSON.parse((e as MessageEvent).data) as PostProcessEvent
+      if (p.stage) {
+        // The status label comes from the server
+        qc.invalidateQueries({ queryKey: ['queue'] })
+        return
+      }
+      patchSlot(qc, p.id, () => ({
+        extract_pct: (p.pct ?? 0).toFixed(0),
+        extract_file: p.file ?? '',
+      }))
+    })
+
+    source.addEventListener('status', () => {
+      qc.invalidateQueries({ queryKey: ['status'] })
+      qc.invalidateQueries({ queryKey: ['queue'] })
+    })
+
+    source.addEventListener('vpn', () => {
+      qc.invalidateQueries({ queryKey: ['vpn-status'] })
+    })
+
+    return () => {
+      source.close()
+      setLive(false)
+    }
+  }, [qc])
+}